@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/aibotsoft/crypto-surebet/pkg/capture"
+	"github.com/aibotsoft/crypto-surebet/pkg/config"
+	"github.com/aibotsoft/crypto-surebet/pkg/store"
+	"github.com/aibotsoft/crypto-surebet/services/backtest"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"os"
+	"time"
+)
+
+// replayFlags are shared by the commands running the backtest engine.
+type replayFlags struct {
+	from       *string
+	to         *string
+	quote      *float64
+	coin       *float64
+	maker      *float64
+	taker      *float64
+	captureDir *string
+}
+
+func addReplayFlags(fs *flag.FlagSet) *replayFlags {
+	return &replayFlags{
+		from:       fs.String("from", time.Now().Add(-24*time.Hour).Format(time.RFC3339), "replay surebets from, RFC3339"),
+		to:         fs.String("to", time.Now().Format(time.RFC3339), "replay surebets to, RFC3339"),
+		quote:      fs.Float64("quote", 10000, "starting balance of every quote currency"),
+		coin:       fs.Float64("coin", 500, "starting usd value of every base coin"),
+		maker:      fs.Float64("maker", 0.0002, "maker fee rate"),
+		taker:      fs.Float64("taker", 0.0007, "taker fee rate"),
+		captureDir: fs.String("capture", "", "replay capture files from dir instead of the surebets table"),
+	}
+}
+
+// open returns the source and engine options, close must be called when the source is done.
+func (rf *replayFlags) open(ctx context.Context, cfg *config.Config, log *zap.Logger) (src backtest.Source, opt backtest.Options, closeFn func(), err error) {
+	fromTime, err := time.Parse(time.RFC3339, *rf.from)
+	if err != nil {
+		return nil, opt, nil, fmt.Errorf("parse_from_error: %w", err)
+	}
+	toTime, err := time.Parse(time.RFC3339, *rf.to)
+	if err != nil {
+		return nil, opt, nil, fmt.Errorf("parse_to_error: %w", err)
+	}
+	sto, err := store.NewStore(cfg, log, ctx)
+	if err != nil {
+		return nil, opt, nil, err
+	}
+	markets, err := sto.SelectMarkets()
+	if err != nil {
+		_ = sto.Close()
+		return nil, opt, nil, err
+	}
+	opt = backtest.Options{
+		Markets:      markets,
+		MakerFee:     decimal.NewFromFloat(*rf.maker),
+		TakerFee:     decimal.NewFromFloat(*rf.taker),
+		QuoteBalance: decimal.NewFromFloat(*rf.quote),
+		CoinBalance:  decimal.NewFromFloat(*rf.coin),
+	}
+	if *rf.captureDir == "" {
+		return backtest.NewStoreSource(sto, fromTime, toTime), opt, func() { _ = sto.Close() }, nil
+	}
+	reader, err := capture.NewReader(*rf.captureDir, fromTime, toTime)
+	if err != nil {
+		_ = sto.Close()
+		return nil, opt, nil, err
+	}
+	return backtest.NewCaptureSource(reader), opt, func() {
+		reader.Close()
+		_ = sto.Close()
+	}, nil
+}
+
+func runBacktest(cfg *config.Config, log *zap.Logger, args []string) error {
+	fs := flag.NewFlagSet("backtest", flag.ContinueOnError)
+	rf := addReplayFlags(fs)
+	asJSON := fs.Bool("json", false, "print report as json")
+	verbose := fs.Bool("v", false, "keep placer logs")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src, opt, closeSrc, err := rf.open(ctx, cfg, log)
+	if err != nil {
+		return err
+	}
+	defer closeSrc()
+	placerLog := log
+	if !*verbose {
+		placerLog = zap.NewNop()
+	}
+	start := time.Now()
+	rep, err := backtest.NewEngine(cfg, placerLog, opt).Run(ctx, src)
+	if err != nil {
+		return err
+	}
+	log.Info("backtest_done", zap.Time("from", rep.From), zap.Time("to", rep.To), zap.Duration("elapsed", time.Since(start)))
+	if *asJSON {
+		return rep.JSON(os.Stdout)
+	}
+	return rep.Print(os.Stdout)
+}
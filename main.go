@@ -2,13 +2,18 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"github.com/aibotsoft/crypto-surebet/pkg/capture"
 	"github.com/aibotsoft/crypto-surebet/pkg/config"
 	"github.com/aibotsoft/crypto-surebet/pkg/logger"
 	"github.com/aibotsoft/crypto-surebet/pkg/signals"
 	"github.com/aibotsoft/crypto-surebet/pkg/store"
+	"github.com/aibotsoft/crypto-surebet/pkg/venue"
 	"github.com/aibotsoft/crypto-surebet/pkg/version"
 	"github.com/aibotsoft/crypto-surebet/services/placer"
 	"go.uber.org/zap"
+	"os"
+	"strings"
 )
 
 func main() {
@@ -17,6 +22,15 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		err = runCommand(cfg, log, os.Args[1], os.Args[2:])
+		_ = log.Sync()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 	log.Info("start_service", zap.String("version", version.Version), zap.String("build_date", version.BuildDate), zap.Any("config", cfg))
 	ctx, cancel := context.WithCancel(context.Background())
 	sto, err := store.NewStore(cfg, log, ctx)
@@ -27,24 +41,60 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	p, err := placer.NewPlacer(cfg, log, ctx, sto)
+	var v venue.Venue = venue.NewFtx(cfg, log)
+	if cfg.Service.PaperMode {
+		v = venue.NewPaper(log, v)
+	}
+	p, err := placer.NewPlacer(cfg, log, ctx, sto, v)
 	if err != nil {
 		panic(err)
 	}
-	errCh := make(chan error)
+	var rec *capture.Recorder
+	if cfg.Capture.Enabled {
+		rec, err = capture.NewRecorder(cfg, log)
+		if err != nil {
+			panic(err)
+		}
+		p.SetRecorder(rec)
+	}
+	errCh := make(chan error, 1)
 	go func() {
 		errCh <- p.Run()
 	}()
+	if cfg.Http.Enabled {
+		go func() {
+			err := p.ListenHTTP(ctx)
+			if err != nil {
+				log.Error("http_server_error", zap.Error(err))
+			}
+		}()
+	}
 	defer func() {
 		log.Info("closing_services...")
+		p.Shutdown(cfg.Shutdown.Timeout)
 		cancel()
+		if rec != nil {
+			rec.Close()
+		}
 		err2 := sto.Close()
 		if err2 != nil {
-			log.Warn("close_db_error", zap.Error(err))
+			log.Warn("close_db_error", zap.Error(err2))
 		}
 		_ = log.Sync()
 	}()
 	stopCh := signals.SetupSignalHandler()
+	reloadCh := signals.SetupReloadHandler()
+	go func() {
+		for range reloadCh {
+			_ = p.ReloadConfig("sighup")
+		}
+	}()
+	killCh := signals.SetupKillHandler()
+	go func() {
+		for sig := range killCh {
+			_, _ = p.Kill(placer.KillSourceSignal, sig.String(), cfg.Kill.KeepHeals)
+		}
+	}()
 	select {
 	case err := <-errCh:
 		log.Error("stop_service_by_error", zap.Error(err))
@@ -52,3 +102,18 @@ func main() {
 		log.Info("stop_service_by_os_signal", zap.String("signal", sig.String()))
 	}
 }
+
+func runCommand(cfg *config.Config, log *zap.Logger, name string, args []string) error {
+	switch name {
+	case "backtest":
+		return runBacktest(cfg, log, args)
+	case "optimize":
+		return runOptimize(cfg, log, args)
+	case "report":
+		return runReport(cfg, log, args)
+	case "migrate":
+		return runMigrate(cfg, log, args)
+	default:
+		return fmt.Errorf("unknown_command: %s", name)
+	}
+}
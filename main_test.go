@@ -6,6 +6,7 @@ import (
 	"github.com/aibotsoft/crypto-surebet/pkg/config"
 	"github.com/aibotsoft/crypto-surebet/pkg/logger"
 	"github.com/aibotsoft/crypto-surebet/pkg/store"
+	"github.com/aibotsoft/crypto-surebet/pkg/venue"
 	"github.com/aibotsoft/crypto-surebet/services/placer"
 	"testing"
 )
@@ -28,7 +29,7 @@ func TestMain(m *testing.M) {
 	if err != nil {
 		panic(err)
 	}
-	p, err = placer.NewPlacer(cfg, log, ctx, sto)
+	p, err = placer.NewPlacer(cfg, log, ctx, sto, venue.NewFtx(cfg, log))
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/aibotsoft/crypto-surebet/pkg/config"
+	"github.com/aibotsoft/crypto-surebet/pkg/store"
+	"go.uber.org/zap"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+func runMigrate(cfg *config.Config, log *zap.Logger, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	to := fs.Int64("to", 0, "up: apply up to this version, 0 applies all")
+	steps := fs.Int("n", 1, "down: number of migrations to revert")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	action := "status"
+	if fs.NArg() > 0 {
+		action = fs.Arg(0)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sto, err := store.NewStore(cfg, log, ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = sto.Close() }()
+	switch action {
+	case "status":
+	case "up":
+		n, err := sto.MigrateUp(*to)
+		log.Info("migrate_up_done", zap.Int("applied", n))
+		if err != nil {
+			return err
+		}
+	case "down":
+		n, err := sto.MigrateDown(*steps)
+		log.Info("migrate_down_done", zap.Int("reverted", n))
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown_migrate_action: %s", action)
+	}
+	status, err := sto.MigrationStatus()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "version\tname\tapplied_at\n")
+	for _, st := range status {
+		applied := "pending"
+		if st.AppliedAt != nil {
+			applied = st.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", st.Version, st.Name, applied)
+	}
+	return tw.Flush()
+}
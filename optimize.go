@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/aibotsoft/crypto-surebet/pkg/config"
+	"github.com/aibotsoft/crypto-surebet/services/backtest"
+	"go.uber.org/zap"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+type paramFlags []string
+
+func (p *paramFlags) String() string {
+	return strings.Join(*p, " ")
+}
+
+func (p *paramFlags) Set(s string) error {
+	*p = append(*p, s)
+	return nil
+}
+
+func runOptimize(cfg *config.Config, log *zap.Logger, args []string) error {
+	fs := flag.NewFlagSet("optimize", flag.ContinueOnError)
+	rf := addReplayFlags(fs)
+	var paramList paramFlags
+	fs.Var(&paramList, "p", "param values, name=v1,v2 or name=from:to:step, repeatable")
+	random := fs.Int("random", 0, "try n random combinations instead of the full grid")
+	seed := fs.Int64("seed", 1, "random search seed")
+	workers := fs.Int("workers", runtime.NumCPU(), "parallel replays")
+	sortKey := fs.String("sort", "pnl", "rank by pnl, volume, inventory or heal_fail")
+	top := fs.Int("top", 20, "print only the best n, 0 prints all")
+	asJSON := fs.Bool("json", false, "print results as json")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	err = backtest.SortResults(nil, *sortKey)
+	if err != nil {
+		return err
+	}
+	if len(paramList) == 0 {
+		return fmt.Errorf("no_params: use -p name=values")
+	}
+	var params []backtest.Param
+	for _, s := range paramList {
+		p, err := backtest.ParseParam(s)
+		if err != nil {
+			return err
+		}
+		params = append(params, p)
+	}
+	sets := backtest.Grid(params)
+	if *random > 0 {
+		sets = backtest.Random(params, *random, *seed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src, opt, closeSrc, err := rf.open(ctx, cfg, log)
+	if err != nil {
+		return err
+	}
+	data, err := backtest.ReadAll(src)
+	closeSrc()
+	if err != nil {
+		return err
+	}
+	log.Info("optimize_start", zap.Int("inputs", len(data)), zap.Int("configs", len(sets)), zap.Int("workers", *workers))
+	start := time.Now()
+	results := backtest.Sweep(ctx, cfg, opt, data, sets, *workers)
+	err = backtest.SortResults(results, *sortKey)
+	if err != nil {
+		return err
+	}
+	log.Info("optimize_done", zap.Duration("elapsed", time.Since(start)))
+	if *top > 0 && len(results) > *top {
+		results = results[:*top]
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+	return backtest.PrintSweep(os.Stdout, results)
+}
@@ -0,0 +1,120 @@
+package capture
+
+import (
+	"github.com/aibotsoft/crypto-surebet/pkg/config"
+	"github.com/aibotsoft/crypto-surebet/pkg/store"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testRecorder(t *testing.T, maxFileSize int64) (*Recorder, string) {
+	t.Helper()
+	var cfg config.Config
+	cfg.Capture.Dir = t.TempDir()
+	cfg.Capture.MaxFileSize = maxFileSize
+	cfg.Capture.RotatePeriod = time.Hour
+	cfg.Capture.BufferSize = 100
+	r, err := NewRecorder(&cfg, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, cfg.Capture.Dir
+}
+
+func surebet(id int64) *store.Surebet {
+	return &store.Surebet{
+		ID:        id,
+		FtxTicker: &store.TickerData{Symbol: "BTC/USD", BidPrice: decimal.NewFromInt(id)},
+		UsdtPrice: decimal.NewFromInt(1),
+	}
+}
+
+func TestRecordRotateRead(t *testing.T) {
+	r, dir := testRecorder(t, 512)
+	for i := int64(1); i <= 50; i++ {
+		r.Record(i*10, surebet(i))
+	}
+	r.Close()
+	recorded, dropped := r.Stats()
+	if recorded != 50 || dropped != 0 {
+		t.Fatalf("recorded %d dropped %d", recorded, dropped)
+	}
+	reader, err := NewReader(dir, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reader.Files()) < 2 {
+		t.Fatalf("files %d, want rotation", len(reader.Files()))
+	}
+	var n int64
+	for {
+		rec, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		n++
+		if rec.Surebet.ID != n || rec.Received != n*10 || !rec.Surebet.FtxTicker.BidPrice.Equal(decimal.NewFromInt(n)) {
+			t.Fatalf("record %d: %+v", n, rec)
+		}
+	}
+	if n != 50 {
+		t.Fatalf("read %d, want 50", n)
+	}
+
+	reader, err = NewReader(dir, time.Unix(0, 200), time.Unix(0, 300))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n = 0
+	for {
+		_, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		n++
+	}
+	if n != 11 {
+		t.Fatalf("read %d in range, want 11", n)
+	}
+}
+
+func TestReadTruncated(t *testing.T) {
+	r, dir := testRecorder(t, 1<<20)
+	for i := int64(1); i <= 20; i++ {
+		r.Record(i, surebet(i))
+	}
+	r.Close()
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+fileExt))
+	if len(files) != 1 {
+		t.Fatalf("files %v", files)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	//crash while writing: the part file has no gzip footer
+	part := files[0] + partExt
+	err = os.WriteFile(part, data[:len(data)-8], 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Remove(files[0])
+	list, err := ReadFile(part)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) == 0 || list[0].Surebet.ID != 1 {
+		t.Fatalf("read %d records from truncated file", len(list))
+	}
+}
@@ -0,0 +1,159 @@
+package capture
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reader returns records of all capture files in a directory in file order,
+// including the file still being written. A truncated file ends at the last
+// complete record.
+type Reader struct {
+	files []string
+	from  int64
+	to    int64
+	file  *os.File
+	dec   *gob.Decoder
+	name  string
+}
+
+// NewReader reads records received in [from, to], zero times are not limited.
+func NewReader(dir string, from time.Time, to time.Time) (*Reader, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("capture_read_dir_error: %w", err)
+	}
+	r := &Reader{to: 1<<63 - 1}
+	if !from.IsZero() {
+		r.from = from.UnixNano()
+	}
+	if !to.IsZero() {
+		r.to = to.UnixNano()
+	}
+	type item struct {
+		path  string
+		start int64
+	}
+	var items []item
+	for _, e := range entries {
+		start, ok := fileStart(e.Name())
+		if e.IsDir() || !ok || start > r.to {
+			continue
+		}
+		items = append(items, item{path: filepath.Join(dir, e.Name()), start: start})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].start < items[j].start })
+	for i, it := range items {
+		//next file starts before from, so this one can not have anything newer
+		if i+1 < len(items) && items[i+1].start <= r.from {
+			continue
+		}
+		r.files = append(r.files, it.path)
+	}
+	return r, nil
+}
+
+// Files lists the files the reader goes through.
+func (r *Reader) Files() []string {
+	return r.files
+}
+
+func (r *Reader) Next() (*Record, error) {
+	for {
+		if r.dec == nil {
+			if len(r.files) == 0 {
+				return nil, io.EOF
+			}
+			err := r.open(r.files[0])
+			r.files = r.files[1:]
+			if err != nil {
+				return nil, err
+			}
+		}
+		var rec Record
+		err := r.dec.Decode(&rec)
+		if err != nil {
+			r.closeFile()
+			if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+				continue
+			}
+			return nil, fmt.Errorf("capture_decode_error: %s: %w", r.name, err)
+		}
+		if rec.Received < r.from || rec.Received > r.to {
+			continue
+		}
+		return &rec, nil
+	}
+}
+
+func (r *Reader) Close() {
+	r.closeFile()
+	r.files = nil
+}
+
+func (r *Reader) open(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("capture_open_error: %w", err)
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		_ = f.Close()
+		//empty file just created by the recorder
+		if err == io.EOF {
+			return nil
+		}
+		return fmt.Errorf("capture_gzip_error: %s: %w", name, err)
+	}
+	r.file = f
+	r.name = name
+	r.dec = gob.NewDecoder(bufio.NewReader(gz))
+	return nil
+}
+
+func (r *Reader) closeFile() {
+	if r.file != nil {
+		_ = r.file.Close()
+	}
+	r.file = nil
+	r.dec = nil
+}
+
+// ReadFile returns all complete records of one capture file.
+func ReadFile(name string) ([]Record, error) {
+	r := &Reader{files: []string{name}, to: 1<<63 - 1}
+	defer r.Close()
+	var list []Record
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return list, nil
+		}
+		if err != nil {
+			return list, err
+		}
+		list = append(list, *rec)
+	}
+}
+
+func fileStart(name string) (int64, bool) {
+	name = strings.TrimSuffix(name, partExt)
+	if !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileExt) {
+		return 0, false
+	}
+	start, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileExt), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return start, true
+}
@@ -0,0 +1,206 @@
+package capture
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/gob"
+	"fmt"
+	"github.com/aibotsoft/crypto-surebet/pkg/config"
+	"github.com/aibotsoft/crypto-surebet/pkg/store"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const filePrefix = "crypto-surebet-"
+const fileExt = ".gob.gz"
+
+// partExt marks the file being written, it is renamed when rotated or closed.
+const partExt = ".part"
+const flushPeriod = time.Second
+
+// Record is a surebet message with the time it was received, in unix nanos.
+type Record struct {
+	Received int64
+	Surebet  *store.Surebet
+}
+
+// Recorder writes every received surebet to gzip compressed gob files in Dir,
+// starting a new file when MaxFileSize bytes were written or RotatePeriod passed.
+type Recorder struct {
+	cfg    *config.Config
+	log    *zap.Logger
+	ch     chan Record
+	done   chan struct{}
+	mu     sync.RWMutex
+	closed bool
+
+	file    *os.File
+	gz      *gzip.Writer
+	buf     *bufio.Writer
+	enc     *gob.Encoder
+	name    string
+	opened  time.Time
+	written countWriter
+
+	recorded int64
+	dropped  int64
+}
+
+type countWriter struct {
+	w *bufio.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func NewRecorder(cfg *config.Config, log *zap.Logger) (*Recorder, error) {
+	err := os.MkdirAll(cfg.Capture.Dir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("capture_dir_error: %w", err)
+	}
+	r := &Recorder{
+		cfg:  cfg,
+		log:  log,
+		ch:   make(chan Record, cfg.Capture.BufferSize),
+		done: make(chan struct{}),
+	}
+	go r.loop()
+	return r, nil
+}
+
+// Record queues the message and never blocks, when the buffer is full the message
+// is counted as dropped. Calc changes the surebet later, so a copy is queued.
+func (r *Recorder) Record(received int64, sb *store.Surebet) {
+	c := *sb
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		atomic.AddInt64(&r.dropped, 1)
+		return
+	}
+	select {
+	case r.ch <- Record{Received: received, Surebet: &c}:
+	default:
+		atomic.AddInt64(&r.dropped, 1)
+	}
+}
+
+func (r *Recorder) Stats() (recorded int64, dropped int64) {
+	return atomic.LoadInt64(&r.recorded), atomic.LoadInt64(&r.dropped)
+}
+
+// Close writes everything queued and finalizes the current file.
+func (r *Recorder) Close() {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.ch)
+	}
+	r.mu.Unlock()
+	<-r.done
+}
+
+func (r *Recorder) loop() {
+	defer close(r.done)
+	flushTick := time.NewTicker(flushPeriod)
+	defer flushTick.Stop()
+	for {
+		select {
+		case rec, ok := <-r.ch:
+			if !ok {
+				r.closeFile()
+				recorded, dropped := r.Stats()
+				r.log.Info("capture_closed", zap.Int64("recorded", recorded), zap.Int64("dropped", dropped))
+				return
+			}
+			r.write(rec)
+		case <-flushTick.C:
+			r.flush()
+		}
+	}
+}
+
+func (r *Recorder) write(rec Record) {
+	if r.file != nil && (r.written.n >= r.cfg.Capture.MaxFileSize || time.Since(r.opened) >= r.cfg.Capture.RotatePeriod) {
+		r.closeFile()
+	}
+	if r.file == nil {
+		err := r.openFile(time.Unix(0, rec.Received))
+		if err != nil {
+			r.log.Error("capture_open_error", zap.Error(err))
+			atomic.AddInt64(&r.dropped, 1)
+			return
+		}
+	}
+	err := r.enc.Encode(&rec)
+	if err != nil {
+		r.log.Error("capture_write_error", zap.String("file", r.name), zap.Error(err))
+		atomic.AddInt64(&r.dropped, 1)
+		return
+	}
+	atomic.AddInt64(&r.recorded, 1)
+}
+
+func (r *Recorder) openFile(start time.Time) error {
+	r.name = filepath.Join(r.cfg.Capture.Dir, fileName(start))
+	f, err := os.Create(r.name + partExt)
+	if err != nil {
+		return err
+	}
+	r.file = f
+	r.gz = gzip.NewWriter(f)
+	r.buf = bufio.NewWriter(r.gz)
+	r.written = countWriter{w: r.buf}
+	r.enc = gob.NewEncoder(&r.written)
+	r.opened = time.Now()
+	return nil
+}
+
+// flush pushes buffered records to the file, so a crash loses at most flushPeriod.
+func (r *Recorder) flush() {
+	if r.file == nil {
+		return
+	}
+	err := r.buf.Flush()
+	if err == nil {
+		err = r.gz.Flush()
+	}
+	if err != nil {
+		r.log.Error("capture_flush_error", zap.String("file", r.name), zap.Error(err))
+	}
+}
+
+func (r *Recorder) closeFile() {
+	if r.file == nil {
+		return
+	}
+	err := r.buf.Flush()
+	if err == nil {
+		err = r.gz.Close()
+	}
+	err2 := r.file.Close()
+	if err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(r.name+partExt, r.name)
+	}
+	if err != nil {
+		r.log.Error("capture_close_error", zap.String("file", r.name), zap.Error(err))
+	} else {
+		r.log.Info("capture_file_done", zap.String("file", r.name), zap.Int64("bytes", r.written.n))
+	}
+	r.file = nil
+}
+
+func fileName(start time.Time) string {
+	return fmt.Sprintf("%s%019d%s", filePrefix, start.UnixNano(), fileExt)
+}
@@ -0,0 +1,82 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f once d has elapsed.
+	AfterFunc(d time.Duration, f func())
+}
+
+type Real struct{}
+
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+func (Real) AfterFunc(d time.Duration, f func()) {
+	time.AfterFunc(d, f)
+}
+
+type simTimer struct {
+	at time.Time
+	f  func()
+}
+
+// Sim is a manually driven clock. Time moves only on Set, timers that become due
+// are run synchronously by the caller of Set in deadline order.
+type Sim struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []simTimer
+}
+
+func NewSim(now time.Time) *Sim {
+	return &Sim{now: now}
+}
+
+func (c *Sim) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *Sim) AfterFunc(d time.Duration, f func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timers = append(c.timers, simTimer{at: c.now.Add(d), f: f})
+	sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].at.Before(c.timers[j].at) })
+}
+
+// Set moves the clock forward to t, running every timer due up to t. Going back in
+// time is ignored.
+func (c *Sim) Set(t time.Time) {
+	for {
+		c.mu.Lock()
+		if len(c.timers) == 0 || c.timers[0].at.After(t) {
+			if t.After(c.now) {
+				c.now = t
+			}
+			c.mu.Unlock()
+			return
+		}
+		timer := c.timers[0]
+		c.timers = c.timers[1:]
+		if timer.at.After(c.now) {
+			c.now = timer.at
+		}
+		c.mu.Unlock()
+		timer.f()
+	}
+}
+
+// Pending returns the number of timers not fired yet.
+func (c *Sim) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
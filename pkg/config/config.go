@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"github.com/cristalhq/aconfig"
 	"github.com/cristalhq/aconfig/aconfigyaml"
 	"time"
@@ -25,6 +26,15 @@ type Config struct {
 		ReHealPeriod         time.Duration `json:"re_heal_period"`
 		BetCancelPeriod      time.Duration `json:"bet_cancel_period"`
 		DemoMode             bool          `json:"demo_mode" default:"false"`
+		PaperMode            bool          `json:"paper_mode" default:"false"`
+		//0 disables watching config files, reload is still possible by SIGHUP
+		ConfigWatchPeriod time.Duration `json:"config_watch_period" default:"10s"`
+		//usd value to hold of every coin, the skew ratio moves required profit and
+		//size toward it, 0 skew disables
+		InventoryTarget float64 `json:"inventory_target" default:"28"`
+		InventorySkew   float64 `json:"inventory_skew" default:"0"`
+		//0 disables saving the placer funnel
+		FunnelPersistPeriod time.Duration `json:"funnel_persist_period" default:"5m"`
 	} `json:"service"`
 	Zap struct {
 		//debug, info, warn, error, fatal, panic
@@ -51,6 +61,102 @@ type Config struct {
 		Host string `json:"host"`
 		Port string `json:"port"`
 	} `json:"nats"`
+	Capture struct {
+		Enabled bool   `json:"enabled" default:"false"`
+		Dir     string `json:"dir" default:"capture"`
+		//uncompressed bytes per file
+		MaxFileSize  int64         `json:"max_file_size" default:"268435456"`
+		RotatePeriod time.Duration `json:"rotate_period" default:"1h"`
+		BufferSize   int           `json:"buffer_size" default:"10000"`
+	} `json:"capture"`
+	Http struct {
+		//metrics and admin api
+		Enabled bool   `json:"enabled" default:"false"`
+		Addr    string `json:"addr" default:":9100"`
+		//admin api has no auth, keep the addr private when enabled
+		Admin bool `json:"admin" default:"false"`
+	} `json:"http"`
+	Kill struct {
+		//keep heal orders when the kill switch trips by signal or automatically
+		KeepHeals bool `json:"keep_heals" default:"true"`
+		//trip when this many heals got no order within the window, 0 disables
+		HealFailLimit  int           `json:"heal_fail_limit" default:"3"`
+		HealFailWindow time.Duration `json:"heal_fail_window" default:"10m"`
+	} `json:"kill"`
+	Inventory struct {
+		//passive post only orders moving coins out of the band back to target
+		Rebalance bool `json:"rebalance" default:"false"`
+		//allowed deviation from target as a fraction of it
+		Band            float64       `json:"band" default:"0.5"`
+		RebalancePeriod time.Duration `json:"rebalance_period" default:"1m"`
+		//max usd per rebalance order
+		OrderUsd float64 `json:"order_usd" default:"10"`
+	} `json:"inventory"`
+	Exposure struct {
+		//usd limits checked before every bet, 0 disables each of them
+		CoinUsd      float64 `json:"coin_usd" default:"0"`
+		OpenNotional float64 `json:"open_notional" default:"0"`
+		InventoryUsd float64 `json:"inventory_usd" default:"0"`
+		//coins left out of the inventory limit
+		StableCoins string `json:"stable_coins" default:"USD,USDT,USDC,BUSD"`
+	} `json:"exposure"`
+	Ledger struct {
+		//realized pnl of surebets younger than Lookback is rebuilt from fills every
+		//Period, 0 disables
+		Period   time.Duration `json:"period" default:"1m"`
+		Lookback time.Duration `json:"lookback" default:"24h"`
+	} `json:"ledger"`
+	Equity struct {
+		//0 disables equity snapshots
+		SnapshotPeriod time.Duration `json:"snapshot_period" default:"15m"`
+	} `json:"equity"`
+	Reconcile struct {
+		//0 disables the inventory reconciler
+		Period time.Duration `json:"period" default:"5m"`
+		//usd drift allowed per coin
+		Tolerance float64 `json:"tolerance" default:"20"`
+		//place heals for flagged drifts and retry stuck heals
+		Correct bool `json:"correct" default:"false"`
+	} `json:"reconcile"`
+	Recovery struct {
+		//surebets and heals younger than this are resumed on start, 0 disables
+		Lookback time.Duration `json:"lookback" default:"24h"`
+	} `json:"recovery"`
+	Shutdown struct {
+		//cancel open bet orders instead of waiting for the bet cancel period
+		CancelBets bool `json:"cancel_bets" default:"true"`
+		//max wait for heals of in-flight bets and saving
+		Timeout time.Duration `json:"timeout" default:"15s"`
+	} `json:"shutdown"`
+	Breaker struct {
+		//0 disables all circuit breakers
+		CheckPeriod time.Duration `json:"check_period" default:"1s"`
+		//realized pnl in quote currency over the window, 0 disables
+		PnlWindow         time.Duration `json:"pnl_window" default:"1h"`
+		MaxDrawdown       float64       `json:"max_drawdown" default:"0"`
+		MarketMaxDrawdown float64       `json:"market_max_drawdown" default:"0"`
+		//bet and heal errors, rate limit errors counted apart, 0 disables
+		ErrorWindow    time.Duration `json:"error_window" default:"1m"`
+		ErrorLimit     int           `json:"error_limit" default:"20"`
+		RateLimitLimit int           `json:"rate_limit_limit" default:"5"`
+		//moving average of the nats delay, 0 disables
+		MaxNatsDelay time.Duration `json:"max_nats_delay" default:"0s"`
+		//pause length, doubled on every trip until no trip for TripReset, MaxCoolDown 0 does not cap it
+		CoolDown    time.Duration `json:"cool_down" default:"1m"`
+		MaxCoolDown time.Duration `json:"max_cool_down" default:"30m"`
+		TripReset   time.Duration `json:"trip_reset" default:"1h"`
+	} `json:"breaker"`
+	Writer struct {
+		//rows are written in batches when BatchSize rows are queued or every
+		//FlushPeriod, 0 writes at once
+		BatchSize   int           `json:"batch_size" default:"200"`
+		FlushPeriod time.Duration `json:"flush_period" default:"200ms"`
+		//transient db errors are retried, then the batch is queued again while the
+		//queue is under MaxQueue rows
+		Retries    int           `json:"retries" default:"3"`
+		RetryDelay time.Duration `json:"retry_delay" default:"100ms"`
+		MaxQueue   int           `json:"max_queue" default:"100000"`
+	} `json:"writer"`
 	Ws struct {
 		ConnTimeout time.Duration `json:"conn_timeout" default:"5s"`
 	} `json:"ws"`
@@ -62,7 +168,19 @@ type Config struct {
 	} `json:"postgres"`
 }
 
+// Files are merged in order, later ones override.
+var Files = []string{"config.yaml", "crypto-surebet.yaml"}
+
 func NewConfig() *Config {
+	cfg, err := Load()
+	if err != nil {
+		panic(err)
+	}
+	return cfg
+}
+
+// Load reads config files and env again, used on reload.
+func Load() (*Config, error) {
 	var cfg Config
 	loader := aconfig.LoaderFor(&cfg, aconfig.Config{
 		SkipFlags:          true,
@@ -75,15 +193,14 @@ func NewConfig() *Config {
 		FileFlag:           "config",
 		FailOnFileNotFound: false,
 		MergeFiles:         true,
-		Files:              []string{"config.yaml", "crypto-surebet.yaml"},
+		Files:              Files,
 		FileDecoders: map[string]aconfig.FileDecoder{
 			".yaml": aconfigyaml.New(),
 		},
 	})
 	err := loader.Load()
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("load_config_error: %w", err)
 	}
-
-	return &cfg
+	return &cfg, nil
 }
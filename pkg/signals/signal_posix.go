@@ -3,4 +3,24 @@
 
 package signals
 
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
 //var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// SetupReloadHandler returns a channel receiving SIGHUP.
+func SetupReloadHandler() <-chan os.Signal {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	return c
+}
+
+// SetupKillHandler returns a channel receiving SIGUSR1, it trips the kill switch.
+func SetupKillHandler() <-chan os.Signal {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1)
+	return c
+}
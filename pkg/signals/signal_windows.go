@@ -1,3 +1,15 @@
 package signals
 
+import "os"
+
 //var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGKILL, syscall.SIGTERM, syscall.SIGINT}
+
+// SetupReloadHandler returns a channel that never receives, there is no SIGHUP on windows.
+func SetupReloadHandler() <-chan os.Signal {
+	return make(chan os.Signal)
+}
+
+// SetupKillHandler returns a channel that never receives, there is no SIGUSR1 on windows.
+func SetupKillHandler() <-chan os.Signal {
+	return make(chan os.Signal)
+}
@@ -0,0 +1,537 @@
+package store
+
+import (
+	"errors"
+	"github.com/shopspring/decimal"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrNotFound = errors.New("record_not_found")
+
+// Memory keeps everything in process memory. It mirrors the Store methods used by
+// the placer and is meant for simulations and tests.
+type Memory struct {
+	mu        sync.Mutex
+	account   Account
+	balances  map[string]Balance
+	markets   map[string]Market
+	orders    map[int64]Order
+	fills     map[int64]Fills
+	surebets  map[int64]Surebet
+	heals     map[int64]Heal
+	healOrder map[int64][]int64
+	changes   []ConfigChange
+	overrides map[string]PlaceOverride
+	rules     []MarketRule
+	windows   []TradingWindow
+	funnel    []FunnelStat
+	kills     []KillEvent
+	cycles    map[int64]PnlCycle
+	equity    []EquitySnapshot
+	baselines []InventoryBaseline
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		balances:  make(map[string]Balance),
+		markets:   make(map[string]Market),
+		orders:    make(map[int64]Order),
+		fills:     make(map[int64]Fills),
+		surebets:  make(map[int64]Surebet),
+		heals:     make(map[int64]Heal),
+		healOrder: make(map[int64][]int64),
+		overrides: make(map[string]PlaceOverride),
+		cycles:    make(map[int64]PnlCycle),
+	}
+}
+
+func (m *Memory) SaveAccount(resp *Account) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.account = *resp
+	return nil
+}
+
+func (m *Memory) SaveBalances(balanceList *[]Balance) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, b := range *balanceList {
+		m.balances[b.Coin] = b
+	}
+	return nil
+}
+
+func (m *Memory) SaveMarkets(data *[]Market) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, market := range *data {
+		m.markets[market.Name] = market
+	}
+	return nil
+}
+
+func (m *Memory) SaveOrders(data []Order) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, o := range data {
+		m.orders[o.ID] = o
+	}
+	return nil
+}
+
+func (m *Memory) SaveOrder(order *Order) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.orders[order.ID] = *order
+}
+
+func (m *Memory) SaveSurebet(sb *Surebet) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.surebets[sb.ID] = *sb
+}
+
+func (m *Memory) SaveFills(data *Fills) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fills[data.ID] = *data
+}
+
+func (m *Memory) SaveHeal(data *Heal) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := *data
+	h.Orders = nil
+	ids := m.healOrder[h.ID][:0]
+	for _, o := range data.Orders {
+		m.orders[o.ID] = *o
+		ids = append(ids, o.ID)
+	}
+	m.healOrder[h.ID] = ids
+	m.heals[h.ID] = h
+}
+
+func (m *Memory) InsertSurebets(data []*Surebet) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, sb := range data {
+		if _, ok := m.surebets[sb.ID]; !ok {
+			m.surebets[sb.ID] = *sb
+		}
+	}
+	return nil
+}
+
+func (m *Memory) UpsertHeals(data []*Heal) error {
+	for _, h := range data {
+		m.SaveHeal(h)
+	}
+	return nil
+}
+
+func (m *Memory) InsertFills(data []*Fills) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, f := range data {
+		if _, ok := m.fills[f.ID]; !ok {
+			m.fills[f.ID] = *f
+		}
+	}
+	return nil
+}
+
+func (m *Memory) UpsertOrders(data []Order) error {
+	return m.SaveOrders(data)
+}
+
+func (m *Memory) DeleteSurebetsByOrderIDs(ids []int64) error {
+	for _, id := range ids {
+		m.DeleteSurebetByOrderID(id)
+		m.DeleteOrderByID(id)
+	}
+	return nil
+}
+
+func (m *Memory) SaveConfigChanges(data []ConfigChange) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, c := range data {
+		c.ID = int64(len(m.changes) + 1)
+		m.changes = append(m.changes, c)
+	}
+	return nil
+}
+
+func (m *Memory) ConfigChanges() []ConfigChange {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]ConfigChange(nil), m.changes...)
+}
+
+func (m *Memory) SavePlaceOverride(o PlaceOverride) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.overrides[o.Key] = o
+}
+
+func (m *Memory) SelectPlaceOverrides() ([]PlaceOverride, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data := make([]PlaceOverride, 0, len(m.overrides))
+	for _, o := range m.overrides {
+		data = append(data, o)
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i].Key < data[j].Key })
+	return data, nil
+}
+
+func (m *Memory) SaveMarketRule(r MarketRule) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r.ID = int64(len(m.rules) + 1)
+	m.rules = append(m.rules, r)
+}
+
+func (m *Memory) SelectMarketRules() ([]MarketRule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]MarketRule(nil), m.rules...), nil
+}
+
+func (m *Memory) SaveTradingWindow(w TradingWindow) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.ID = int64(len(m.windows) + 1)
+	m.windows = append(m.windows, w)
+}
+
+func (m *Memory) SelectTradingWindows(after time.Time) ([]TradingWindow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var data []TradingWindow
+	for _, w := range m.windows {
+		if w.End.After(after) {
+			data = append(data, w)
+		}
+	}
+	return data, nil
+}
+
+func (m *Memory) SaveFunnelStats(data []FunnelStat) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, f := range data {
+		f.ID = int64(len(m.funnel) + 1)
+		m.funnel = append(m.funnel, f)
+	}
+	return nil
+}
+
+func (m *Memory) FunnelStats() []FunnelStat {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]FunnelStat(nil), m.funnel...)
+}
+
+func (m *Memory) SaveKillEvent(data *KillEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if data.ID == 0 {
+		data.ID = int64(len(m.kills) + 1)
+		m.kills = append(m.kills, *data)
+		return nil
+	}
+	m.kills[data.ID-1] = *data
+	return nil
+}
+
+func (m *Memory) SelectActiveKillEvent() (*KillEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.kills) - 1; i >= 0; i-- {
+		if m.kills[i].ArmedAt == nil {
+			e := m.kills[i]
+			return &e, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *Memory) KillEvents() []KillEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]KillEvent(nil), m.kills...)
+}
+
+func (m *Memory) DeleteSurebetByOrderID(orderID int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, sb := range m.surebets {
+		if sb.OrderID == orderID {
+			delete(m.surebets, id)
+		}
+	}
+}
+
+func (m *Memory) DeleteOrderByID(orderID int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.orders, orderID)
+}
+
+func (m *Memory) SelectUnhealedSurebets(afterID int64) ([]Surebet, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var data []Surebet
+	for id, sb := range m.surebets {
+		if _, ok := m.heals[id]; ok || id <= afterID || sb.OrderID == 0 {
+			continue
+		}
+		data = append(data, sb)
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i].ID < data[j].ID })
+	return data, nil
+}
+
+func (m *Memory) SelectHeals(afterID int64) ([]Heal, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var data []Heal
+	for id, h := range m.heals {
+		if id <= afterID {
+			continue
+		}
+		h.Orders = nil
+		for _, orderID := range m.healOrder[id] {
+			o := m.orders[orderID]
+			h.Orders = append(h.Orders, &o)
+		}
+		data = append(data, h)
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i].ID < data[j].ID })
+	return data, nil
+}
+
+func (m *Memory) SelectHealsPage(afterID int64, toID int64, limit int) ([]Heal, error) {
+	data, err := m.SelectHeals(afterID)
+	if err != nil {
+		return nil, err
+	}
+	for i, h := range data {
+		if h.ID > toID {
+			data = data[:i]
+			break
+		}
+	}
+	if len(data) > limit {
+		data = data[:limit]
+	}
+	return data, nil
+}
+
+func (m *Memory) SelectSurebets(afterID int64, toID int64, limit int) ([]Surebet, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var data []Surebet
+	for id, sb := range m.surebets {
+		if id > afterID && id <= toID {
+			data = append(data, sb)
+		}
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i].ID < data[j].ID })
+	if len(data) > limit {
+		data = data[:limit]
+	}
+	return data, nil
+}
+
+func (m *Memory) SelectFillsByOrderIDs(ids []int64) ([]Fills, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	orders := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		orders[id] = true
+	}
+	var data []Fills
+	for _, f := range m.fills {
+		if orders[f.OrderID] {
+			data = append(data, f)
+		}
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i].Time.Before(data[j].Time) })
+	return data, nil
+}
+
+func (m *Memory) SavePnlCycles(data []PnlCycle) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, c := range data {
+		m.cycles[c.ID] = c
+	}
+	return nil
+}
+
+func (m *Memory) SelectPnlCycles(from time.Time, to time.Time, market string, paper bool) ([]PnlCycle, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var data []PnlCycle
+	for id, c := range m.cycles {
+		if id < from.UnixNano() || id >= to.UnixNano() || (market != "" && c.Market != market) || c.Paper != paper {
+			continue
+		}
+		data = append(data, c)
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i].ID < data[j].ID })
+	return data, nil
+}
+
+func (m *Memory) SaveEquitySnapshots(data []EquitySnapshot) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range data {
+		e.ID = int64(len(m.equity) + 1)
+		m.equity = append(m.equity, e)
+	}
+	return nil
+}
+
+func (m *Memory) SaveInventoryBaselines(data []InventoryBaseline) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, b := range data {
+		b.ID = int64(len(m.baselines) + 1)
+		m.baselines = append(m.baselines, b)
+	}
+	return nil
+}
+
+func (m *Memory) SelectInventoryBaselines(paper bool) ([]InventoryBaseline, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	last := make(map[string]InventoryBaseline)
+	for _, b := range m.baselines {
+		if b.Paper == paper {
+			last[b.Coin] = b
+		}
+	}
+	data := make([]InventoryBaseline, 0, len(last))
+	for _, b := range last {
+		data = append(data, b)
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i].Coin < data[j].Coin })
+	return data, nil
+}
+
+func (m *Memory) SelectFillFlows(after time.Time, paper bool) ([]FillFlow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	type flowKey struct {
+		market      string
+		side        Side
+		feeCurrency string
+	}
+	flows := make(map[flowKey]*FillFlow)
+	var data []FillFlow
+	for _, f := range m.fills {
+		if !f.Time.After(after) || f.Paper != paper {
+			continue
+		}
+		key := flowKey{market: f.Market, side: f.Side, feeCurrency: f.FeeCurrency}
+		flow, ok := flows[key]
+		if !ok {
+			flow = &FillFlow{Market: f.Market, Side: f.Side, FeeCurrency: f.FeeCurrency}
+			flows[key] = flow
+		}
+		flow.Size = flow.Size.Add(decimal.NewFromFloat(f.Size))
+		flow.Fee = flow.Fee.Add(decimal.NewFromFloat(f.Fee))
+	}
+	for _, flow := range flows {
+		data = append(data, *flow)
+	}
+	return data, nil
+}
+
+func (m *Memory) SelectEquitySnapshots(from time.Time, to time.Time, coin string, paper bool) ([]EquitySnapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var data []EquitySnapshot
+	for _, e := range m.equity {
+		if e.CreatedAt.Before(from) || !e.CreatedAt.Before(to) || (coin != "" && e.Coin != coin) || e.Paper != paper {
+			continue
+		}
+		data = append(data, e)
+	}
+	return data, nil
+}
+
+func (m *Memory) SelectHealByID(id int64) (*Heal, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.heals[id]
+	if !ok {
+		return &Heal{}, ErrNotFound
+	}
+	return &h, nil
+}
+
+func (m *Memory) FindHealOrders(heal *Heal) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var orders []*Order
+	for _, id := range m.healOrder[heal.ID] {
+		o := m.orders[id]
+		orders = append(orders, &o)
+	}
+	heal.Orders = orders
+}
+
+func (m *Memory) Surebets() []Surebet {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var data []Surebet
+	for _, sb := range m.surebets {
+		data = append(data, sb)
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i].ID < data[j].ID })
+	return data
+}
+
+// Heals returns saved heals with their orders attached.
+func (m *Memory) Heals() []Heal {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var data []Heal
+	for _, h := range m.heals {
+		for _, id := range m.healOrder[h.ID] {
+			o := m.orders[id]
+			h.Orders = append(h.Orders, &o)
+		}
+		data = append(data, h)
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i].ID < data[j].ID })
+	return data
+}
+
+func (m *Memory) Orders() []Order {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var data []Order
+	for _, o := range m.orders {
+		data = append(data, o)
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i].ID < data[j].ID })
+	return data
+}
+
+func (m *Memory) Fills() []Fills {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var data []Fills
+	for _, f := range m.fills {
+		data = append(data, f)
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i].ID < data[j].ID })
+	return data
+}
@@ -0,0 +1,229 @@
+package store
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration is a pair of scripts named NNNN_name.up.sql and NNNN_name.down.sql.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+type schemaMigration struct {
+	Version   int64     `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// LoadMigrations returns the embedded migrations ordered by version.
+func LoadMigrations() ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, e := range entries {
+		file := e.Name()
+		var up bool
+		var base string
+		switch {
+		case strings.HasSuffix(file, ".up.sql"):
+			up, base = true, strings.TrimSuffix(file, ".up.sql")
+		case strings.HasSuffix(file, ".down.sql"):
+			base = strings.TrimSuffix(file, ".down.sql")
+		default:
+			return nil, fmt.Errorf("bad_migration_file: %s", file)
+		}
+		split := strings.SplitN(base, "_", 2)
+		version, err := strconv.ParseInt(split[0], 10, 64)
+		if err != nil || len(split) != 2 || version <= 0 {
+			return nil, fmt.Errorf("bad_migration_file: %s", file)
+		}
+		data, err := migrationFiles.ReadFile(path.Join("migrations", file))
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: split[1]}
+			byVersion[version] = m
+		}
+		if m.Name != split[1] {
+			return nil, fmt.Errorf("migration_name_mismatch: %d %s %s", version, m.Name, split[1])
+		}
+		if up {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+	list := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration_incomplete: %d_%s", m.Version, m.Name)
+		}
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+func (s *Store) migrationDB() (*gorm.DB, context.CancelFunc, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, 5*time.Minute)
+	db := s.db.WithContext(ctx)
+	err := db.Exec(`create table if not exists schema_migrations
+(
+    version    bigint primary key,
+    name       text        not null,
+    applied_at timestamptz not null
+)`).Error
+	if err != nil {
+		cancel()
+		return nil, nil, fmt.Errorf("create_schema_migrations_error: %w", err)
+	}
+	return db, cancel, nil
+}
+
+func appliedMigrations(db *gorm.DB) (map[int64]schemaMigration, error) {
+	var data []schemaMigration
+	err := db.Order("version").Find(&data).Error
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int64]schemaMigration, len(data))
+	for _, m := range data {
+		applied[m.Version] = m
+	}
+	return applied, nil
+}
+
+// MigrationStatus lists all migrations, AppliedAt is nil for pending ones.
+func (s *Store) MigrationStatus() ([]MigrationStatus, error) {
+	list, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	db, cancel, err := s.migrationDB()
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	status := make([]MigrationStatus, 0, len(list))
+	for _, m := range list {
+		st := MigrationStatus{Version: m.Version, Name: m.Name}
+		if a, ok := applied[m.Version]; ok {
+			at := a.AppliedAt
+			st.AppliedAt = &at
+		}
+		status = append(status, st)
+	}
+	return status, nil
+}
+
+// MigrateUp applies pending migrations up to version to, 0 applies all of them. Every
+// migration runs in its own transaction, it returns how many were applied.
+func (s *Store) MigrateUp(to int64) (int, error) {
+	list, err := LoadMigrations()
+	if err != nil {
+		return 0, err
+	}
+	db, cancel, err := s.migrationDB()
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for _, m := range list {
+		if to > 0 && m.Version > to {
+			break
+		}
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		m := m
+		err = db.Transaction(func(tx *gorm.DB) error {
+			err := tx.Exec(m.Up).Error
+			if err != nil {
+				return err
+			}
+			return tx.Create(&schemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return n, fmt.Errorf("migrate_up_error: %d_%s: %w", m.Version, m.Name, err)
+		}
+		s.log.Info("migrate_up", zap.Int64("version", m.Version), zap.String("name", m.Name))
+		n++
+	}
+	return n, nil
+}
+
+// MigrateDown reverts the last steps applied migrations, it returns how many were
+// reverted.
+func (s *Store) MigrateDown(steps int) (int, error) {
+	list, err := LoadMigrations()
+	if err != nil {
+		return 0, err
+	}
+	db, cancel, err := s.migrationDB()
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for i := len(list) - 1; i >= 0 && n < steps; i-- {
+		m := list[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			err := tx.Exec(m.Down).Error
+			if err != nil {
+				return err
+			}
+			return tx.Delete(&schemaMigration{}, m.Version).Error
+		})
+		if err != nil {
+			return n, fmt.Errorf("migrate_down_error: %d_%s: %w", m.Version, m.Name, err)
+		}
+		s.log.Info("migrate_down", zap.Int64("version", m.Version), zap.String("name", m.Name))
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,31 @@
+package store
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	list, err := LoadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) == 0 || list[0].Version != 1 {
+		t.Fatalf("migrations %+v", list)
+	}
+	if strings.Contains(list[0].Down, "drop") {
+		t.Fatal("reverting 0001_init drops the trading history")
+	}
+	for i, m := range list {
+		if i > 0 && m.Version <= list[i-1].Version {
+			t.Fatalf("migration %d not after %d", m.Version, list[i-1].Version)
+		}
+		if strings.TrimSpace(m.Up) == "" || strings.TrimSpace(m.Down) == "" {
+			t.Fatalf("migration %d_%s has an empty script", m.Version, m.Name)
+		}
+		// gorm takes ? as a placeholder
+		if strings.Contains(m.Up, "?") || strings.Contains(m.Down, "?") {
+			t.Fatalf("migration %d_%s has a ?", m.Version, m.Name)
+		}
+	}
+}
@@ -0,0 +1,2 @@
+-- 0001_init takes over the trading history tables, reverting it keeps them
+select 1;
@@ -0,0 +1,242 @@
+-- tables created by gorm AutoMigrate before versioned migrations, if not exists keeps
+-- the existing databases
+create table if not exists accounts
+(
+    updated_at                     timestamptz not null,
+    backstop_provider              boolean,
+    collateral                     decimal,
+    free_collateral                decimal,
+    initial_margin_requirement     decimal,
+    leverage                       decimal,
+    liquidating                    boolean,
+    maintenance_margin_requirement decimal,
+    maker_fee                      numeric     not null,
+    margin_fraction                decimal,
+    open_margin_fraction           decimal,
+    taker_fee                      numeric     not null,
+    total_account_value            decimal,
+    total_position_size            decimal,
+    username                       text,
+    primary key (username)
+);
+
+create table if not exists balances
+(
+    coin                     text,
+    free                     numeric     not null,
+    total                    numeric     not null,
+    usd_value                numeric     not null,
+    available_without_borrow numeric     not null,
+    updated_at               timestamptz not null,
+    primary key (coin)
+);
+
+create table if not exists orders
+(
+    created_at     timestamptz not null,
+    updated_at     timestamptz not null,
+    market         text        not null,
+    side           text        not null,
+    status         text        not null,
+    type           text        not null,
+    client_id      text,
+    price          decimal     not null,
+    avg_fill_price decimal,
+    size           decimal,
+    filled_size    decimal     not null,
+    remaining_size decimal,
+    id             bigint,
+    ioc            boolean,
+    post_only      boolean,
+    reduce_only    boolean,
+    closed_at      bigint,
+    paper          boolean     not null default false,
+    primary key (id)
+);
+
+create table if not exists markets
+(
+    updated_at               timestamptz not null,
+    name                     text,
+    base_currency            text,
+    quote_currency           text,
+    quote_volume24_h         decimal,
+    change1_h                decimal,
+    change24_h               decimal,
+    change_bod               decimal,
+    high_leverage_fee_exempt boolean,
+    min_provide_size         decimal,
+    type                     text,
+    underlying               text,
+    enabled                  boolean,
+    ask                      decimal,
+    bid                      decimal,
+    last                     decimal,
+    post_only                boolean,
+    price                    decimal,
+    price_increment          decimal,
+    size_increment           decimal,
+    restricted               boolean,
+    volume_usd24_h           decimal,
+    primary key (name)
+);
+
+create table if not exists fills
+(
+    base_currency  text        not null,
+    fee            decimal     not null,
+    fee_currency   text        not null,
+    fee_rate       decimal     not null,
+    id             bigint      not null,
+    liquidity      text        not null,
+    market         text        not null,
+    order_id       bigint      not null,
+    price          decimal     not null,
+    quote_currency text        not null,
+    side           text        not null,
+    size           decimal     not null,
+    time           timestamptz not null,
+    trade_id       bigint      not null,
+    type           text        not null,
+    paper          boolean     not null default false,
+    primary key (id)
+);
+create index if not exists idx_fills_order_id on fills (order_id);
+
+create table if not exists surebets
+(
+    created_at             timestamptz,
+    id                     bigint,
+    last_bin_time          bigint,
+    start_time             bigint,
+    begin_place            bigint,
+    done                   bigint,
+    ftx_spread             numeric not null,
+    bin_spread             numeric not null,
+    buy_profit             numeric not null,
+    sell_profit            numeric not null,
+    target_profit          numeric not null,
+    profit                 numeric not null,
+    required_profit        numeric not null,
+    amount_coef            numeric not null,
+    profit_inc             numeric not null,
+    volume                 numeric not null,
+    max_stake              numeric not null,
+    place_market           text,
+    place_side             text    not null,
+    place_price            numeric not null,
+    place_type             text    not null,
+    place_size             numeric not null,
+    place_ioc              boolean,
+    place_post_only        boolean,
+    bin_symbol             text    not null,
+    bin_bid_price          numeric not null,
+    bin_bid_qty            numeric not null,
+    bin_ask_price          numeric not null,
+    bin_ask_qty            numeric not null,
+    bin_server_time        bigint  not null,
+    bin_receive_time       bigint  not null,
+    bin_prev_bid_price     numeric,
+    bin_prev_bid_qty       numeric,
+    bin_prev_ask_price     numeric,
+    bin_prev_ask_qty       numeric,
+    bin_prev_server_time   bigint,
+    bin_prev_receive_time  bigint,
+    ftx_symbol             text    not null,
+    ftx_bid_price          numeric not null,
+    ftx_bid_qty            numeric not null,
+    ftx_ask_price          numeric not null,
+    ftx_ask_qty            numeric not null,
+    ftx_server_time        bigint  not null,
+    ftx_receive_time       bigint  not null,
+    ftx_prev_bid_price     numeric,
+    ftx_prev_bid_qty       numeric,
+    ftx_prev_ask_price     numeric,
+    ftx_prev_ask_qty       numeric,
+    ftx_prev_server_time   bigint,
+    ftx_prev_receive_time  bigint,
+    base_free              numeric not null,
+    base_total             numeric,
+    base_usd_value         numeric not null,
+    quote_free             numeric not null,
+    quote_total            numeric not null,
+    quote_usd_value        numeric not null,
+    maker_fee              numeric not null,
+    taker_fee              numeric not null,
+    base_currency          text    not null,
+    quote_currency         text    not null,
+    min_provide_size       numeric not null,
+    size_increment         numeric not null,
+    price_increment        numeric not null,
+    change1_h              numeric not null,
+    change24_h             numeric not null,
+    change_bod             numeric not null,
+    quote_volume24_h       bigint  not null,
+    volume_usd24_h         bigint  not null,
+    profit_price_diff      numeric,
+    conn_reused            boolean,
+    bin_volume             numeric,
+    price                  numeric,
+    size                   numeric,
+    profit_sub_spread      numeric,
+    bin_price              numeric,
+    bin_size               numeric,
+    profit_sub_fee         numeric,
+    real_fee               numeric,
+    target_amount          numeric,
+    order_id               bigint,
+    avg_price_diff         numeric,
+    max_price_diff         numeric,
+    min_price_diff         numeric,
+    profit_sub_avg         numeric,
+    usdt_price             numeric,
+    base_open_buy          numeric,
+    base_open_sell         numeric,
+    avg_price_diff_ratio   numeric,
+    min_volume             numeric,
+    size_ratio             numeric,
+    size_by_bin            numeric,
+    max_by                 text,
+    paper                  boolean not null default false,
+    bin_ftx_volume_ratio   numeric,
+    profit_diff_ratio      numeric,
+    profit_inc_ratio       numeric,
+    size_ratio_multiplayer numeric,
+    override               text,
+    inventory_dev          numeric,
+    inventory_skew         numeric,
+    primary key (id)
+);
+
+create table if not exists heals
+(
+    created_at      timestamptz not null,
+    id              bigint      not null,
+    start           bigint      not null,
+    done            bigint      not null,
+    place_market    text,
+    place_side      text        not null,
+    place_price     numeric     not null,
+    place_type      text        not null,
+    place_size      numeric     not null,
+    place_ioc       boolean,
+    place_post_only boolean,
+    filled_size     numeric     not null,
+    avg_fill_price  numeric     not null,
+    fee_part        numeric     not null,
+    profit_part     numeric     not null,
+    error_msg       text,
+    min_size        numeric,
+    price_increment numeric,
+    paper           boolean     not null default false,
+    primary key (id)
+);
+
+create table if not exists heal_orders
+(
+    heal_id  bigint,
+    order_id bigint,
+    primary key (heal_id, order_id),
+    constraint fk_heal_orders_heal foreign key (heal_id) references heals (id),
+    constraint fk_heal_orders_order foreign key (order_id) references orders (id)
+);
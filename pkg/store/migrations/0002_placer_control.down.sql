@@ -0,0 +1,6 @@
+drop table if exists kill_events;
+drop table if exists funnel_stats;
+drop table if exists trading_windows;
+drop table if exists market_rules;
+drop table if exists place_overrides;
+drop table if exists config_changes;
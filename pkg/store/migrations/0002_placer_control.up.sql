@@ -0,0 +1,84 @@
+create table if not exists config_changes
+(
+    id         bigserial,
+    created_at timestamptz not null,
+    source     text        not null,
+    name       text        not null,
+    old_value  numeric     not null,
+    new_value  numeric     not null,
+    primary key (id)
+);
+create index if not exists idx_config_changes_created_at on config_changes (created_at);
+
+create table if not exists place_overrides
+(
+    key                    text,
+    updated_at             timestamptz not null,
+    max_stake              numeric,
+    target_profit          numeric,
+    target_amount          numeric,
+    bin_ftx_volume_ratio   numeric,
+    profit_diff_ratio      numeric,
+    avg_price_diff_ratio   numeric,
+    profit_inc_ratio       numeric,
+    min_volume             numeric,
+    reheal_threshold       numeric,
+    size_ratio_multiplayer numeric,
+    inventory_target       numeric,
+    inventory_skew         numeric,
+    primary key (key)
+);
+
+create table if not exists market_rules
+(
+    id         bigserial,
+    updated_at timestamptz not null,
+    kind       text        not null,
+    field      text        not null,
+    value      text        not null,
+    primary key (id)
+);
+
+create table if not exists trading_windows
+(
+    id         bigserial,
+    updated_at timestamptz not null,
+    key        text        not null,
+    start      timestamptz not null,
+    "end"      timestamptz not null,
+    comment    text,
+    primary key (id)
+);
+create index if not exists idx_trading_windows_end on trading_windows ("end");
+
+create table if not exists funnel_stats
+(
+    id         bigserial,
+    start      timestamptz not null,
+    "end"      timestamptz not null,
+    market     text        not null,
+    received   bigint      not null,
+    locked     bigint      not null,
+    profitable bigint      not null,
+    sized      bigint      not null,
+    placed     bigint      not null,
+    filled     bigint      not null,
+    healed     bigint      not null,
+    rejects    jsonb,
+    primary key (id)
+);
+create index if not exists idx_funnel_stats_start on funnel_stats (start);
+
+create table if not exists kill_events
+(
+    id         bigserial,
+    created_at timestamptz not null,
+    source     text        not null,
+    reason     text        not null,
+    keep_heals boolean     not null,
+    canceled   bigint      not null,
+    armed_at   timestamptz,
+    armed_by   text,
+    primary key (id)
+);
+create index if not exists idx_kill_events_created_at on kill_events (created_at);
@@ -0,0 +1,2 @@
+drop table if exists equity_snapshots;
+drop table if exists pnl_cycles;
@@ -0,0 +1,34 @@
+create table if not exists pnl_cycles
+(
+    id              bigint,
+    updated_at      timestamptz not null,
+    market          text        not null,
+    side            text        not null,
+    bet_size        numeric     not null,
+    bet_value       numeric     not null,
+    heal_size       numeric     not null,
+    heal_value      numeric     not null,
+    fees            numeric     not null,
+    expected_profit numeric     not null,
+    realized_pnl    numeric     not null,
+    open_size       numeric     not null,
+    fills           bigint      not null,
+    re_heals        bigint      not null,
+    closed          boolean     not null,
+    paper           boolean     not null default false,
+    primary key (id)
+);
+create index if not exists idx_pnl_cycles_market on pnl_cycles (market);
+
+create table if not exists equity_snapshots
+(
+    id             bigserial,
+    created_at     timestamptz not null,
+    coin           text        not null,
+    total          numeric     not null,
+    price          numeric     not null,
+    usd_value      numeric     not null,
+    unrealized_pnl numeric     not null,
+    primary key (id)
+);
+create index if not exists idx_equity_snapshots_created_at on equity_snapshots (created_at);
@@ -0,0 +1 @@
+drop view if exists surebet_view;
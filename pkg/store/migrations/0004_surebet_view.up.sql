@@ -0,0 +1,36 @@
+-- bets with fills, their heal and its last order
+create or replace view surebet_view as
+select s.created_at                     surebet_created,
+       s.target_profit,
+       s.amount_coef,
+       s.target_amount,
+       s.base_usd_value::int,
+       s.volume                         bet_volume,
+       s.place_size                     bet_place,
+       s.ftx_symbol                     sym,
+       s.place_side                     si,
+       s.profit_sub_fee,
+       s.required_profit,
+       h.filled_size                    bet_fill_size,
+       h.avg_fill_price::numeric(10, 5) bet_fill_price,
+       h.fee_part::numeric(9, 3),
+       h.profit_part::numeric(9, 4),
+       h.place_side                     heal_side,
+       ho.status                        heal_status,
+       ho.client_id                     heal_client_id,
+       ho.avg_fill_price                heal_fill_price,
+       ho.size                          heal_size,
+       ho.filled_size                   heal_fill_size,
+       h.error_msg,
+       (h.done - h.start) / 1000000     heal_elapsed_ms
+from surebets s
+         left join orders bo on bo.id = s.order_id
+         left join heals h on s.id = h.id
+         left join lateral (select o.*
+                            from heal_orders x
+                                     join orders o on o.id = x.order_id
+                            where x.heal_id = h.id
+                            order by o.id desc
+                            limit 1) ho on true
+where bo.filled_size > 0
+order by s.created_at desc;
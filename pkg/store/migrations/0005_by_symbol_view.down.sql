@@ -0,0 +1 @@
+drop view if exists by_symbol;
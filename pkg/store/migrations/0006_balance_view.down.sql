@@ -0,0 +1 @@
+drop view if exists balance_view;
@@ -0,0 +1,21 @@
+-- the stake per coin is the inventory_target override of the coin, 28 is the default
+create or replace view balance_view as
+select coin,
+       free,
+       total,
+       usd_value::int,
+       available_without_borrow                                        available,
+       (usd_value / total)::numeric(9, 3)                              price,
+       ((usd_value / stake) - 18)::numeric(9, 3)                       norm_count,
+       (0.03 + ((usd_value / stake) - 17) * 0.03 / 17)::numeric(9, 4) new_buy,
+       (0.03 - ((usd_value / stake) - 17) * 0.03 / 17)::numeric(9, 4) new_sell,
+       sum(usd_value) over () /
+       (select count(coin) from balances where usd_value > 0 and coin != 'USDT' and coin != 'USD') avg_usd_value,
+       (select count(coin) from balances where usd_value > 0 and coin != 'USDT' and coin != 'USD') coin_count,
+       count(coin) over ()                                             row_count
+from (select b.*, coalesce(o.inventory_target, 28) stake
+      from balances b
+               left join place_overrides o on o.key = b.coin) balances
+where usd_value > 0
+  and coin != 'USDT'
+order by usd_value desc;
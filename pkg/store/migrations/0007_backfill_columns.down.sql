@@ -0,0 +1,2 @@
+-- the columns belong to 0001_init, dropping them would break databases created by it
+select 1;
@@ -0,0 +1,17 @@
+-- databases created by gorm AutoMigrate before 0001_init kept their old tables,
+-- add the columns 0001_init has and they miss
+alter table orders
+    add column if not exists paper boolean not null default false;
+alter table fills
+    add column if not exists paper boolean not null default false;
+alter table heals
+    add column if not exists paper boolean not null default false;
+alter table surebets
+    add column if not exists paper                  boolean not null default false,
+    add column if not exists bin_ftx_volume_ratio   numeric,
+    add column if not exists profit_diff_ratio      numeric,
+    add column if not exists profit_inc_ratio       numeric,
+    add column if not exists size_ratio_multiplayer numeric,
+    add column if not exists override               text,
+    add column if not exists inventory_dev          numeric,
+    add column if not exists inventory_skew         numeric;
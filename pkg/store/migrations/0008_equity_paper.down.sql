@@ -0,0 +1,2 @@
+alter table equity_snapshots
+    drop column if exists paper;
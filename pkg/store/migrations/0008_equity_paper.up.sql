@@ -0,0 +1,2 @@
+alter table equity_snapshots
+    add column if not exists paper boolean not null default false;
@@ -0,0 +1,11 @@
+create table if not exists inventory_baselines
+(
+    id         bigserial,
+    created_at timestamptz not null,
+    coin       text        not null,
+    total      numeric     not null,
+    source     text        not null,
+    paper      boolean     not null default false,
+    primary key (id)
+);
+create index if not exists idx_inventory_baselines_coin on inventory_baselines (coin, id);
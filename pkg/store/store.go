@@ -2,17 +2,19 @@ package store
 
 import (
 	"context"
+	"database/sql/driver"
+	"errors"
 	"fmt"
 	"github.com/aibotsoft/crypto-surebet/pkg/config"
-	ftxapi "github.com/aibotsoft/ftx-api"
-	"github.com/jinzhu/copier"
 	"go.uber.org/zap"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 	"log"
+	"net"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -62,22 +64,11 @@ func (s *Store) Close() error {
 	}
 	return db.Close()
 }
+
+// Migrate applies all pending schema migrations.
 func (s *Store) Migrate() error {
-	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.Postgres.Timeout)
-	defer cancel()
-	err := s.db.WithContext(ctx).AutoMigrate(
-		&Account{},
-		&Balance{},
-		&Order{},
-		&Market{},
-		&Fills{},
-		&Surebet{},
-		&Heal{},
-	)
-	if err != nil {
-		return fmt.Errorf("auto_migrate_error: %w", err)
-	}
-	return nil
+	_, err := s.MigrateUp(0)
+	return err
 }
 
 func (s *Store) SaveAccount(resp *Account) error {
@@ -98,14 +89,9 @@ func (s *Store) SaveMarkets(data *[]Market) error {
 	return s.db.WithContext(ctx).Save(data).Error
 }
 
-func (s *Store) SaveOrders(apiOrderList []ftxapi.Order) error {
+func (s *Store) SaveOrders(data []Order) error {
 	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.Postgres.Timeout)
 	defer cancel()
-	var data []Order
-	err := copier.Copy(&data, apiOrderList)
-	if err != nil {
-		return err
-	}
 	return s.db.WithContext(ctx).Clauses(clause.OnConflict{
 		Columns: []clause.Column{{Name: "id"}},
 		DoUpdates: clause.AssignmentColumns([]string{
@@ -157,6 +143,77 @@ func (s *Store) SaveHeal(data *Heal) {
 	}
 }
 
+// InsertSurebets creates surebets, existing ids are skipped.
+func (s *Store) InsertSurebets(data []*Surebet) error {
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.Postgres.Timeout)
+	defer cancel()
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&data).Error
+}
+
+// UpsertHeals creates or updates heals with their orders, ids must be unique.
+func (s *Store) UpsertHeals(data []*Heal) error {
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.Postgres.Timeout)
+	defer cancel()
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&data).Error
+}
+
+// InsertFills creates fills, existing ids are skipped.
+func (s *Store) InsertFills(data []*Fills) error {
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.Postgres.Timeout)
+	defer cancel()
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&data).Error
+}
+
+// UpsertOrders creates or updates orders, ids must be unique.
+func (s *Store) UpsertOrders(data []Order) error {
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.Postgres.Timeout)
+	defer cancel()
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&data).Error
+}
+
+// DeleteSurebetsByOrderIDs deletes unfilled bets, their surebets and orders.
+func (s *Store) DeleteSurebetsByOrderIDs(ids []int64) error {
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.Postgres.Timeout)
+	defer cancel()
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("order_id in ?", ids).Delete(&Surebet{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&Order{}, ids).Error
+	})
+}
+
+func (s *Store) SaveConfigChanges(data []ConfigChange) error {
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.Postgres.Timeout)
+	defer cancel()
+	return s.db.WithContext(ctx).Create(&data).Error
+}
+
+func (s *Store) SaveFunnelStats(data []FunnelStat) error {
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.Postgres.Timeout)
+	defer cancel()
+	return s.db.WithContext(ctx).Create(&data).Error
+}
+
+func (s *Store) SaveKillEvent(data *KillEvent) error {
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.Postgres.Timeout)
+	defer cancel()
+	return s.db.WithContext(ctx).Save(data).Error
+}
+
+// SelectActiveKillEvent returns the last not re-armed kill event, nil if trading is armed.
+func (s *Store) SelectActiveKillEvent() (*KillEvent, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.Postgres.Timeout)
+	defer cancel()
+	var data []KillEvent
+	err := s.db.WithContext(ctx).Where("armed_at is null").Order("id desc").Limit(1).Find(&data).Error
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+	return &data[0], nil
+}
+
 func (s *Store) DeleteSurebetByOrderID(orderID int64) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -183,6 +240,170 @@ func (s *Store) SelectHealByID(id int64) (*Heal, error) {
 	return &heal, err
 }
 
+// SelectSurebets returns up to limit surebets with id in (afterID, toID] ordered by id.
+func (s *Store) SelectSurebets(afterID int64, toID int64, limit int) ([]Surebet, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.Postgres.Timeout)
+	defer cancel()
+	var data []Surebet
+	err := s.db.WithContext(ctx).Where("id > ? and id <= ?", afterID, toID).Order("id").Limit(limit).Find(&data).Error
+	return data, err
+}
+
+// SelectUnhealedSurebets returns placed surebets with id after afterID and no heal.
+func (s *Store) SelectUnhealedSurebets(afterID int64) ([]Surebet, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.Postgres.Timeout)
+	defer cancel()
+	var data []Surebet
+	err := s.db.WithContext(ctx).
+		Where("id > ? and order_id <> 0 and not exists (select 1 from heals h where h.id = surebets.id)", afterID).
+		Order("id").Find(&data).Error
+	return data, err
+}
+
+// SelectHeals returns heals with id after afterID with their orders.
+func (s *Store) SelectHeals(afterID int64) ([]Heal, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.Postgres.Timeout)
+	defer cancel()
+	var data []Heal
+	err := s.db.WithContext(ctx).Preload("Orders").Where("id > ?", afterID).Order("id").Find(&data).Error
+	return data, err
+}
+
+// SelectHealsPage returns up to limit heals with id in (afterID, toID] with their
+// orders, ordered by id.
+func (s *Store) SelectHealsPage(afterID int64, toID int64, limit int) ([]Heal, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.Postgres.Timeout)
+	defer cancel()
+	var data []Heal
+	err := s.db.WithContext(ctx).Preload("Orders").Where("id > ? and id <= ?", afterID, toID).Order("id").Limit(limit).Find(&data).Error
+	return data, err
+}
+
+// SelectFillsByOrderIDs returns the fills of the orders ordered by time.
+func (s *Store) SelectFillsByOrderIDs(ids []int64) ([]Fills, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.Postgres.Timeout)
+	defer cancel()
+	var data []Fills
+	err := s.db.WithContext(ctx).Where("order_id in ?", ids).Order("time").Find(&data).Error
+	return data, err
+}
+
+func (s *Store) SavePnlCycles(data []PnlCycle) error {
+	if len(data) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.Postgres.Timeout)
+	defer cancel()
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&data).Error
+}
+
+// SelectPnlCycles returns cycles of surebets placed in [from, to), of one market
+// when market is not empty. Paper cycles are returned only when paper is set.
+func (s *Store) SelectPnlCycles(from time.Time, to time.Time, market string, paper bool) ([]PnlCycle, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.Postgres.Timeout)
+	defer cancel()
+	var data []PnlCycle
+	q := s.db.WithContext(ctx).Where("id >= ? and id < ? and paper = ?", from.UnixNano(), to.UnixNano(), paper)
+	if market != "" {
+		q = q.Where("market = ?", market)
+	}
+	err := q.Order("id").Find(&data).Error
+	return data, err
+}
+
+func (s *Store) SaveInventoryBaselines(data []InventoryBaseline) error {
+	if len(data) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.Postgres.Timeout)
+	defer cancel()
+	return s.db.WithContext(ctx).Create(&data).Error
+}
+
+// SelectInventoryBaselines returns the last baseline of every coin, of paper mode
+// when paper is set.
+func (s *Store) SelectInventoryBaselines(paper bool) ([]InventoryBaseline, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.Postgres.Timeout)
+	defer cancel()
+	var data []InventoryBaseline
+	err := s.db.WithContext(ctx).
+		Raw("select distinct on (coin) * from inventory_baselines where paper = ? order by coin, id desc", paper).
+		Scan(&data).Error
+	return data, err
+}
+
+// SelectFillFlows sums the fills after after by market, side and fee currency.
+func (s *Store) SelectFillFlows(after time.Time, paper bool) ([]FillFlow, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.Postgres.Timeout)
+	defer cancel()
+	var data []FillFlow
+	err := s.db.WithContext(ctx).Model(&Fills{}).
+		Select("market, side, fee_currency, sum(size) size, sum(fee) fee").
+		Where("time > ? and paper = ?", after, paper).
+		Group("market, side, fee_currency").
+		Scan(&data).Error
+	return data, err
+}
+
+func (s *Store) SaveEquitySnapshots(data []EquitySnapshot) error {
+	if len(data) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.Postgres.Timeout)
+	defer cancel()
+	return s.db.WithContext(ctx).Create(&data).Error
+}
+
+// SelectEquitySnapshots returns snapshots taken in [from, to), of one coin when coin
+// is not empty, ordered by time. Paper snapshots are returned only when paper is set.
+func (s *Store) SelectEquitySnapshots(from time.Time, to time.Time, coin string, paper bool) ([]EquitySnapshot, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.Postgres.Timeout)
+	defer cancel()
+	var data []EquitySnapshot
+	q := s.db.WithContext(ctx).Where("created_at >= ? and created_at < ? and paper = ?", from, to, paper)
+	if coin != "" {
+		q = q.Where("coin = ?", coin)
+	}
+	err := q.Order("created_at, id").Find(&data).Error
+	return data, err
+}
+
+func (s *Store) SelectMarkets() ([]Market, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.Postgres.Timeout)
+	defer cancel()
+	var data []Market
+	err := s.db.WithContext(ctx).Find(&data).Error
+	return data, err
+}
+
+func (s *Store) SelectPlaceOverrides() ([]PlaceOverride, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.Postgres.Timeout)
+	defer cancel()
+	var data []PlaceOverride
+	err := s.db.WithContext(ctx).Find(&data).Error
+	return data, err
+}
+
+func (s *Store) SelectMarketRules() ([]MarketRule, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.Postgres.Timeout)
+	defer cancel()
+	var data []MarketRule
+	err := s.db.WithContext(ctx).Order("id").Find(&data).Error
+	return data, err
+}
+
+// SelectTradingWindows returns windows not ended before after.
+func (s *Store) SelectTradingWindows(after time.Time) ([]TradingWindow, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.cfg.Postgres.Timeout)
+	defer cancel()
+	var data []TradingWindow
+	err := s.db.WithContext(ctx).Where("\"end\" > ?", after).Order("start").Find(&data).Error
+	return data, err
+}
+
 func (s *Store) FindHealOrders(heal *Heal) {
 	var orders []*Order
 	err := s.db.Model(&heal).Association("Orders").Find(&orders)
@@ -324,3 +545,24 @@ func (s *Store) FindHealOrders(heal *Heal) {
 //	)
 //	w.Flush()
 //}
+
+// IsTransient reports whether err may go away on retry: connection errors, timeouts,
+// serialization failures and deadlocks.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var pgErr interface{ SQLState() string }
+	if errors.As(err, &pgErr) {
+		code := pgErr.SQLState()
+		return strings.HasPrefix(code, "08") || code == "40001" || code == "40P01" || code == "57P01"
+	}
+	return false
+}
@@ -119,6 +119,7 @@ type Order struct {
 	PostOnly      bool        `json:"postOnly"`
 	ReduceOnly    bool        `json:"reduceOnly"`
 	ClosedAt      *int64      `json:"closed_at"`
+	Paper         bool        `json:"paper" gorm:"not null;default:false"`
 }
 type Market struct {
 	UpdatedAt             time.Time `json:"updated_at" gorm:"not null"`
@@ -179,15 +180,15 @@ type MarketEmb struct {
 	ChangeBod      decimal.Decimal `json:"-" gorm:"type:numeric not null"`
 	QuoteVolume24H int64           `json:"-" gorm:"not null"`
 	VolumeUsd24H   int64           `json:"-" gorm:"not null"`
-	//Type           string  `json:"type"`
+	Type           string          `json:"type" gorm:"-"`
 	//Underlying     string  `json:"underlying"`
-	//Enabled        bool    `json:"enabled"`
+	Enabled bool `json:"enabled" gorm:"-"`
 	//Ask            float64 `json:"ask"`
 	//Bid            float64 `json:"bid"`
 	//Last           float64 `json:"last"`
-	//PostOnly       bool    `json:"postOnly"`
+	PostOnly bool `json:"postOnly" gorm:"-"`
 	//Price          float64 `json:"price"`
-	//Restricted     bool    `json:"restricted"`
+	Restricted bool `json:"restricted" gorm:"-"`
 }
 type Fills struct {
 	BaseCurrency  *string   `json:"baseCurrency" gorm:"not null"`
@@ -205,6 +206,7 @@ type Fills struct {
 	Time          time.Time `json:"time" gorm:"not null"`
 	TradeID       int64     `json:"tradeId" gorm:"not null"`
 	Type          string    `json:"type" gorm:"not null"`
+	Paper         bool      `json:"paper" gorm:"not null;default:false"`
 }
 type PlaceParamsEmb struct {
 	Market   string          `json:"market"`
@@ -272,6 +274,17 @@ type Surebet struct {
 	SizeRatio         decimal.Decimal `json:"size_ratio" gorm:"type:numeric"`
 	SizeByBin         decimal.Decimal `json:"size_by_bin" gorm:"type:numeric"`
 	MaxBy             string          `json:"max_by"`
+	Paper             bool            `json:"paper" gorm:"not null;default:false"`
+	//effective place config, the fields above hold the rest of it
+	BinFtxVolumeRatio    decimal.Decimal `json:"bin_ftx_volume_ratio" gorm:"type:numeric"`
+	ProfitDiffRatio      decimal.Decimal `json:"profit_diff_ratio" gorm:"type:numeric"`
+	ProfitIncRatio       decimal.Decimal `json:"profit_inc_ratio" gorm:"type:numeric"`
+	SizeRatioMultiplayer decimal.Decimal `json:"size_ratio_multiplayer" gorm:"type:numeric"`
+	//overrides applied, base currency first
+	Override string `json:"override"`
+	//base coin usd value deviation from the inventory target, -1..1
+	InventoryDev  decimal.Decimal `json:"inventory_dev" gorm:"type:numeric"`
+	InventorySkew decimal.Decimal `json:"inventory_skew" gorm:"type:numeric"`
 }
 type Heal struct {
 	CreatedAt time.Time `json:"-" gorm:"not null"`
@@ -288,4 +301,140 @@ type Heal struct {
 	Orders         []*Order        `json:"orders" gorm:"many2many:heal_orders;"`
 	MinSize        decimal.Decimal `json:"min_size" gorm:"type:numeric"`
 	PriceIncrement decimal.Decimal `json:"price_increment" gorm:"type:numeric"`
+	Paper          bool            `json:"paper" gorm:"not null;default:false"`
+}
+type ConfigChange struct {
+	ID        int64           `json:"id" gorm:"primaryKey;autoIncrement:true"`
+	CreatedAt time.Time       `json:"created_at" gorm:"not null;index"`
+	Source    string          `json:"source" gorm:"not null"`
+	Name      string          `json:"name" gorm:"not null"`
+	OldValue  decimal.Decimal `json:"old_value" gorm:"type:numeric not null"`
+	NewValue  decimal.Decimal `json:"new_value" gorm:"type:numeric not null"`
+}
+
+// PlaceOverride replaces the set place config values for a market (BTC/USD) or
+// for all markets of a base currency (BTC), the market one wins.
+type PlaceOverride struct {
+	Key                  string              `json:"key" gorm:"primaryKey"`
+	UpdatedAt            time.Time           `json:"updated_at" gorm:"not null"`
+	MaxStake             decimal.NullDecimal `json:"max_stake" gorm:"type:numeric"`
+	TargetProfit         decimal.NullDecimal `json:"target_profit" gorm:"type:numeric"`
+	TargetAmount         decimal.NullDecimal `json:"target_amount" gorm:"type:numeric"`
+	BinFtxVolumeRatio    decimal.NullDecimal `json:"bin_ftx_volume_ratio" gorm:"type:numeric"`
+	ProfitDiffRatio      decimal.NullDecimal `json:"profit_diff_ratio" gorm:"type:numeric"`
+	AvgPriceDiffRatio    decimal.NullDecimal `json:"avg_price_diff_ratio" gorm:"type:numeric"`
+	ProfitIncRatio       decimal.NullDecimal `json:"profit_inc_ratio" gorm:"type:numeric"`
+	MinVolume            decimal.NullDecimal `json:"min_volume" gorm:"type:numeric"`
+	RehealThreshold      decimal.NullDecimal `json:"reheal_threshold" gorm:"type:numeric"`
+	SizeRatioMultiplayer decimal.NullDecimal `json:"size_ratio_multiplayer" gorm:"type:numeric"`
+	InventoryTarget      decimal.NullDecimal `json:"inventory_target" gorm:"type:numeric"`
+	InventorySkew        decimal.NullDecimal `json:"inventory_skew" gorm:"type:numeric"`
+}
+
+// MarketRule allows or denies markets by Field: market, base or type. When a field
+// has any allow rule, values without one are rejected.
+type MarketRule struct {
+	ID        int64     `json:"id" gorm:"primaryKey"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"not null"`
+	Kind      string    `json:"kind" gorm:"not null"`
+	Field     string    `json:"field" gorm:"not null"`
+	Value     string    `json:"value" gorm:"not null"`
+}
+
+// TradingWindow pauses trading of a market, a base currency or of everything (*)
+// from Start to End, e.g. around maintenance or listings.
+type TradingWindow struct {
+	ID        int64     `json:"id" gorm:"primaryKey"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"not null"`
+	Key       string    `json:"key" gorm:"not null"`
+	Start     time.Time `json:"start" gorm:"not null"`
+	End       time.Time `json:"end" gorm:"not null;index"`
+	Comment   string    `json:"comment"`
+}
+
+// FunnelStat is the placer funnel of a market from Start to End, Rejects is a json
+// object of reject reason counts.
+type FunnelStat struct {
+	ID         int64     `json:"id" gorm:"primaryKey"`
+	Start      time.Time `json:"start" gorm:"not null;index"`
+	End        time.Time `json:"end" gorm:"not null"`
+	Market     string    `json:"market" gorm:"not null"`
+	Received   int64     `json:"received" gorm:"not null"`
+	Locked     int64     `json:"locked" gorm:"not null"`
+	Profitable int64     `json:"profitable" gorm:"not null"`
+	Sized      int64     `json:"sized" gorm:"not null"`
+	Placed     int64     `json:"placed" gorm:"not null"`
+	Filled     int64     `json:"filled" gorm:"not null"`
+	Healed     int64     `json:"healed" gorm:"not null"`
+	Rejects    string    `json:"rejects" gorm:"type:jsonb"`
+}
+
+// KillEvent is a kill switch trip, trading stays halted until it is re-armed.
+type KillEvent struct {
+	ID        int64      `json:"id" gorm:"primaryKey;autoIncrement:true"`
+	CreatedAt time.Time  `json:"created_at" gorm:"not null;index"`
+	Source    string     `json:"source" gorm:"not null"`
+	Reason    string     `json:"reason" gorm:"not null"`
+	KeepHeals bool       `json:"keep_heals" gorm:"not null"`
+	Canceled  int        `json:"canceled" gorm:"not null"`
+	ArmedAt   *time.Time `json:"armed_at"`
+	ArmedBy   *string    `json:"armed_by"`
+}
+
+// PnlCycle is the realized result of a surebet and its heal computed from fills,
+// values are in the quote currency and ID is the surebet id.
+type PnlCycle struct {
+	ID             int64           `json:"id" gorm:"primaryKey;autoIncrement:false"`
+	UpdatedAt      time.Time       `json:"updated_at" gorm:"not null"`
+	Market         string          `json:"market" gorm:"not null;index"`
+	Side           Side            `json:"side" gorm:"not null"`
+	BetSize        decimal.Decimal `json:"bet_size" gorm:"type:numeric not null"`
+	BetValue       decimal.Decimal `json:"bet_value" gorm:"type:numeric not null"`
+	HealSize       decimal.Decimal `json:"heal_size" gorm:"type:numeric not null"`
+	HealValue      decimal.Decimal `json:"heal_value" gorm:"type:numeric not null"`
+	Fees           decimal.Decimal `json:"fees" gorm:"type:numeric not null"`
+	ExpectedProfit decimal.Decimal `json:"expected_profit" gorm:"type:numeric not null"`
+	RealizedPnl    decimal.Decimal `json:"realized_pnl" gorm:"type:numeric not null"`
+	//bet size not healed yet, negative when heals overfilled
+	OpenSize decimal.Decimal `json:"open_size" gorm:"type:numeric not null"`
+	Fills    int             `json:"fills" gorm:"not null"`
+	ReHeals  int             `json:"re_heals" gorm:"not null"`
+	Closed   bool            `json:"closed" gorm:"not null"`
+	Paper    bool            `json:"paper" gorm:"not null;default:false"`
+}
+
+// EquitySnapshot is the value of a coin balance at CreatedAt, rows taken together
+// share CreatedAt and the TOTAL row sums them. UnrealizedPnl marks the open heals of
+// the coin against the last price.
+type EquitySnapshot struct {
+	ID            int64           `json:"id" gorm:"primaryKey;autoIncrement:true"`
+	CreatedAt     time.Time       `json:"created_at" gorm:"not null;index"`
+	Coin          string          `json:"coin" gorm:"not null"`
+	Total         decimal.Decimal `json:"total" gorm:"type:numeric not null"`
+	Price         decimal.Decimal `json:"price" gorm:"type:numeric not null"`
+	UsdValue      decimal.Decimal `json:"usd_value" gorm:"type:numeric not null"`
+	UnrealizedPnl decimal.Decimal `json:"unrealized_pnl" gorm:"type:numeric not null"`
+	Paper         bool            `json:"paper" gorm:"not null;default:false"`
+}
+
+// InventoryBaseline is the balance of a coin the reconciler expects at CreatedAt,
+// fills after it move the expected balance. The last row of a coin is the current
+// one.
+type InventoryBaseline struct {
+	ID        int64           `json:"id" gorm:"primaryKey;autoIncrement:true"`
+	CreatedAt time.Time       `json:"created_at" gorm:"not null"`
+	Coin      string          `json:"coin" gorm:"not null"`
+	Total     decimal.Decimal `json:"total" gorm:"type:numeric not null"`
+	Source    string          `json:"source" gorm:"not null"`
+	Paper     bool            `json:"paper" gorm:"not null;default:false"`
+}
+
+// FillFlow sums the size and fees of the fills of a market side paid in one fee
+// currency.
+type FillFlow struct {
+	Market      string          `json:"market"`
+	Side        Side            `json:"side"`
+	FeeCurrency string          `json:"fee_currency"`
+	Size        decimal.Decimal `json:"size"`
+	Fee         decimal.Decimal `json:"fee"`
 }
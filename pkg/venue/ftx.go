@@ -0,0 +1,188 @@
+package venue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aibotsoft/crypto-surebet/pkg/config"
+	"github.com/aibotsoft/crypto-surebet/pkg/store"
+	"github.com/aibotsoft/ftx-api"
+	"github.com/jinzhu/copier"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+)
+
+type Ftx struct {
+	log    *zap.Logger
+	client *ftxapi.Client
+	ws     *ftxapi.WebsocketService
+}
+
+func NewFtx(cfg *config.Config, log *zap.Logger) *Ftx {
+	ftxConfig := ftxapi.Config{
+		ApiKey:     cfg.Ftx.Key,
+		ApiSecret:  cfg.Ftx.Secret,
+		Logger:     log.WithOptions(zap.IncreaseLevel(zap.InfoLevel)).Sugar(),
+		SubAccount: ftxapi.StringPointer(cfg.Ftx.SubAccount),
+	}
+	client := ftxapi.NewClient(ftxConfig)
+	ws := ftxapi.NewWebsocketService(cfg.Ftx.Key, cfg.Ftx.Secret, ftxapi.WebsocketEndpoint, log.Sugar()).AutoReconnect()
+	ws.SubAccount(cfg.Ftx.SubAccount)
+	return &Ftx{
+		log:    log,
+		client: client,
+		ws:     ws,
+	}
+}
+
+func DecimalToFloat64(d decimal.Decimal) float64 {
+	f, _ := d.Float64()
+	return f
+}
+
+func ftxError(err error) error {
+	switch {
+	case errors.Is(err, ftxapi.ErrorRateLimit):
+		return ErrRateLimit
+	case errors.Is(err, ftxapi.OrderAlreadyClosed):
+		return ErrOrderAlreadyClosed
+	case errors.Is(err, ftxapi.OrderAlreadyQueued):
+		return ErrOrderAlreadyQueued
+	}
+	return err
+}
+
+func (f *Ftx) GetAccount(ctx context.Context) (*store.Account, error) {
+	resp, err := f.client.NewGetAccountService().Do(ctx)
+	if err != nil {
+		return nil, ftxError(err)
+	}
+	var data store.Account
+	err = copier.Copy(&data, resp)
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (f *Ftx) GetBalances(ctx context.Context) ([]store.Balance, error) {
+	resp, err := f.client.NewGetBalancesService().Do(ctx)
+	if err != nil {
+		return nil, ftxError(err)
+	}
+	var data []store.Balance
+	err = copier.Copy(&data, resp)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (f *Ftx) GetMarkets(ctx context.Context) ([]store.Market, error) {
+	resp, err := f.client.NewGetMarketsService().Do(ctx)
+	if err != nil {
+		return nil, ftxError(err)
+	}
+	var data []store.Market
+	err = copier.Copy(&data, resp)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (f *Ftx) GetOpenOrders(ctx context.Context) ([]store.Order, error) {
+	resp, err := f.client.NewGetOpenOrdersService().Do(ctx)
+	if err != nil {
+		return nil, ftxError(err)
+	}
+	var data []store.Order
+	err = copier.Copy(&data, resp)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (f *Ftx) GetOrderHistory(ctx context.Context) ([]store.Order, error) {
+	resp, _, err := f.client.NewGetOrderHistoryService().Do(ctx)
+	if err != nil {
+		return nil, ftxError(err)
+	}
+	var data []store.Order
+	err = copier.Copy(&data, resp)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (f *Ftx) PlaceOrder(ctx context.Context, param store.PlaceParamsEmb) (*store.Order, error) {
+	data := ftxapi.PlaceOrderParams{
+		Market:   param.Market,
+		Side:     ftxapi.Side(param.Side),
+		Price:    DecimalToFloat64(param.Price),
+		Type:     ftxapi.OrderType(param.Type),
+		Size:     DecimalToFloat64(param.Size),
+		Ioc:      &param.Ioc,
+		PostOnly: &param.PostOnly,
+		ClientID: ftxapi.StringPointer(param.ClientID),
+	}
+	resp, err := f.client.NewPlaceOrderService().Params(data).Do(ctx)
+	if err != nil {
+		return nil, ftxError(err)
+	}
+	var o store.Order
+	err = copier.Copy(&o, resp)
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+func (f *Ftx) CancelOrder(ctx context.Context, orderID int64) error {
+	return ftxError(f.client.NewCancelOrderService().OrderID(orderID).Do(ctx))
+}
+
+func (f *Ftx) CancelAllOrders(ctx context.Context) error {
+	return ftxError(f.client.NewCancelAllOrderService().Do(ctx))
+}
+
+func (f *Ftx) Subscribe(orderHandler OrderHandler, fillsHandler FillsHandler, errHandler ErrorHandler) error {
+	handler := func(res ftxapi.WsReponse) {
+		if res.Orders != nil {
+			var o store.Order
+			err := copier.Copy(&o, res.Orders.Data)
+			if err != nil {
+				f.log.Error("copy_order_error", zap.Error(err))
+				return
+			}
+			orderHandler(&o)
+		} else if res.Fills != nil {
+			var data store.Fills
+			err := copier.Copy(&data, res.Fills.Data)
+			if err != nil {
+				f.log.Warn("copy_fills_error", zap.Error(err))
+				return
+			}
+			fillsHandler(&data)
+		}
+	}
+	err := f.ws.Connect(handler, ftxapi.WsErrorHandler(errHandler))
+	if err != nil {
+		return fmt.Errorf("ws_connect_error: %w", err)
+	}
+	err = f.ws.Subscribe(ftxapi.Subscription{Channel: ftxapi.WsChannelOrders})
+	if err != nil {
+		return fmt.Errorf("ws_subscribe_error: %w", err)
+	}
+	err = f.ws.Subscribe(ftxapi.Subscription{Channel: ftxapi.WsChannelFills})
+	if err != nil {
+		return fmt.Errorf("ws_subscribe_error: %w", err)
+	}
+	return nil
+}
+
+func (f *Ftx) Close() {
+	f.ws.Close()
+}
@@ -0,0 +1,552 @@
+package venue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aibotsoft/crypto-surebet/pkg/clock"
+	"github.com/aibotsoft/crypto-surebet/pkg/store"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNotEnoughBalance = errors.New("not_enough_balances")
+var ErrOrderNotFound = errors.New("order_not_found")
+var ErrNoSuchMarket = errors.New("no_such_market")
+
+const (
+	liquidityMaker = "maker"
+	liquidityTaker = "taker"
+)
+
+type simOrder struct {
+	order  store.Order
+	price  decimal.Decimal
+	size   decimal.Decimal
+	filled decimal.Decimal
+	cost   decimal.Decimal
+}
+
+func (o *simOrder) remaining() decimal.Decimal {
+	return o.size.Sub(o.filled)
+}
+
+type simEvent struct {
+	order *store.Order
+	fills *store.Fills
+}
+
+// Sim is an in-memory matching venue. External liquidity is the top of book set by
+// SetTicker, own orders rest in the book until the ticker crosses them. Order and
+// fill events are delivered the same way the FTX websocket reports them.
+type Sim struct {
+	log          *zap.Logger
+	mu           sync.Mutex
+	clock        clock.Clock
+	source       Venue
+	paper        bool
+	seeded       bool
+	account      store.Account
+	markets      map[string]store.Market
+	balances     map[string]decimal.Decimal
+	prices       map[string]decimal.Decimal
+	tickers      map[string]store.TickerData
+	orders       map[int64]*simOrder
+	fills        []store.Fills
+	lastOrderID  int64
+	lastFillID   int64
+	pending      []simEvent
+	dispatching  bool
+	orderHandler OrderHandler
+	fillsHandler FillsHandler
+}
+
+func NewSim(log *zap.Logger) *Sim {
+	return &Sim{
+		log:      log,
+		clock:    clock.Real{},
+		account:  store.Account{Username: "sim"},
+		markets:  make(map[string]store.Market),
+		balances: make(map[string]decimal.Decimal),
+		prices:   make(map[string]decimal.Decimal),
+		tickers:  make(map[string]store.TickerData),
+		orders:   make(map[int64]*simOrder),
+	}
+}
+
+// NewPaper returns a simulator for paper trading. Account fees, markets and the
+// starting balances are taken from the source venue, orders never reach it.
+// Order and fill ids start from the current time so they do not collide with real
+// ones or with previous paper sessions.
+func NewPaper(log *zap.Logger, source Venue) *Sim {
+	s := NewSim(log)
+	s.source = source
+	s.paper = true
+	s.lastOrderID = time.Now().UnixNano()
+	s.lastFillID = s.lastOrderID
+	return s
+}
+
+func (s *Sim) SetClock(c clock.Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = c
+}
+
+func (s *Sim) SetFees(makerFee decimal.Decimal, takerFee decimal.Decimal) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.account.MakerFee = makerFee
+	s.account.TakerFee = takerFee
+}
+
+func (s *Sim) AddMarket(m store.Market) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.markets[m.Name] = m
+}
+
+func (s *Sim) SetBalance(coin string, total decimal.Decimal) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.balances[coin] = total
+}
+
+// SetTicker replaces the external top of book of the market and matches resting
+// orders crossed by it. Matched quantity is taken from the ticker until next update.
+func (s *Sim) SetTicker(t store.TickerData) {
+	s.mu.Lock()
+	s.tickers[t.Symbol] = t
+	for _, o := range s.openOrders(t.Symbol) {
+		s.match(o, liquidityMaker)
+	}
+	s.mu.Unlock()
+	s.dispatch()
+}
+
+func (s *Sim) Fills() []store.Fills {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]store.Fills(nil), s.fills...)
+}
+
+func (s *Sim) GetAccount(ctx context.Context) (*store.Account, error) {
+	if s.source != nil {
+		account, err := s.source.GetAccount(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.SetFees(account.MakerFee, account.TakerFee)
+		return account, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	account := s.account
+	account.UpdatedAt = s.clock.Now()
+	return &account, nil
+}
+
+func (s *Sim) GetBalances(ctx context.Context) ([]store.Balance, error) {
+	err := s.seedBalances(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var data []store.Balance
+	for coin, total := range s.balances {
+		free := s.free(coin)
+		data = append(data, store.Balance{
+			Coin:                   coin,
+			Free:                   free,
+			Total:                  total,
+			UsdValue:               total.Mul(s.usdPrice(coin)),
+			AvailableWithoutBorrow: free,
+			UpdatedAt:              s.clock.Now(),
+		})
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i].Coin < data[j].Coin })
+	return data, nil
+}
+
+func (s *Sim) seedBalances(ctx context.Context) error {
+	s.mu.Lock()
+	seeded := s.source == nil || s.seeded
+	s.mu.Unlock()
+	if seeded {
+		return nil
+	}
+	data, err := s.source.GetBalances(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, b := range data {
+		s.balances[b.Coin] = b.Total
+		if b.Total.IsPositive() {
+			s.prices[b.Coin] = b.UsdValue.Div(b.Total)
+		}
+	}
+	s.seeded = true
+	return nil
+}
+
+func (s *Sim) GetMarkets(ctx context.Context) ([]store.Market, error) {
+	if s.source != nil {
+		data, err := s.source.GetMarkets(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, m := range data {
+			s.markets[m.Name] = m
+		}
+		return data, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var data []store.Market
+	for _, m := range s.markets {
+		data = append(data, m)
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i].Name < data[j].Name })
+	return data, nil
+}
+
+func (s *Sim) GetOpenOrders(ctx context.Context) ([]store.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var data []store.Order
+	for _, o := range s.openOrders("") {
+		data = append(data, o.order)
+	}
+	return data, nil
+}
+
+func (s *Sim) GetOrderHistory(ctx context.Context) ([]store.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var data []store.Order
+	for _, o := range s.orders {
+		data = append(data, o.order)
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i].ID > data[j].ID })
+	return data, nil
+}
+
+func (s *Sim) PlaceOrder(ctx context.Context, param store.PlaceParamsEmb) (*store.Order, error) {
+	s.mu.Lock()
+	m, ok := s.markets[param.Market]
+	if !ok || m.BaseCurrency == nil || m.QuoteCurrency == nil {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchMarket, param.Market)
+	}
+	if !param.Size.IsPositive() || (param.Type == store.OrderTypeLimit && !param.Price.IsPositive()) {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("invalid_order_params: size:%v price:%v", param.Size, param.Price)
+	}
+	price := param.Price
+	if param.Type == store.OrderTypeMarket {
+		t := s.tickers[param.Market]
+		if param.Side == store.SideBuy {
+			price = t.AskPrice
+		} else {
+			price = t.BidPrice
+		}
+		param.Ioc = true
+	}
+	if param.Side == store.SideBuy {
+		if s.free(*m.QuoteCurrency).LessThan(param.Size.Mul(price)) {
+			s.mu.Unlock()
+			return nil, ErrNotEnoughBalance
+		}
+	} else if s.free(*m.BaseCurrency).LessThan(param.Size) {
+		s.mu.Unlock()
+		return nil, ErrNotEnoughBalance
+	}
+	s.lastOrderID++
+	o := &simOrder{
+		order: store.Order{
+			CreatedAt:     s.clock.Now(),
+			UpdatedAt:     s.clock.Now(),
+			Market:        param.Market,
+			Side:          param.Side,
+			Status:        store.OrderStatusNew,
+			Type:          param.Type,
+			Price:         DecimalToFloat64(price),
+			Size:          DecimalToFloat64(param.Size),
+			RemainingSize: DecimalToFloat64(param.Size),
+			ID:            s.lastOrderID,
+			Ioc:           param.Ioc,
+			PostOnly:      param.PostOnly,
+			Paper:         s.paper,
+		},
+		price: price,
+		size:  param.Size,
+	}
+	if param.ClientID != "" {
+		clientID := param.ClientID
+		o.order.ClientID = &clientID
+	}
+	s.orders[o.order.ID] = o
+	resp := o.order
+	s.emitOrder(o)
+
+	if param.PostOnly && s.crossed(o) {
+		s.close(o)
+	} else {
+		o.order.Status = store.OrderStatusOpen
+		s.match(o, liquidityTaker)
+		if o.order.Status != store.OrderStatusClosed && param.Ioc {
+			s.close(o)
+		}
+	}
+	s.mu.Unlock()
+	s.dispatch()
+	return &resp, nil
+}
+
+func (s *Sim) CancelOrder(ctx context.Context, orderID int64) error {
+	s.mu.Lock()
+	o, ok := s.orders[orderID]
+	if !ok {
+		s.mu.Unlock()
+		return ErrOrderNotFound
+	}
+	if o.order.Status == store.OrderStatusClosed {
+		s.mu.Unlock()
+		return ErrOrderAlreadyClosed
+	}
+	s.close(o)
+	s.mu.Unlock()
+	s.dispatch()
+	return nil
+}
+
+func (s *Sim) CancelAllOrders(ctx context.Context) error {
+	s.mu.Lock()
+	for _, o := range s.openOrders("") {
+		s.close(o)
+	}
+	s.mu.Unlock()
+	s.dispatch()
+	return nil
+}
+
+func (s *Sim) Subscribe(orderHandler OrderHandler, fillsHandler FillsHandler, errHandler ErrorHandler) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.orderHandler = orderHandler
+	s.fillsHandler = fillsHandler
+	return nil
+}
+
+func (s *Sim) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.orderHandler = nil
+	s.fillsHandler = nil
+}
+
+func (s *Sim) openOrders(market string) []*simOrder {
+	var data []*simOrder
+	for _, o := range s.orders {
+		if o.order.Status == store.OrderStatusClosed {
+			continue
+		}
+		if market != "" && o.order.Market != market {
+			continue
+		}
+		data = append(data, o)
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i].order.ID < data[j].order.ID })
+	return data
+}
+
+func (s *Sim) free(coin string) decimal.Decimal {
+	free := s.balances[coin]
+	for _, o := range s.orders {
+		if o.order.Status == store.OrderStatusClosed {
+			continue
+		}
+		m := s.markets[o.order.Market]
+		if o.order.Side == store.SideBuy && *m.QuoteCurrency == coin {
+			free = free.Sub(o.remaining().Mul(o.price))
+		} else if o.order.Side == store.SideSell && *m.BaseCurrency == coin {
+			free = free.Sub(o.remaining())
+		}
+	}
+	return free
+}
+
+func (s *Sim) usdPrice(coin string) decimal.Decimal {
+	if coin == "USD" || coin == "USDT" {
+		return decimal.NewFromInt(1)
+	}
+	for _, quote := range []string{"USD", "USDT"} {
+		t, ok := s.tickers[coin+"/"+quote]
+		if ok && t.BidPrice.IsPositive() {
+			return t.BidPrice
+		}
+	}
+	return s.prices[coin]
+}
+
+func (s *Sim) crossed(o *simOrder) bool {
+	t, ok := s.tickers[o.order.Market]
+	if !ok {
+		return false
+	}
+	if o.order.Side == store.SideBuy {
+		return t.AskPrice.IsPositive() && o.price.GreaterThanOrEqual(t.AskPrice)
+	}
+	return t.BidPrice.IsPositive() && o.price.LessThanOrEqual(t.BidPrice)
+}
+
+// match fills the order against the external top of book. Takers trade at the book
+// price, makers at their own limit price.
+func (s *Sim) match(o *simOrder, liquidity string) {
+	if !s.crossed(o) {
+		return
+	}
+	t := s.tickers[o.order.Market]
+	price := o.price
+	qty := o.remaining()
+	if o.order.Side == store.SideBuy {
+		if liquidity == liquidityTaker {
+			price = t.AskPrice
+		}
+		qty = decimal.Min(qty, t.AskQty)
+		t.AskQty = t.AskQty.Sub(qty)
+	} else {
+		if liquidity == liquidityTaker {
+			price = t.BidPrice
+		}
+		qty = decimal.Min(qty, t.BidQty)
+		t.BidQty = t.BidQty.Sub(qty)
+	}
+	if !qty.IsPositive() {
+		return
+	}
+	s.tickers[o.order.Market] = t
+	s.fill(o, price, qty, liquidity)
+}
+
+func (s *Sim) fill(o *simOrder, price decimal.Decimal, qty decimal.Decimal, liquidity string) {
+	m := s.markets[o.order.Market]
+	feeRate := s.account.TakerFee
+	if liquidity == liquidityMaker {
+		feeRate = s.account.MakerFee
+	}
+	value := price.Mul(qty)
+	fee := value.Mul(feeRate)
+	base, quote := *m.BaseCurrency, *m.QuoteCurrency
+	if o.order.Side == store.SideBuy {
+		s.balances[base] = s.balances[base].Add(qty)
+		s.balances[quote] = s.balances[quote].Sub(value).Sub(fee)
+	} else {
+		s.balances[base] = s.balances[base].Sub(qty)
+		s.balances[quote] = s.balances[quote].Add(value).Sub(fee)
+	}
+	o.filled = o.filled.Add(qty)
+	o.cost = o.cost.Add(value)
+	o.order.FilledSize = DecimalToFloat64(o.filled)
+	o.order.RemainingSize = DecimalToFloat64(o.remaining())
+	o.order.AvgFillPrice = DecimalToFloat64(o.cost.Div(o.filled))
+	o.order.UpdatedAt = s.clock.Now()
+
+	s.lastFillID++
+	f := store.Fills{
+		BaseCurrency:  m.BaseCurrency,
+		Fee:           DecimalToFloat64(fee),
+		FeeCurrency:   quote,
+		FeeRate:       DecimalToFloat64(feeRate),
+		ID:            s.lastFillID,
+		Liquidity:     liquidity,
+		Market:        o.order.Market,
+		OrderID:       o.order.ID,
+		Price:         DecimalToFloat64(price),
+		QuoteCurrency: quote,
+		Side:          o.order.Side,
+		Size:          DecimalToFloat64(qty),
+		Time:          s.clock.Now(),
+		TradeID:       s.lastFillID,
+		Type:          "order",
+		Paper:         s.paper,
+	}
+	s.fills = append(s.fills, f)
+	s.pending = append(s.pending, simEvent{fills: &f})
+	if !o.remaining().IsPositive() {
+		s.close(o)
+		return
+	}
+	s.emitOrder(o)
+}
+
+func (s *Sim) close(o *simOrder) {
+	o.order.Status = store.OrderStatusClosed
+	o.order.RemainingSize = 0
+	o.order.UpdatedAt = s.clock.Now()
+	s.emitOrder(o)
+}
+
+func (s *Sim) emitOrder(o *simOrder) {
+	order := o.order
+	s.pending = append(s.pending, simEvent{order: &order})
+}
+
+// dispatch delivers pending events in order. Only one goroutine delivers at a time,
+// events added meanwhile are picked up by it.
+func (s *Sim) dispatch() {
+	s.mu.Lock()
+	if s.dispatching {
+		s.mu.Unlock()
+		return
+	}
+	s.dispatching = true
+	for len(s.pending) > 0 {
+		e := s.pending[0]
+		s.pending = s.pending[1:]
+		orderHandler, fillsHandler := s.orderHandler, s.fillsHandler
+		s.mu.Unlock()
+		if e.order != nil && orderHandler != nil {
+			orderHandler(e.order)
+		}
+		if e.fills != nil && fillsHandler != nil {
+			fillsHandler(e.fills)
+		}
+		s.mu.Lock()
+	}
+	s.dispatching = false
+	s.mu.Unlock()
+}
+
+func simCurrencies(name string) (*string, *string) {
+	split := strings.Split(name, "/")
+	if len(split) != 2 {
+		return nil, nil
+	}
+	return &split[0], &split[1]
+}
+
+// SimMarket builds a spot market description for the simulator.
+func SimMarket(name string, minProvideSize float64, priceIncrement float64) store.Market {
+	base, quote := simCurrencies(name)
+	return store.Market{
+		UpdatedAt:      time.Now(),
+		Name:           name,
+		BaseCurrency:   base,
+		QuoteCurrency:  quote,
+		MinProvideSize: minProvideSize,
+		SizeIncrement:  minProvideSize,
+		PriceIncrement: priceIncrement,
+		Type:           "spot",
+		Enabled:        true,
+	}
+}
@@ -0,0 +1,36 @@
+package venue
+
+import (
+	"context"
+	"errors"
+	"github.com/aibotsoft/crypto-surebet/pkg/store"
+)
+
+var ErrRateLimit = errors.New("error_rate_limit")
+var ErrOrderAlreadyClosed = errors.New("order_already_closed")
+var ErrOrderAlreadyQueued = errors.New("order_already_queued_for_cancellation")
+
+type OrderHandler func(order *store.Order)
+type FillsHandler func(fills *store.Fills)
+type ErrorHandler func(err error)
+
+// Venue is an execution venue the placer trades on. Orders and fills are reported
+// asynchronously to the handlers passed to Subscribe.
+type Venue interface {
+	GetAccount(ctx context.Context) (*store.Account, error)
+	GetBalances(ctx context.Context) ([]store.Balance, error)
+	GetMarkets(ctx context.Context) ([]store.Market, error)
+	GetOpenOrders(ctx context.Context) ([]store.Order, error)
+	GetOrderHistory(ctx context.Context) ([]store.Order, error)
+	PlaceOrder(ctx context.Context, param store.PlaceParamsEmb) (*store.Order, error)
+	CancelOrder(ctx context.Context, orderID int64) error
+	CancelAllOrders(ctx context.Context) error
+	Subscribe(orderHandler OrderHandler, fillsHandler FillsHandler, errHandler ErrorHandler) error
+	Close()
+}
+
+// TickerSink is implemented by venues that match orders against market data pushed
+// by the caller, like the simulator.
+type TickerSink interface {
+	SetTicker(t store.TickerData)
+}
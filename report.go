@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/aibotsoft/crypto-surebet/pkg/config"
+	"github.com/aibotsoft/crypto-surebet/pkg/store"
+	"github.com/aibotsoft/crypto-surebet/services/report"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"time"
+)
+
+func runReport(cfg *config.Config, log *zap.Logger, args []string) error {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	from := fs.String("from", time.Now().AddDate(0, 0, -7).Format(time.RFC3339), "surebets placed from, RFC3339")
+	to := fs.String("to", time.Now().Format(time.RFC3339), "surebets placed to, RFC3339")
+	period := fs.String("period", "day", "day or week")
+	format := fs.String("format", "md", "md, csv or json")
+	top := fs.Int("top", 5, "markets in the top and bottom lists")
+	paper := fs.Bool("paper", false, "report paper surebets instead of live ones")
+	out := fs.String("out", "", "write to file instead of stdout")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	fromTime, err := time.Parse(time.RFC3339, *from)
+	if err != nil {
+		return fmt.Errorf("parse_from_error: %w", err)
+	}
+	toTime, err := time.Parse(time.RFC3339, *to)
+	if err != nil {
+		return fmt.Errorf("parse_to_error: %w", err)
+	}
+	p, err := report.ParsePeriod(*period)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sto, err := store.NewStore(cfg, log, ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = sto.Close() }()
+	rep, err := report.Build(sto, fromTime, toTime, p, *paper, *top)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	return rep.Write(w, *format)
+}
@@ -0,0 +1,198 @@
+package backtest
+
+import (
+	"context"
+	"fmt"
+	"github.com/aibotsoft/crypto-surebet/pkg/clock"
+	"github.com/aibotsoft/crypto-surebet/pkg/config"
+	"github.com/aibotsoft/crypto-surebet/pkg/store"
+	"github.com/aibotsoft/crypto-surebet/pkg/venue"
+	"github.com/aibotsoft/crypto-surebet/services/placer"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"io"
+	"time"
+)
+
+// maxLockTime replaces Service.MaxLockTime. Simulated time does not move while Calc
+// waits for a symbol lock, so waiting longer can not change the outcome.
+const maxLockTime = time.Millisecond
+
+type Options struct {
+	Markets  []store.Market
+	MakerFee decimal.Decimal
+	TakerFee decimal.Decimal
+	// QuoteBalance is the starting balance of every quote currency.
+	QuoteBalance decimal.Decimal
+	// CoinBalance is the starting USD value of every base coin, converted at the first price seen.
+	CoinBalance decimal.Decimal
+}
+
+// Engine replays recorded surebets through Placer.Calc against the simulated venue
+// on a simulated clock.
+type Engine struct {
+	cfg *config.Config
+	log *zap.Logger
+	opt Options
+}
+
+func NewEngine(cfg *config.Config, log *zap.Logger, opt Options) *Engine {
+	return &Engine{cfg: cfg, log: log, opt: opt}
+}
+
+type run struct {
+	engine    *Engine
+	clock     *clock.Sim
+	sim       *venue.Sim
+	mem       *store.Memory
+	placer    *placer.Placer
+	markets   map[string]store.Market
+	seeded    map[string]bool
+	stats     map[string]*MarketReport
+	lastCheck time.Time
+	first     time.Time
+	last      time.Time
+}
+
+func (e *Engine) Run(ctx context.Context, src Source) (*Report, error) {
+	in, err := src.Next()
+	if err == io.EOF {
+		return nil, fmt.Errorf("backtest_source_empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	r, err := e.newRun(ctx, time.Unix(0, in.Received))
+	if err != nil {
+		return nil, err
+	}
+	for {
+		r.step(in)
+		in, err = src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	//let the last bets be canceled and healed
+	r.clock.Set(r.last.Add(e.cfg.Service.BetCancelPeriod))
+	r.settle()
+	return r.report(ctx)
+}
+
+func (e *Engine) newRun(ctx context.Context, start time.Time) (*run, error) {
+	cfg := *e.cfg
+	cfg.Service.MaxLockTime = maxLockTime
+	cfg.Service.PaperMode = false
+	cfg.Service.DemoMode = false
+
+	r := &run{
+		engine:    e,
+		clock:     clock.NewSim(start),
+		mem:       store.NewMemory(),
+		markets:   make(map[string]store.Market),
+		seeded:    make(map[string]bool),
+		stats:     make(map[string]*MarketReport),
+		lastCheck: start,
+		first:     start,
+	}
+	r.sim = venue.NewSim(e.log)
+	r.sim.SetClock(r.clock)
+	r.sim.SetFees(e.opt.MakerFee, e.opt.TakerFee)
+	for _, m := range e.opt.Markets {
+		r.addMarket(m)
+	}
+	p, err := placer.NewPlacer(&cfg, e.log, ctx, r.mem, r.sim)
+	if err != nil {
+		return nil, err
+	}
+	p.SetClock(r.clock)
+	err = p.Start()
+	if err != nil {
+		return nil, err
+	}
+	r.placer = p
+	return r, nil
+}
+
+func (r *run) addMarket(m store.Market) {
+	if m.BaseCurrency == nil || m.QuoteCurrency == nil {
+		return
+	}
+	r.markets[m.Name] = m
+	r.sim.AddMarket(m)
+	if !r.seeded[*m.QuoteCurrency] {
+		r.sim.SetBalance(*m.QuoteCurrency, r.engine.opt.QuoteBalance)
+		r.seeded[*m.QuoteCurrency] = true
+	}
+}
+
+// settle waits until every reaction to the last event is finished.
+func (r *run) settle() {
+	for {
+		r.placer.Wait()
+		if !r.placer.Drain() {
+			return
+		}
+	}
+}
+
+func (r *run) step(in *Input) {
+	sb := *in.Surebet
+	if sb.FtxTicker == nil || sb.BinTicker == nil {
+		return
+	}
+	now := time.Unix(0, in.Received)
+	r.clock.Set(now)
+	r.settle()
+	if now.Sub(r.lastCheck) > r.engine.cfg.Service.ReHealPeriod+time.Second {
+		_ = r.placer.GetOpenOrders()
+		r.settle()
+		r.lastCheck = now
+	}
+	r.last = now
+	symbol := sb.FtxTicker.Symbol
+	st := r.marketStat(symbol)
+	st.Inputs++
+	st.LastPrice = sb.FtxTicker.BidPrice.Add(sb.FtxTicker.AskPrice).Div(decimal.NewFromInt(2))
+
+	m, ok := r.markets[symbol]
+	if !ok && sb.Market != nil {
+		m = venue.SimMarket(symbol, sb.Market.MinProvideSize.InexactFloat64(), sb.Market.PriceIncrement.InexactFloat64())
+		r.addMarket(m)
+		ok = true
+		_ = r.placer.GetMarkets()
+	}
+	if !ok {
+		st.Skipped++
+		return
+	}
+	r.sim.SetTicker(*sb.FtxTicker)
+	r.settle()
+	if base := *m.BaseCurrency; !r.seeded[base] && st.LastPrice.IsPositive() {
+		r.sim.SetBalance(base, r.engine.opt.CoinBalance.Div(st.LastPrice))
+		r.seeded[base] = true
+		_ = r.placer.GetBalances()
+	}
+	lock := r.placer.Calc(&sb)
+	if lock != nil {
+		<-lock
+	}
+	r.settle()
+}
+
+func (r *run) marketStat(market string) *MarketReport {
+	st, ok := r.stats[market]
+	if !ok {
+		st = &MarketReport{Market: market}
+		r.stats[market] = st
+	}
+	return st
+}
@@ -0,0 +1,119 @@
+package backtest
+
+import (
+	"context"
+	"github.com/aibotsoft/crypto-surebet/pkg/config"
+	"github.com/aibotsoft/crypto-surebet/pkg/store"
+	"github.com/aibotsoft/crypto-surebet/pkg/venue"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+const testMarket = "BTC/USD"
+
+func testConfig() *config.Config {
+	var cfg config.Config
+	cfg.Service.TargetProfit = 0.1
+	cfg.Service.TargetAmount = 10
+	cfg.Service.BinFtxVolumeRatio = 2
+	cfg.Service.ProfitDiffRatio = 2
+	cfg.Service.AvgPriceDiffRatio = 10
+	cfg.Service.ProfitIncRatio = 1
+	cfg.Service.MaxStake = 100
+	cfg.Service.MinVolume = 10
+	cfg.Service.RehealThreshold = 0.1
+	cfg.Service.SizeRatioMultiplayer = 1
+	cfg.Service.SendReceiveMaxDelay = time.Second
+	cfg.Service.MaxLockTime = 100 * time.Millisecond
+	cfg.Service.ReHealPeriod = 200 * time.Millisecond
+	cfg.Service.BetCancelPeriod = 50 * time.Millisecond
+	return &cfg
+}
+
+func ticker(bid, ask float64) *store.TickerData {
+	return &store.TickerData{
+		Symbol:   testMarket,
+		BidPrice: decimal.NewFromFloat(bid),
+		BidQty:   decimal.NewFromInt(1),
+		AskPrice: decimal.NewFromFloat(ask),
+		AskQty:   decimal.NewFromInt(1),
+	}
+}
+
+func input(at time.Time, ftxBid, ftxAsk, binBid, binAsk float64) Input {
+	return Input{
+		Received: at.Add(10 * time.Millisecond).UnixNano(),
+		Surebet: &store.Surebet{
+			ID:        at.UnixNano(),
+			FtxTicker: ticker(ftxBid, ftxAsk),
+			BinTicker: ticker(binBid, binAsk),
+			UsdtPrice: decimal.NewFromInt(1),
+		},
+	}
+}
+
+func TestEngineBetAndHeal(t *testing.T) {
+	start := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	src := NewSliceSource([]Input{
+		input(start, 19990, 20000, 20100, 20110),
+		input(start.Add(time.Second), 20050, 20060, 20050, 20060),
+		input(start.Add(2*time.Second), 20050, 20060, 20050, 20060),
+	})
+	engine := NewEngine(testConfig(), zap.NewNop(), Options{
+		Markets:      []store.Market{venue.SimMarket(testMarket, 0.0001, 1)},
+		MakerFee:     decimal.NewFromFloat(0.0002),
+		TakerFee:     decimal.NewFromFloat(0.0007),
+		QuoteBalance: decimal.NewFromInt(10000),
+		CoinBalance:  decimal.NewFromInt(20000),
+	})
+	rep, err := engine.Run(context.Background(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Markets) != 1 {
+		t.Fatalf("markets %d, want 1", len(rep.Markets))
+	}
+	m := rep.Markets[0]
+	if m.Inputs != 3 {
+		t.Fatalf("inputs %d, want 3", m.Inputs)
+	}
+	if m.Bets != 1 || m.FilledBets != 1 {
+		t.Fatalf("bets %d filled %d, want 1 and 1", m.Bets, m.FilledBets)
+	}
+	if m.Heals != 1 || m.Healed != 1 {
+		t.Fatalf("heals %d healed %d, want 1 and 1", m.Heals, m.Healed)
+	}
+	if !m.OpenQty.IsZero() {
+		t.Fatalf("open qty %v, want 0", m.OpenQty)
+	}
+	if !m.Pnl.IsPositive() {
+		t.Fatalf("pnl %v, want positive", m.Pnl)
+	}
+	if m.AvgHealTime <= 0 || m.AvgHealTime > 2*time.Second {
+		t.Fatalf("avg heal time %v", m.AvgHealTime)
+	}
+	if !rep.Total.FillRate.Equal(m.FillRate) {
+		t.Fatalf("total fill rate %v, market %v", rep.Total.FillRate, m.FillRate)
+	}
+}
+
+func TestReportUnplacedHeal(t *testing.T) {
+	r := &run{sim: venue.NewSim(zap.NewNop()), mem: store.NewMemory(), stats: make(map[string]*MarketReport)}
+	// a heal under the min size is saved without orders
+	r.mem.SaveHeal(&store.Heal{
+		ID:          1,
+		Start:       time.Now().UnixNano(),
+		FilledSize:  decimal.NewFromFloat(0.00005),
+		MinSize:     decimal.NewFromFloat(0.0001),
+		PlaceParams: store.PlaceParamsEmb{Market: testMarket},
+	})
+	rep, err := r.report(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := rep.Total; st.Heals != 1 || st.Healed != 0 || st.AvgHealTime != 0 || st.MaxHealTime != 0 {
+		t.Fatalf("total %+v", st)
+	}
+}
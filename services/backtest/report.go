@@ -0,0 +1,181 @@
+package backtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aibotsoft/crypto-surebet/pkg/store"
+	"github.com/aibotsoft/crypto-surebet/services/placer"
+	"github.com/shopspring/decimal"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+type MarketReport struct {
+	Market        string          `json:"market"`
+	Inputs        int64           `json:"inputs"`
+	Skipped       int64           `json:"skipped"`
+	Bets          int64           `json:"bets"`
+	FilledBets    int64           `json:"filled_bets"`
+	BetVolume     decimal.Decimal `json:"bet_volume"`
+	FilledVolume  decimal.Decimal `json:"filled_volume"`
+	FillRate      decimal.Decimal `json:"fill_rate"`
+	Heals         int64           `json:"heals"`
+	Healed        int64           `json:"healed"`
+	AvgHealTime   time.Duration   `json:"avg_heal_time"`
+	MaxHealTime   time.Duration   `json:"max_heal_time"`
+	Fee           decimal.Decimal `json:"fee"`
+	OpenQty       decimal.Decimal `json:"open_qty"`
+	LastPrice     decimal.Decimal `json:"last_price"`
+	Pnl           decimal.Decimal `json:"pnl"`
+	betSize       decimal.Decimal
+	betFilledSize decimal.Decimal
+	healTime      time.Duration
+}
+
+type Report struct {
+	From    time.Time       `json:"from"`
+	To      time.Time       `json:"to"`
+	Markets []*MarketReport `json:"markets"`
+	Total   *MarketReport   `json:"total"`
+}
+
+func (r *run) report(ctx context.Context) (*Report, error) {
+	orders, err := r.sim.GetOrderHistory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	orderMap := make(map[int64]store.Order, len(orders))
+	for _, o := range orders {
+		orderMap[o.ID] = o
+		if o.ClientID == nil {
+			continue
+		}
+		clientID, err := placer.UnmarshalClientID(*o.ClientID)
+		if err != nil || clientID.Side != placer.BET {
+			continue
+		}
+		st := r.marketStat(o.Market)
+		st.Bets++
+		st.BetVolume = st.BetVolume.Add(decimal.NewFromFloat(o.Size * o.Price))
+		st.betSize = st.betSize.Add(decimal.NewFromFloat(o.Size))
+		if o.FilledSize > 0 {
+			st.FilledBets++
+			st.FilledVolume = st.FilledVolume.Add(decimal.NewFromFloat(o.FilledSize * o.AvgFillPrice))
+			st.betFilledSize = st.betFilledSize.Add(decimal.NewFromFloat(o.FilledSize))
+		}
+	}
+	lastFill := make(map[int64]time.Time)
+	cash := make(map[string]decimal.Decimal)
+	for _, f := range r.sim.Fills() {
+		st := r.marketStat(f.Market)
+		value := decimal.NewFromFloat(f.Price * f.Size)
+		size := decimal.NewFromFloat(f.Size)
+		if f.Side == store.SideBuy {
+			cash[f.Market] = cash[f.Market].Sub(value)
+			st.OpenQty = st.OpenQty.Add(size)
+		} else {
+			cash[f.Market] = cash[f.Market].Add(value)
+			st.OpenQty = st.OpenQty.Sub(size)
+		}
+		fee := decimal.NewFromFloat(f.Fee)
+		st.Fee = st.Fee.Add(fee)
+		cash[f.Market] = cash[f.Market].Sub(fee)
+		if f.Time.After(lastFill[f.OrderID]) {
+			lastFill[f.OrderID] = f.Time
+		}
+	}
+	for _, h := range r.mem.Heals() {
+		st := r.marketStat(h.PlaceParams.Market)
+		st.Heals++
+		var filled decimal.Decimal
+		var last time.Time
+		for _, ho := range h.Orders {
+			o, ok := orderMap[ho.ID]
+			if !ok {
+				continue
+			}
+			filled = filled.Add(decimal.NewFromFloat(o.FilledSize))
+			if lastFill[o.ID].After(last) {
+				last = lastFill[o.ID]
+			}
+		}
+		// a heal too small to place has no orders, it is not healed
+		if !filled.IsPositive() || last.IsZero() || h.FilledSize.Sub(filled).GreaterThanOrEqual(h.MinSize) {
+			continue
+		}
+		st.Healed++
+		el := last.Sub(time.Unix(0, h.Start))
+		st.healTime += el
+		if el > st.MaxHealTime {
+			st.MaxHealTime = el
+		}
+	}
+
+	rep := &Report{From: r.first, To: r.last, Total: &MarketReport{Market: "total"}}
+	for market, st := range r.stats {
+		if st.Bets == 0 && st.Heals == 0 && st.OpenQty.IsZero() {
+			continue
+		}
+		st.Pnl = cash[market].Add(st.OpenQty.Mul(st.LastPrice))
+		rep.Markets = append(rep.Markets, st)
+		rep.Total.add(st)
+	}
+	sort.Slice(rep.Markets, func(i, j int) bool {
+		return rep.Markets[i].Market < rep.Markets[j].Market
+	})
+	for _, st := range r.stats {
+		rep.Total.Inputs += st.Inputs
+		rep.Total.Skipped += st.Skipped
+	}
+	for _, st := range append(rep.Markets, rep.Total) {
+		st.done()
+	}
+	return rep, nil
+}
+
+func (t *MarketReport) add(st *MarketReport) {
+	t.Bets += st.Bets
+	t.FilledBets += st.FilledBets
+	t.BetVolume = t.BetVolume.Add(st.BetVolume)
+	t.FilledVolume = t.FilledVolume.Add(st.FilledVolume)
+	t.betSize = t.betSize.Add(st.betSize)
+	t.betFilledSize = t.betFilledSize.Add(st.betFilledSize)
+	t.Heals += st.Heals
+	t.Healed += st.Healed
+	t.healTime += st.healTime
+	if st.MaxHealTime > t.MaxHealTime {
+		t.MaxHealTime = st.MaxHealTime
+	}
+	t.Fee = t.Fee.Add(st.Fee)
+	t.Pnl = t.Pnl.Add(st.Pnl)
+}
+
+func (t *MarketReport) done() {
+	if t.betSize.IsPositive() {
+		t.FillRate = t.betFilledSize.Div(t.betSize).Round(4)
+	}
+	if t.Healed > 0 {
+		t.AvgHealTime = t.healTime / time.Duration(t.Healed)
+	}
+}
+
+func (rep *Report) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "market\tinputs\tskipped\tbets\tfilled\tvolume\tfill_rate\theals\thealed\tavg_heal\tmax_heal\tfee\topen_qty\tpnl\t\n")
+	for _, st := range append(rep.Markets, rep.Total) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%s\t%s\t%d\t%d\t%v\t%v\t%s\t%s\t%s\t\n",
+			st.Market, st.Inputs, st.Skipped, st.Bets, st.FilledBets, st.FilledVolume.StringFixed(2), st.FillRate.String(),
+			st.Heals, st.Healed, st.AvgHealTime.Round(time.Millisecond), st.MaxHealTime.Round(time.Millisecond),
+			st.Fee.StringFixed(4), st.OpenQty.String(), st.Pnl.StringFixed(4))
+	}
+	return tw.Flush()
+}
+
+func (rep *Report) JSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rep)
+}
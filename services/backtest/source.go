@@ -0,0 +1,131 @@
+package backtest
+
+import (
+	"github.com/aibotsoft/crypto-surebet/pkg/capture"
+	"github.com/aibotsoft/crypto-surebet/pkg/store"
+	"io"
+	"time"
+)
+
+const storePageSize = 1000
+
+// Input is a surebet as it was received by the placer.
+type Input struct {
+	Received int64
+	Surebet  *store.Surebet
+}
+
+// Source yields inputs in receive order and io.EOF after the last one.
+type Source interface {
+	Next() (*Input, error)
+}
+
+// replayable keeps only the fields set by the surebet producer, everything else is
+// recalculated by Calc.
+func replayable(sb *store.Surebet) *store.Surebet {
+	return &store.Surebet{
+		ID:           sb.ID,
+		LastBinTime:  sb.LastBinTime,
+		BinTicker:    sb.BinTicker,
+		FtxTicker:    sb.FtxTicker,
+		AvgPriceDiff: sb.AvgPriceDiff,
+		MaxPriceDiff: sb.MaxPriceDiff,
+		MinPriceDiff: sb.MinPriceDiff,
+		UsdtPrice:    sb.UsdtPrice,
+		ConnReused:   sb.ConnReused,
+	}
+}
+
+type storeSource struct {
+	sto     *store.Store
+	afterID int64
+	toID    int64
+	buf     []store.Surebet
+	done    bool
+}
+
+// NewStoreSource replays rows of the surebets table. Only surebets that were placed
+// and got a fill are kept there, so this source is biased towards taken bets.
+func NewStoreSource(sto *store.Store, from time.Time, to time.Time) Source {
+	return &storeSource{sto: sto, afterID: from.UnixNano(), toID: to.UnixNano()}
+}
+
+func (s *storeSource) Next() (*Input, error) {
+	if len(s.buf) == 0 {
+		if s.done {
+			return nil, io.EOF
+		}
+		data, err := s.sto.SelectSurebets(s.afterID, s.toID, storePageSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) < storePageSize {
+			s.done = true
+		}
+		if len(data) == 0 {
+			return nil, io.EOF
+		}
+		s.buf = data
+		s.afterID = data[len(data)-1].ID
+	}
+	sb := s.buf[0]
+	s.buf = s.buf[1:]
+	in := &Input{Received: sb.StartTime, Surebet: replayable(&sb)}
+	if in.Received == 0 {
+		in.Received = sb.ID
+	}
+	if sb.Market != nil && sb.Market.BaseCurrency != "" {
+		in.Surebet.Market = sb.Market
+	}
+	return in, nil
+}
+
+type captureSource struct {
+	reader *capture.Reader
+}
+
+// NewCaptureSource replays a capture, it has every received surebet including the
+// rejected ones.
+func NewCaptureSource(reader *capture.Reader) Source {
+	return &captureSource{reader: reader}
+}
+
+func (s *captureSource) Next() (*Input, error) {
+	rec, err := s.reader.Next()
+	if err != nil {
+		return nil, err
+	}
+	return &Input{Received: rec.Received, Surebet: replayable(rec.Surebet)}, nil
+}
+
+type sliceSource struct {
+	data []Input
+}
+
+// NewSliceSource replays inputs loaded before, e.g. by ReadAll.
+func NewSliceSource(data []Input) Source {
+	return &sliceSource{data: data}
+}
+
+func (s *sliceSource) Next() (*Input, error) {
+	if len(s.data) == 0 {
+		return nil, io.EOF
+	}
+	in := s.data[0]
+	s.data = s.data[1:]
+	return &in, nil
+}
+
+func ReadAll(src Source) ([]Input, error) {
+	var data []Input
+	for {
+		in, err := src.Next()
+		if err == io.EOF {
+			return data, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, *in)
+	}
+}
@@ -0,0 +1,251 @@
+package backtest
+
+import (
+	"context"
+	"fmt"
+	"github.com/aibotsoft/crypto-surebet/pkg/config"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"io"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// paramSetters lists the PlaceConfig tunables by their config name.
+var paramSetters = map[string]func(cfg *config.Config, v float64){
+	"max_stake":              func(cfg *config.Config, v float64) { cfg.Service.MaxStake = int64(v) },
+	"target_profit":          func(cfg *config.Config, v float64) { cfg.Service.TargetProfit = v },
+	"target_amount":          func(cfg *config.Config, v float64) { cfg.Service.TargetAmount = int64(v) },
+	"referral_rate":          func(cfg *config.Config, v float64) { cfg.Service.ReferralRate = v },
+	"bin_ftx_volume_ratio":   func(cfg *config.Config, v float64) { cfg.Service.BinFtxVolumeRatio = int64(v) },
+	"profit_diff_ratio":      func(cfg *config.Config, v float64) { cfg.Service.ProfitDiffRatio = int64(v) },
+	"avg_price_diff_ratio":   func(cfg *config.Config, v float64) { cfg.Service.AvgPriceDiffRatio = int64(v) },
+	"profit_inc_ratio":       func(cfg *config.Config, v float64) { cfg.Service.ProfitIncRatio = int64(v) },
+	"min_volume":             func(cfg *config.Config, v float64) { cfg.Service.MinVolume = int64(v) },
+	"reheal_threshold":       func(cfg *config.Config, v float64) { cfg.Service.RehealThreshold = v },
+	"size_ratio_multiplayer": func(cfg *config.Config, v float64) { cfg.Service.SizeRatioMultiplayer = int64(v) },
+	"inventory_target":       func(cfg *config.Config, v float64) { cfg.Service.InventoryTarget = v },
+	"inventory_skew":         func(cfg *config.Config, v float64) { cfg.Service.InventorySkew = v },
+}
+
+type Param struct {
+	Name   string
+	Values []float64
+}
+
+// ParseParam parses name=v1,v2,v3 or name=from:to:step.
+func ParseParam(s string) (Param, error) {
+	name, list, ok := strings.Cut(s, "=")
+	if !ok {
+		return Param{}, fmt.Errorf("param_format_error: %s", s)
+	}
+	p := Param{Name: strings.TrimSpace(name)}
+	if _, ok := paramSetters[p.Name]; !ok {
+		return Param{}, fmt.Errorf("unknown_param: %s", p.Name)
+	}
+	if parts := strings.Split(list, ":"); len(parts) == 3 {
+		var r [3]float64
+		for i, part := range parts {
+			v, err := strconv.ParseFloat(part, 64)
+			if err != nil {
+				return Param{}, fmt.Errorf("param_value_error: %s: %w", s, err)
+			}
+			r[i] = v
+		}
+		if r[2] <= 0 || r[1] < r[0] {
+			return Param{}, fmt.Errorf("param_range_error: %s", s)
+		}
+		from, to, step := decimal.NewFromFloat(r[0]), decimal.NewFromFloat(r[1]), decimal.NewFromFloat(r[2])
+		for v := from; v.LessThanOrEqual(to); v = v.Add(step) {
+			p.Values = append(p.Values, v.InexactFloat64())
+		}
+		return p, nil
+	}
+	for _, part := range strings.Split(list, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return Param{}, fmt.Errorf("param_value_error: %s: %w", s, err)
+		}
+		p.Values = append(p.Values, v)
+	}
+	return p, nil
+}
+
+type ParamSet map[string]float64
+
+func (ps ParamSet) String() string {
+	keys := make([]string, 0, len(ps))
+	for k := range ps {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%v", k, ps[k]))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (ps ParamSet) apply(cfg *config.Config) {
+	for name, v := range ps {
+		paramSetters[name](cfg, v)
+	}
+}
+
+// Grid returns every combination of the param values.
+func Grid(params []Param) []ParamSet {
+	list := []ParamSet{{}}
+	for _, p := range params {
+		var next []ParamSet
+		for _, ps := range list {
+			for _, v := range p.Values {
+				c := make(ParamSet, len(ps)+1)
+				for k, old := range ps {
+					c[k] = old
+				}
+				c[p.Name] = v
+				next = append(next, c)
+			}
+		}
+		list = next
+	}
+	return list
+}
+
+// Random returns n combinations with every value picked at random, duplicates are skipped.
+func Random(params []Param, n int, seed int64) []ParamSet {
+	rnd := rand.New(rand.NewSource(seed))
+	total := 1
+	for _, p := range params {
+		total *= len(p.Values)
+	}
+	if n > total {
+		n = total
+	}
+	seen := make(map[string]bool)
+	var list []ParamSet
+	for len(list) < n {
+		ps := make(ParamSet, len(params))
+		for _, p := range params {
+			ps[p.Name] = p.Values[rnd.Intn(len(p.Values))]
+		}
+		if seen[ps.String()] {
+			continue
+		}
+		seen[ps.String()] = true
+		list = append(list, ps)
+	}
+	return list
+}
+
+type SweepResult struct {
+	Params ParamSet        `json:"params"`
+	Pnl    decimal.Decimal `json:"pnl"`
+	Volume decimal.Decimal `json:"volume"`
+	// Inventory is the USD value of the coins left unhealed at the end.
+	Inventory decimal.Decimal `json:"inventory"`
+	Bets      int64           `json:"bets"`
+	Heals     int64           `json:"heals"`
+	// HealFail is the part of heals not filled at the end.
+	HealFail decimal.Decimal `json:"heal_fail"`
+	Err      string          `json:"error,omitempty"`
+}
+
+func newSweepResult(ps ParamSet, rep *Report) SweepResult {
+	res := SweepResult{
+		Params: ps,
+		Pnl:    rep.Total.Pnl,
+		Volume: rep.Total.FilledVolume,
+		Bets:   rep.Total.Bets,
+		Heals:  rep.Total.Heals,
+	}
+	for _, m := range rep.Markets {
+		res.Inventory = res.Inventory.Add(m.OpenQty.Mul(m.LastPrice).Abs())
+	}
+	if rep.Total.Heals > 0 {
+		res.HealFail = decimal.NewFromInt(rep.Total.Heals-rep.Total.Healed).DivRound(decimal.NewFromInt(rep.Total.Heals), 4)
+	}
+	return res
+}
+
+// Sweep replays data once for every param set on workers goroutines.
+func Sweep(ctx context.Context, cfg *config.Config, opt Options, data []Input, sets []ParamSet, workers int) []SweepResult {
+	if workers < 1 {
+		workers = 1
+	}
+	results := make([]SweepResult, len(sets))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				c := *cfg
+				sets[i].apply(&c)
+				rep, err := NewEngine(&c, zap.NewNop(), opt).Run(ctx, NewSliceSource(data))
+				if err != nil {
+					results[i] = SweepResult{Params: sets[i], Err: err.Error()}
+					continue
+				}
+				results[i] = newSweepResult(sets[i], rep)
+			}
+		}()
+	}
+dispatch:
+	for i := range sets {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			for ; i < len(sets); i++ {
+				results[i] = SweepResult{Params: sets[i], Err: ctx.Err().Error()}
+			}
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// SortResults orders by the key descending, inventory and heal_fail ascending.
+func SortResults(results []SweepResult, key string) error {
+	var less func(a, b SweepResult) bool
+	switch key {
+	case "pnl":
+		less = func(a, b SweepResult) bool { return a.Pnl.GreaterThan(b.Pnl) }
+	case "volume":
+		less = func(a, b SweepResult) bool { return a.Volume.GreaterThan(b.Volume) }
+	case "inventory":
+		less = func(a, b SweepResult) bool { return a.Inventory.LessThan(b.Inventory) }
+	case "heal_fail":
+		less = func(a, b SweepResult) bool { return a.HealFail.LessThan(b.HealFail) }
+	default:
+		return fmt.Errorf("unknown_sort_key: %s", key)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if (results[i].Err == "") != (results[j].Err == "") {
+			return results[i].Err == ""
+		}
+		return less(results[i], results[j])
+	})
+	return nil
+}
+
+func PrintSweep(w io.Writer, results []SweepResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "rank\tpnl\tvolume\tinventory\theal_fail\tbets\theals\tparams\n")
+	for i, r := range results {
+		if r.Err != "" {
+			fmt.Fprintf(tw, "%d\t-\t-\t-\t-\t-\t-\t%s error: %s\n", i+1, r.Params, r.Err)
+			continue
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%d\t%d\t%s\n", i+1, r.Pnl.StringFixed(4), r.Volume.StringFixed(2),
+			r.Inventory.StringFixed(2), r.HealFail.String(), r.Bets, r.Heals, r.Params)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,70 @@
+package backtest
+
+import (
+	"context"
+	"github.com/aibotsoft/crypto-surebet/pkg/store"
+	"github.com/aibotsoft/crypto-surebet/pkg/venue"
+	"github.com/shopspring/decimal"
+	"testing"
+	"time"
+)
+
+func TestParseParamGrid(t *testing.T) {
+	p1, err := ParseParam("target_profit=0.05:0.15:0.05")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p1.Values) != 3 || p1.Values[2] != 0.15 {
+		t.Fatalf("range values %v", p1.Values)
+	}
+	p2, err := ParseParam("max_stake=50,100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(Grid([]Param{p1, p2})); got != 6 {
+		t.Fatalf("grid %d, want 6", got)
+	}
+	if got := len(Random([]Param{p1, p2}, 100, 1)); got != 6 {
+		t.Fatalf("random %d, want all 6", got)
+	}
+	_, err = ParseParam("no_such_param=1")
+	if err == nil {
+		t.Fatal("want unknown param error")
+	}
+}
+
+func TestSweep(t *testing.T) {
+	start := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	data := []Input{
+		input(start, 19990, 20000, 20100, 20110),
+		input(start.Add(time.Second), 20050, 20060, 20050, 20060),
+	}
+	opt := Options{
+		Markets:      []store.Market{venue.SimMarket(testMarket, 0.0001, 1)},
+		MakerFee:     decimal.NewFromFloat(0.0002),
+		TakerFee:     decimal.NewFromFloat(0.0007),
+		QuoteBalance: decimal.NewFromInt(10000),
+		CoinBalance:  decimal.NewFromInt(20000),
+	}
+	//the surebet has about 0.5% buy profit, a higher target rejects it
+	sets := []ParamSet{{"target_profit": 5}, {"target_profit": 0.1}}
+	results := Sweep(context.Background(), testConfig(), opt, data, sets, 2)
+	err := SortResults(results, "pnl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Err != "" || results[0].Params["target_profit"] != 0.1 || results[0].Bets != 1 {
+		t.Fatalf("best %+v", results[0])
+	}
+	if results[1].Bets != 0 {
+		t.Fatalf("worst %+v", results[1])
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, res := range Sweep(ctx, testConfig(), opt, data, sets, 1) {
+		if res.Err == "" || res.Params == nil {
+			t.Fatalf("canceled sweep %+v", res)
+		}
+	}
+}
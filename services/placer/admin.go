@@ -0,0 +1,458 @@
+package placer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aibotsoft/crypto-surebet/pkg/store"
+	"go.uber.org/zap"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var ErrHealNotFound = errors.New("heal_not_found")
+
+type adminError struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, adminError{Error: err.Error()})
+}
+
+// adminGet and adminPost wrap handlers returning a value to encode or an error.
+func adminGet(h func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return adminHandler(http.MethodGet, h)
+}
+
+func adminPost(h func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return adminHandler(http.MethodPost, h)
+}
+
+func adminHandler(method string, h func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method_not_allowed"))
+			return
+		}
+		v, err := h(r)
+		if err != nil {
+			status := http.StatusInternalServerError
+			var bad badRequest
+			if errors.As(err, &bad) {
+				status = http.StatusBadRequest
+			} else if errors.Is(err, ErrHealNotFound) {
+				status = http.StatusNotFound
+			}
+			writeError(w, status, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, v)
+	}
+}
+
+type badRequest struct {
+	msg string
+}
+
+func (e badRequest) Error() string {
+	return e.msg
+}
+
+func queryInt64(r *http.Request, name string) (int64, error) {
+	v, err := strconv.ParseInt(r.URL.Query().Get(name), 10, 64)
+	if err != nil {
+		return 0, badRequest{msg: fmt.Sprintf("bad_%s", name)}
+	}
+	return v, nil
+}
+
+func queryDuration(r *http.Request, name string, def time.Duration) (time.Duration, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, badRequest{msg: fmt.Sprintf("bad_%s", name)}
+	}
+	return d, nil
+}
+
+func queryBool(r *http.Request, name string, def bool) (bool, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, badRequest{msg: fmt.Sprintf("bad_%s", name)}
+	}
+	return b, nil
+}
+
+func (p *Placer) adminRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/admin/orders", adminGet(func(r *http.Request) (interface{}, error) {
+		return p.OpenOrders(), nil
+	}))
+	mux.HandleFunc("/admin/surebets", adminGet(func(r *http.Request) (interface{}, error) {
+		return p.InflightSurebets(), nil
+	}))
+	mux.HandleFunc("/admin/heals", adminGet(func(r *http.Request) (interface{}, error) {
+		return p.PendingHeals(), nil
+	}))
+	mux.HandleFunc("/admin/balances", adminGet(func(r *http.Request) (interface{}, error) {
+		return p.Balances(), nil
+	}))
+	mux.HandleFunc("/admin/locks", adminGet(func(r *http.Request) (interface{}, error) {
+		locks := p.activeLocks()
+		sort.Strings(locks)
+		return locks, nil
+	}))
+	mux.HandleFunc("/admin/config", adminGet(func(r *http.Request) (interface{}, error) {
+		return p.effectiveConfig(r.URL.Query().Get("market")), nil
+	}))
+	mux.HandleFunc("/admin/funnel", adminGet(func(r *http.Request) (interface{}, error) {
+		window, err := queryDuration(r, "window", time.Hour)
+		if err != nil {
+			return nil, err
+		}
+		return p.Funnel(window), nil
+	}))
+	mux.HandleFunc("/admin/decisions", adminGet(func(r *http.Request) (interface{}, error) {
+		n := int64(100)
+		if r.URL.Query().Get("n") != "" {
+			var err error
+			n, err = queryInt64(r, "n")
+			if err != nil {
+				return nil, err
+			}
+		}
+		return p.Decisions(int(n)), nil
+	}))
+	mux.HandleFunc("/admin/rejects", adminGet(func(r *http.Request) (interface{}, error) {
+		return p.RejectStats(), nil
+	}))
+	mux.HandleFunc("/admin/pauses", adminGet(func(r *http.Request) (interface{}, error) {
+		return p.Pauses(), nil
+	}))
+
+	mux.HandleFunc("/admin/kill", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			writeJSON(w, http.StatusOK, p.Killed())
+			return
+		}
+		adminPost(func(r *http.Request) (interface{}, error) {
+			keepHeals := p.cfg.Kill.KeepHeals
+			if s := r.URL.Query().Get("keep_heals"); s != "" {
+				var err error
+				keepHeals, err = strconv.ParseBool(s)
+				if err != nil {
+					return nil, badRequest{msg: "bad_keep_heals"}
+				}
+			}
+			reason := r.URL.Query().Get("reason")
+			if reason == "" {
+				reason = "admin"
+			}
+			return p.Kill(KillSourceAdmin, reason, keepHeals)
+		})(w, r)
+	})
+	mux.HandleFunc("/admin/rearm", adminPost(func(r *http.Request) (interface{}, error) {
+		by := r.URL.Query().Get("by")
+		if by == "" {
+			by = KillSourceAdmin
+		}
+		e, err := p.Rearm(by)
+		if errors.Is(err, ErrKillSwitchNotActive) {
+			return nil, badRequest{msg: err.Error()}
+		}
+		return e, err
+	}))
+	mux.HandleFunc("/admin/reconcile", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			writeJSON(w, http.StatusOK, p.LastReconcile())
+			return
+		}
+		adminPost(func(r *http.Request) (interface{}, error) {
+			return p.Reconcile()
+		})(w, r)
+	})
+	mux.HandleFunc("/admin/reconcile/reset", adminPost(func(r *http.Request) (interface{}, error) {
+		err := p.ResetReconcile("admin")
+		if err != nil {
+			return nil, err
+		}
+		return p.Reconcile()
+	}))
+	mux.HandleFunc("/admin/inventory", adminGet(func(r *http.Request) (interface{}, error) {
+		return p.Inventory(), nil
+	}))
+	mux.HandleFunc("/admin/exposure", adminGet(func(r *http.Request) (interface{}, error) {
+		return p.Exposure(), nil
+	}))
+	mux.HandleFunc("/admin/ledger", adminGet(func(r *http.Request) (interface{}, error) {
+		window, err := queryDuration(r, "window", 24*time.Hour)
+		if err != nil {
+			return nil, err
+		}
+		paper, err := queryBool(r, "paper", p.cfg.Service.PaperMode)
+		if err != nil {
+			return nil, err
+		}
+		to := p.clock.Now()
+		q := r.URL.Query()
+		return p.Ledger(to.Add(-window), to, q.Get("market"), paper, q.Get("cycles") == "true")
+	}))
+	mux.HandleFunc("/admin/ledger/update", adminPost(func(r *http.Request) (interface{}, error) {
+		n, err := p.UpdateLedger()
+		return map[string]int{"cycles": n}, err
+	}))
+	mux.HandleFunc("/admin/equity", adminGet(func(r *http.Request) (interface{}, error) {
+		window, err := queryDuration(r, "window", 24*time.Hour)
+		if err != nil {
+			return nil, err
+		}
+		paper, err := queryBool(r, "paper", p.cfg.Service.PaperMode)
+		if err != nil {
+			return nil, err
+		}
+		to := p.clock.Now()
+		return p.store.SelectEquitySnapshots(to.Add(-window), to, r.URL.Query().Get("coin"), paper)
+	}))
+	mux.HandleFunc("/admin/equity/now", adminGet(func(r *http.Request) (interface{}, error) {
+		return p.Equity(), nil
+	}))
+	mux.HandleFunc("/admin/equity/snapshot", adminPost(func(r *http.Request) (interface{}, error) {
+		err := p.SnapshotEquity()
+		if err != nil {
+			return nil, err
+		}
+		return p.Equity(), nil
+	}))
+	mux.HandleFunc("/admin/equity/changes", adminGet(func(r *http.Request) (interface{}, error) {
+		days := int64(7)
+		if r.URL.Query().Get("days") != "" {
+			var err error
+			days, err = queryInt64(r, "days")
+			if err != nil {
+				return nil, err
+			}
+		}
+		to := p.clock.Now().UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+		return p.EquityChanges(to.Add(-time.Duration(days)*24*time.Hour), to, 24*time.Hour)
+	}))
+	mux.HandleFunc("/admin/pause", adminPost(func(r *http.Request) (interface{}, error) {
+		d, err := queryDuration(r, "duration", 0)
+		if err != nil {
+			return nil, err
+		}
+		reason := r.URL.Query().Get("reason")
+		if reason == "" {
+			reason = "admin"
+		}
+		return p.Pause(r.URL.Query().Get("market"), reason, d), nil
+	}))
+	mux.HandleFunc("/admin/resume", adminPost(func(r *http.Request) (interface{}, error) {
+		return map[string]bool{"resumed": p.Resume(r.URL.Query().Get("market"))}, nil
+	}))
+	mux.HandleFunc("/admin/orders/cancel", adminPost(func(r *http.Request) (interface{}, error) {
+		id, err := queryInt64(r, "id")
+		if err != nil {
+			return nil, err
+		}
+		return map[string]int64{"canceled": id}, p.CancelOrder(id)
+	}))
+	mux.HandleFunc("/admin/heals/retry", adminPost(func(r *http.Request) (interface{}, error) {
+		id, err := queryInt64(r, "id")
+		if err != nil {
+			return nil, err
+		}
+		canceled, err := p.RetryHeal(id)
+		return map[string]int{"canceled_orders": canceled}, err
+	}))
+	mux.HandleFunc("/admin/refresh/balances", adminPost(func(r *http.Request) (interface{}, error) {
+		err := p.GetBalances()
+		if err != nil {
+			return nil, err
+		}
+		return p.Balances(), nil
+	}))
+	mux.HandleFunc("/admin/refresh/markets", adminPost(func(r *http.Request) (interface{}, error) {
+		err := p.GetMarkets()
+		p.marketLock.Lock()
+		n := len(p.marketMap)
+		p.marketLock.Unlock()
+		return map[string]int{"markets": n}, err
+	}))
+}
+
+func (p *Placer) OpenOrders() []store.Order {
+	var list []store.Order
+	p.openOrderMap.Range(func(key, value interface{}) bool {
+		list = append(list, value.(store.Order))
+		return true
+	})
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// InflightSurebets returns copies, Calc still sets the order of a bet in the map.
+func (p *Placer) InflightSurebets() []store.Surebet {
+	var list []store.Surebet
+	p.surebetLock.Lock()
+	p.surebetMap.Range(func(key, value interface{}) bool {
+		list = append(list, *value.(*store.Surebet))
+		return true
+	})
+	p.surebetLock.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// PendingHeals returns copies, heal goroutines change the heals in the map.
+func (p *Placer) PendingHeals() []store.Heal {
+	var list []store.Heal
+	p.healLock.Lock()
+	p.healMap.Range(func(key, value interface{}) bool {
+		list = append(list, cloneHeal(value.(*store.Heal)))
+		return true
+	})
+	p.healLock.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+func (p *Placer) Balances() map[string]store.BalanceEmb {
+	p.balanceLock.Lock()
+	defer p.balanceLock.Unlock()
+	balances := make(map[string]store.BalanceEmb, len(p.balanceMap))
+	for coin, b := range p.balanceMap {
+		balances[coin] = *b
+	}
+	return balances
+}
+
+type effectiveConfig struct {
+	Global    *PlaceConfig                   `json:"global"`
+	Overrides map[string]store.PlaceOverride `json:"overrides"`
+	Market    string                         `json:"market,omitempty"`
+	Override  string                         `json:"override,omitempty"`
+	Effective *PlaceConfig                   `json:"effective,omitempty"`
+}
+
+func (p *Placer) effectiveConfig(market string) effectiveConfig {
+	c := effectiveConfig{
+		Global:    p.loadPlaceConfig(),
+		Overrides: p.overrides.Load().(map[string]store.PlaceOverride),
+	}
+	if market != "" {
+		var base string
+		if m := p.FindMarket(market); m != nil {
+			base = m.BaseCurrency
+		}
+		c.Market = market
+		c.Effective, c.Override = p.placeConfigFor(market, base)
+	}
+	return c
+}
+
+func (p *Placer) CancelOrder(orderID int64) error {
+	ctx, cancel := context.WithTimeout(p.ctx, 5*time.Second)
+	defer cancel()
+	err := p.venue.CancelOrder(ctx, orderID)
+	p.log.Info("admin_cancel_order", zap.Int64("order_id", orderID), zap.Error(err))
+	return err
+}
+
+// RetryHeal cancels the open orders of a pending heal, the close event places the
+// next heal order. A heal without an open order is resumed from the venue state of
+// its orders.
+func (p *Placer) RetryHeal(id int64) (int, error) {
+	got, ok := p.healMap.Load(id)
+	if !ok {
+		return 0, ErrHealNotFound
+	}
+	var orderIDs []int64
+	p.openOrderMap.Range(func(key, value interface{}) bool {
+		o := value.(store.Order)
+		if o.ClientID == nil {
+			return true
+		}
+		clientID, err := UnmarshalClientID(*o.ClientID)
+		if err == nil && healSide(clientID.Side) && clientID.ID == id {
+			orderIDs = append(orderIDs, o.ID)
+		}
+		return true
+	})
+	p.log.Info("admin_retry_heal", zap.Int64("i", id), zap.Int64s("open_orders", orderIDs))
+	if len(orderIDs) == 0 {
+		return p.resumeHeal(got.(*store.Heal))
+	}
+	var canceled int
+	for _, orderID := range orderIDs {
+		err := p.CancelOrder(orderID)
+		if err != nil {
+			return canceled, err
+		}
+		canceled++
+	}
+	return canceled, nil
+}
+
+// resumeHeal refreshes the orders of a heal we saw no open order of. Orders still
+// open on the venue are canceled, otherwise the last one is re-healed so only the
+// size left unhealed is placed again.
+func (p *Placer) resumeHeal(h *store.Heal) (int, error) {
+	orders, err := p.venueOrders()
+	if err != nil {
+		return 0, fmt.Errorf("resume_heal_orders_error: %w", err)
+	}
+	p.healLock.Lock()
+	s := refreshHealOrders(h, orders, venueHealOrders(orders, h.ID))
+	var last store.Order
+	if s.last != nil {
+		last = *s.last
+	}
+	try := int64(len(h.Orders) - 1)
+	var openIDs []int64
+	for _, o := range h.Orders {
+		if o.Status != store.OrderStatusClosed {
+			openIDs = append(openIDs, o.ID)
+		}
+	}
+	p.healLock.Unlock()
+	p.log.Info("resume_heal",
+		zap.Int64("i", h.ID),
+		zap.Float64("hf_size", s.filledSizeSum.InexactFloat64()),
+		zap.Int64s("open_orders", openIDs),
+		zap.Int64("last_order", last.ID),
+	)
+	switch {
+	case s.open:
+		var canceled int
+		for _, orderID := range openIDs {
+			err := p.CancelOrder(orderID)
+			if err != nil {
+				return canceled, err
+			}
+			canceled++
+		}
+		return canceled, nil
+	case s.last == nil:
+		p.async(func() { p.placeHeal(h) })
+	default:
+		clientID := ClientID{ID: h.ID, Side: HEAL, Try: try}
+		p.async(func() { p.reHeal(last, clientID) })
+	}
+	return 0, nil
+}
@@ -3,26 +3,31 @@ package placer
 import (
 	"fmt"
 	"github.com/aibotsoft/crypto-surebet/pkg/store"
-	"github.com/jinzhu/copier"
 	"github.com/shopspring/decimal"
 )
 
-func (p *Placer) GetBalances() error {
+func (p *Placer) GetBalances() (err error) {
+	defer func() {
+		result := "ok"
+		if err != nil {
+			result = "error"
+		}
+		p.metrics.balanceRefresh.WithLabelValues(result).Inc()
+	}()
 	//start := time.Now()
-	resp, err := p.client.NewGetBalancesService().Do(p.ctx)
+	data, err := p.venue.GetBalances(p.ctx)
 	if err != nil {
 		return err
 	}
-	if len(resp) == 0 {
+	if len(data) == 0 {
 		return fmt.Errorf("balance_list_empty")
 	}
-	//p.log.Info("balance_resp", zap.Any("resp", resp))
-	var data []store.Balance
-	err = copier.Copy(&data, resp)
-	if err != nil {
-		return err
-	}
+	//p.log.Info("balance_resp", zap.Any("resp", data))
 	p.saveBalances(data)
+	if p.cfg.Service.PaperMode {
+		//virtual balances must not overwrite the real ones
+		return nil
+	}
 	//total := p.BalanceTotal()
 	err = p.store.SaveBalances(&data)
 	if err != nil {
@@ -0,0 +1,186 @@
+package placer
+
+import (
+	"errors"
+	"github.com/aibotsoft/crypto-surebet/pkg/store"
+	"github.com/aibotsoft/crypto-surebet/pkg/venue"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	breakerDrawdown       = "drawdown"
+	breakerMarketDrawdown = "market_drawdown"
+	breakerErrors         = "error_rate"
+	breakerRateLimit      = "rate_limit"
+	breakerNatsDelay      = "nats_delay"
+)
+
+type pnlEvent struct {
+	at     time.Time
+	market string
+	pnl    decimal.Decimal
+}
+
+type breakerTrip struct {
+	count int
+	last  time.Time
+}
+
+// breakers collect recent outcomes, checkBreakers pauses trading when one of them
+// goes over its limit. The pause expires after a cool-down doubled on every trip in a row.
+type breakers struct {
+	mu         sync.Mutex
+	pnl        []pnlEvent
+	errors     []time.Time
+	rateLimits []time.Time
+	trips      map[string]breakerTrip
+}
+
+func keepAfter(list []time.Time, after time.Time) []time.Time {
+	i := 0
+	for i < len(list) && !list[i].After(after) {
+		i++
+	}
+	return list[i:]
+}
+
+// placeFailed records a failed bet or heal placement.
+func (p *Placer) placeFailed(err error) {
+	now := p.clock.Now()
+	p.breakers.mu.Lock()
+	defer p.breakers.mu.Unlock()
+	if errors.Is(err, venue.ErrRateLimit) {
+		p.breakers.rateLimits = append(p.breakers.rateLimits, now)
+	} else {
+		p.breakers.errors = append(p.breakers.errors, now)
+	}
+}
+
+// healPnl is the realized quote currency pnl of a bet and its heal orders, the bet
+// fee is the estimated FeePart.
+func healPnl(h *store.Heal) decimal.Decimal {
+	var healValue decimal.Decimal
+	for _, o := range h.Orders {
+		healValue = healValue.Add(decimal.NewFromFloat(o.FilledSize).Mul(decimal.NewFromFloat(o.AvgFillPrice)))
+	}
+	betValue := h.FilledSize.Mul(h.AvgFillPrice)
+	if h.PlaceParams.Side == store.SideSell {
+		return healValue.Sub(betValue).Sub(h.FeePart)
+	}
+	return betValue.Sub(healValue).Sub(h.FeePart)
+}
+
+func (p *Placer) healDone(h *store.Heal) {
+	p.breakers.mu.Lock()
+	defer p.breakers.mu.Unlock()
+	p.breakers.pnl = append(p.breakers.pnl, pnlEvent{at: p.clock.Now(), market: h.PlaceParams.Market, pnl: healPnl(h)})
+}
+
+// checkBreakers trips the breakers over limit, it runs on the breaker tick.
+func (p *Placer) checkBreakers() {
+	cfg := p.cfg.Breaker
+	now := p.clock.Now()
+	type trip struct {
+		name  string
+		key   string
+		value float64
+	}
+	var trips []trip
+
+	p.breakers.mu.Lock()
+	p.breakers.errors = keepAfter(p.breakers.errors, now.Add(-cfg.ErrorWindow))
+	if cfg.ErrorLimit > 0 && len(p.breakers.errors) >= cfg.ErrorLimit {
+		trips = append(trips, trip{breakerErrors, pauseAll, float64(len(p.breakers.errors))})
+		p.breakers.errors = nil
+	}
+	p.breakers.rateLimits = keepAfter(p.breakers.rateLimits, now.Add(-cfg.ErrorWindow))
+	if cfg.RateLimitLimit > 0 && len(p.breakers.rateLimits) >= cfg.RateLimitLimit {
+		trips = append(trips, trip{breakerRateLimit, pauseAll, float64(len(p.breakers.rateLimits))})
+		p.breakers.rateLimits = nil
+	}
+
+	i := 0
+	for i < len(p.breakers.pnl) && !p.breakers.pnl[i].at.After(now.Add(-cfg.PnlWindow)) {
+		i++
+	}
+	p.breakers.pnl = p.breakers.pnl[i:]
+	var total decimal.Decimal
+	byMarket := make(map[string]decimal.Decimal)
+	for _, e := range p.breakers.pnl {
+		total = total.Add(e.pnl)
+		byMarket[e.market] = byMarket[e.market].Add(e.pnl)
+	}
+	if cfg.MaxDrawdown > 0 && total.InexactFloat64() <= -cfg.MaxDrawdown {
+		trips = append(trips, trip{breakerDrawdown, pauseAll, total.InexactFloat64()})
+		p.breakers.pnl = nil
+	} else if cfg.MarketMaxDrawdown > 0 {
+		var keep []pnlEvent
+		for _, e := range p.breakers.pnl {
+			if byMarket[e.market].InexactFloat64() > -cfg.MarketMaxDrawdown {
+				keep = append(keep, e)
+			}
+		}
+		for market, pnl := range byMarket {
+			if pnl.InexactFloat64() <= -cfg.MarketMaxDrawdown {
+				trips = append(trips, trip{breakerMarketDrawdown, market, pnl.InexactFloat64()})
+			}
+		}
+		p.breakers.pnl = keep
+	}
+	p.breakers.mu.Unlock()
+
+	if cfg.MaxNatsDelay > 0 {
+		if avg := time.Duration(p.delay.Avg()); avg > cfg.MaxNatsDelay {
+			trips = append(trips, trip{breakerNatsDelay, pauseAll, avg.Seconds()})
+		}
+	}
+	for _, t := range trips {
+		p.tripBreaker(t.name, t.key, t.value)
+	}
+}
+
+func (p *Placer) tripBreaker(name string, key string, value float64) {
+	cfg := p.cfg.Breaker
+	now := p.clock.Now()
+	if _, ok := p.pauseOf(key); ok {
+		return
+	}
+	p.breakers.mu.Lock()
+	if p.breakers.trips == nil {
+		p.breakers.trips = make(map[string]breakerTrip)
+	}
+	t := p.breakers.trips[name+key]
+	if now.Sub(t.last) > cfg.TripReset {
+		t.count = 0
+	}
+	// a pause of 0 never ends, so a zero max leaves the doubling uncapped instead
+	maxCoolDown := cfg.MaxCoolDown
+	if maxCoolDown <= 0 {
+		maxCoolDown = math.MaxInt64 / 2
+	}
+	coolDown := cfg.CoolDown
+	for i := 0; i < t.count && coolDown < maxCoolDown; i++ {
+		coolDown *= 2
+	}
+	if coolDown > maxCoolDown {
+		coolDown = maxCoolDown
+	}
+	t.count++
+	t.last = now
+	p.breakers.trips[name+key] = t
+	p.breakers.mu.Unlock()
+
+	p.metrics.breakerTrips.WithLabelValues(name).Inc()
+	p.log.Warn("breaker_trip",
+		zap.String("breaker", name),
+		zap.String("key", key),
+		zap.Float64("value", value),
+		zap.Int("trip", t.count),
+		zap.Duration("cool_down", coolDown),
+	)
+	p.Pause(key, "breaker_"+name, coolDown)
+}
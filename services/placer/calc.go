@@ -4,16 +4,18 @@ import (
 	"context"
 	"errors"
 	"github.com/aibotsoft/crypto-surebet/pkg/store"
-	ftxapi "github.com/aibotsoft/ftx-api"
+	"github.com/aibotsoft/crypto-surebet/pkg/venue"
 	"github.com/shopspring/decimal"
 	"go.uber.org/zap"
 	"runtime"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
 func (p *Placer) Calc(sb *store.Surebet) chan int64 {
-	sb.StartTime = time.Now().UnixNano()
+	sb.StartTime = p.clock.Now().UnixNano()
+	sb.Paper = p.cfg.Service.PaperMode
 	p.delay.Add(float64(sb.StartTime - sb.ID))
 	if time.Duration(sb.StartTime-sb.ID) > p.cfg.Service.SendReceiveMaxDelay {
 		p.log.Debug("lock_time_too_high",
@@ -22,10 +24,27 @@ func (p *Placer) Calc(sb *store.Surebet) chan int64 {
 			zap.Duration("start_vs_id", time.Duration(sb.StartTime-sb.ID)),
 			zap.Duration("send_receive_max_delay", p.cfg.Service.SendReceiveMaxDelay),
 		)
+		p.decide(sb, StageReceived, rejectDelay)
 		return nil
 	}
 
 	sb.Market = p.FindMarket(sb.FtxTicker.Symbol)
+	if reason := p.checkMarket(sb.FtxTicker.Symbol, sb.Market); reason != "" {
+		p.decide(sb, StageReceived, reason)
+		return nil
+	}
+	if p.stopping() {
+		p.decide(sb, StageReceived, rejectShutdown)
+		return nil
+	}
+	if p.killed() {
+		p.decide(sb, StageReceived, rejectKillSwitch)
+		return nil
+	}
+	if _, ok := p.paused(sb.FtxTicker.Symbol, sb.Market.BaseCurrency); ok {
+		p.decide(sb, StageReceived, rejectPaused)
+		return nil
+	}
 
 	lockTimer, cancel := context.WithTimeout(p.ctx, p.cfg.Service.MaxLockTime)
 	defer cancel()
@@ -44,29 +63,37 @@ func (p *Placer) Calc(sb *store.Surebet) chan int64 {
 		p.log.Debug("lock_too_long",
 			zap.String("s", sb.Market.BaseCurrency),
 			zap.Int64("id", sb.ID),
-			zap.Duration("lock_elapsed", time.Duration(time.Now().UnixNano()-sb.StartTime)),
+			zap.Duration("lock_elapsed", time.Duration(p.clock.Now().UnixNano()-sb.StartTime)),
 			zap.Duration("max_lock_time", p.cfg.Service.MaxLockTime),
 			zap.Int("goroutine", runtime.NumGoroutine()),
 		)
+		p.decide(sb, StageReceived, rejectLockTimeout)
 		return nil
 	}
 	p.lastFtxPriceMap.Store(sb.FtxTicker.Symbol, sb.FtxTicker.BidPrice)
-	sb.MaxStake = p.placeConfig.MaxStake
-	sb.TargetProfit = p.placeConfig.TargetProfit
-	sb.TargetAmount = p.placeConfig.TargetAmount
-	sb.MinVolume = p.placeConfig.MinVolume
+	pc, override := p.placeConfigFor(sb.FtxTicker.Symbol, sb.Market.BaseCurrency)
+	sb.Override = override
+	sb.MaxStake = pc.MaxStake
+	sb.TargetProfit = pc.TargetProfit
+	sb.TargetAmount = pc.TargetAmount
+	sb.MinVolume = pc.MinVolume
+	sb.BinFtxVolumeRatio = pc.BinFtxVolumeRatio
+	sb.ProfitDiffRatio = pc.ProfitDiffRatio
+	sb.ProfitIncRatio = pc.ProfitIncRatio
+	sb.SizeRatioMultiplayer = pc.SizeRatioMultiplayer
 
-	sb.RealFee = p.accountInfo.TakerFee.Sub(p.accountInfo.TakerFee.Mul(p.placeConfig.ReferralRate)).Mul(d100)
+	sb.RealFee = p.accountInfo.TakerFee.Sub(p.accountInfo.TakerFee.Mul(pc.ReferralRate)).Mul(d100)
 	sb.BaseOpenBuy, sb.BaseOpenSell = p.GetOpenBuySell(sb.Market.BaseCurrency)
 
 	sb.BaseBalance = p.FindBalance(sb.Market.BaseCurrency)
 	sb.BaseTotal = sb.BaseBalance.Free.Add(sb.BaseOpenBuy).Sub(sb.BaseOpenSell)
 	if sb.BaseTotal.IsZero() {
+		p.decide(sb, StageLocked, rejectZeroBase)
 		return lock
 	}
 	//sb.AmountCoef = sb.BaseBalance.UsdValue.Div(sb.MaxStake).Sub(sb.TargetAmount).Mul(sb.ProfitInc).Round(5)
 	sb.ProfitInc = sb.BaseOpenBuy.Add(sb.BaseOpenSell).DivRound(sb.BaseTotal, 4)
-	sb.AmountCoef = sb.ProfitInc.Mul(p.placeConfig.ProfitIncRatio).Mul(sb.TargetProfit).Round(4)
+	sb.AmountCoef = sb.ProfitInc.Mul(pc.ProfitIncRatio).Mul(sb.TargetProfit).Round(4)
 
 	sb.QuoteBalance = p.FindBalance(sb.Market.QuoteCurrency)
 
@@ -96,10 +123,15 @@ func (p *Placer) Calc(sb *store.Surebet) chan int64 {
 		sb.BinSize = sb.BinTicker.AskQty
 		sb.Profit = sb.SellProfit
 	}
+	sb.InventoryDev = p.inventoryDev(sb.Market.BaseCurrency, pc.InventoryTarget)
+	sb.InventorySkew = inventorySkew(sb.InventoryDev, pc.InventorySkew, sb.PlaceParams.Side)
+	if !sb.InventorySkew.IsZero() {
+		sb.RequiredProfit = sb.RequiredProfit.Add(sb.InventorySkew.Mul(sb.TargetProfit))
+	}
 	sb.ProfitSubSpread = sb.Profit.Sub(sb.FtxSpread)
 	sb.ProfitSubFee = sb.ProfitSubSpread.Sub(sb.RealFee)
 
-	sb.AvgPriceDiffRatio = p.placeConfig.AvgPriceDiffRatio
+	sb.AvgPriceDiffRatio = pc.AvgPriceDiffRatio
 	if sb.PlaceParams.Side == store.SideBuy {
 		sb.ProfitSubAvg = sb.ProfitSubFee.Sub(sb.AvgPriceDiff.Div(sb.AvgPriceDiffRatio)).Round(5)
 	} else {
@@ -127,10 +159,11 @@ func (p *Placer) Calc(sb *store.Surebet) chan int64 {
 		//	//zap.Any("real_fee", sb.RealFee),
 		//	zap.Any("profit_inc", sb.ProfitInc),
 		//)
+		p.decide(sb, StageLocked, rejectProfitLow)
 		return lock
 	}
 
-	profitDiff := sb.ProfitSubAvg.Sub(sb.RequiredProfit).Div(p.placeConfig.ProfitDiffRatio)
+	profitDiff := sb.ProfitSubAvg.Sub(sb.RequiredProfit).Div(pc.ProfitDiffRatio)
 	sb.ProfitPriceDiff = sb.Price.Mul(profitDiff).DivRound(d100, 6)
 
 	sb.BinVolume = sb.BinPrice.Mul(sb.BinSize).Floor()
@@ -145,8 +178,8 @@ func (p *Placer) Calc(sb *store.Surebet) chan int64 {
 	maxSizeByTotal := sb.BaseTotal.Div(sb.TargetAmount)
 	maxSizeByMaxStake := sb.MaxStake.Div(sb.PlaceParams.Price)
 
-	sb.SizeRatio = sb.Size.Div(sb.BinSize).Mul(p.placeConfig.SizeRatioMultiplayer).Add(d1).Round(1)
-	sb.SizeByBin = sb.BinSize.Div(p.placeConfig.BinFtxVolumeRatio).Div(sb.SizeRatio)
+	sb.SizeRatio = sb.Size.Div(sb.BinSize).Mul(pc.SizeRatioMultiplayer).Add(d1).Round(1)
+	sb.SizeByBin = sb.BinSize.Div(pc.BinFtxVolumeRatio).Div(sb.SizeRatio)
 
 	size := decimal.Min(
 		maxSizeByTotal,
@@ -163,6 +196,10 @@ func (p *Placer) Calc(sb *store.Surebet) chan int64 {
 	} else if size.Equal(sb.SizeByBin) {
 		sb.MaxBy = "bin_size"
 	}
+	if sb.InventorySkew.IsPositive() {
+		size = size.Mul(d1.Sub(decimal.Min(sb.InventorySkew, d1)))
+		sb.MaxBy = "inventory"
+	}
 	sb.PlaceParams.Size = size.Div(sb.Market.MinProvideSize).Floor().Mul(sb.Market.MinProvideSize)
 
 	sb.Volume = sb.PlaceParams.Size.Mul(sb.PlaceParams.Price).Floor()
@@ -182,12 +219,18 @@ func (p *Placer) Calc(sb *store.Surebet) chan int64 {
 			zap.Float64("min_size", sb.Market.MinProvideSize.InexactFloat64()),
 			zap.Float64("b_free", sb.BaseBalance.Free.InexactFloat64()),
 			zap.Int64("q_free", sb.QuoteBalance.Free.IntPart()),
-			zap.Int64("vol_by_bin", sb.BinVolume.Div(p.placeConfig.BinFtxVolumeRatio).IntPart()),
+			zap.Int64("vol_by_bin", sb.BinVolume.Div(pc.BinFtxVolumeRatio).IntPart()),
 		)
+		p.decide(sb, StageProfitable, rejectVolumeLow)
 		p.checkBalanceCh <- sb.Done
 		return lock
 	}
 
+	if reason := p.checkExposure(sb); reason != "" {
+		p.decide(sb, StageProfitable, reason)
+		return lock
+	}
+
 	sb.MakerFee = p.accountInfo.MakerFee
 	sb.TakerFee = p.accountInfo.TakerFee
 	sb.PlaceParams.Market = sb.FtxTicker.Symbol
@@ -197,7 +240,7 @@ func (p *Placer) Calc(sb *store.Surebet) chan int64 {
 
 	sb.PlaceParams.PostOnly = false
 	sb.PlaceParams.ClientID = marshalClientID(ClientID{ID: sb.ID, Side: BET})
-	sb.BeginPlace = time.Now().UnixNano()
+	sb.BeginPlace = p.clock.Now().UnixNano()
 
 	if p.cfg.Service.DemoMode {
 		p.log.Info("demo_mode",
@@ -231,26 +274,51 @@ func (p *Placer) Calc(sb *store.Surebet) chan int64 {
 		time.Sleep(time.Millisecond * 50)
 		//p.saveSbCh <- sb
 		//p.checkBalanceCh <- time.Now().UnixNano()
+		p.decide(sb, StageSized, rejectDemoMode)
 		return lock
 	}
 
+	// counted before the check, Shutdown waits for the bets that passed it
+	atomic.AddInt32(&p.placing, 1)
+	if p.stopping() {
+		atomic.AddInt32(&p.placing, -1)
+		p.decide(sb, StageSized, rejectShutdown)
+		return lock
+	}
+	if p.killed() {
+		atomic.AddInt32(&p.placing, -1)
+		p.decide(sb, StageSized, rejectKillSwitch)
+		return lock
+	}
 	p.surebetMap.Store(sb.ID, sb)
 	order, err := p.PlaceOrder(p.ctx, sb.PlaceParams)
-	sb.Done = time.Now().UnixNano()
+	p.surebetLock.Lock()
+	sb.Done = p.clock.Now().UnixNano()
+	if err == nil {
+		sb.OrderID = order.ID
+	}
+	p.surebetLock.Unlock()
+	atomic.AddInt32(&p.placing, -1)
 	if err != nil {
-		if errors.Is(err, ftxapi.ErrorRateLimit) {
+		p.surebetMap.Delete(sb.ID)
+		p.placeFailed(err)
+		if errors.Is(err, venue.ErrRateLimit) {
 			p.log.Warn("bet_error",
 				zap.Error(err),
 				zap.String("s", sb.FtxTicker.Symbol),
 				zap.Duration("elapsed", time.Duration(sb.Done-sb.StartTime)))
+			p.decide(sb, StageSized, rejectRateLimit)
 		} else {
 			p.log.Warn("bet_error", zap.Error(err), zap.Any("sb", sb), zap.Duration("elapsed", time.Duration(sb.Done-sb.StartTime)))
+			p.decide(sb, StageSized, rejectPlaceError)
 		}
 		return lock
 	}
-	sb.OrderID = order.ID
-	go p.cancelBetOrder(order.ID, sb.ID)
-	p.saveSbCh <- sb
+	p.decide(sb, StagePlaced, "")
+	p.clock.AfterFunc(p.cfg.Service.BetCancelPeriod, func() {
+		p.cancelBetOrder(order.ID, sb.ID)
+	})
+	p.saveSurebet(sb)
 
 	p.log.Info("bet",
 		zap.Int64("i", sb.ID),
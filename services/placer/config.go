@@ -0,0 +1,258 @@
+package placer
+
+import (
+	"fmt"
+	"github.com/aibotsoft/crypto-surebet/pkg/config"
+	"github.com/aibotsoft/crypto-surebet/pkg/store"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"os"
+	"strings"
+	"time"
+)
+
+// PlaceConfig is replaced as a whole on reload, read it once with loadPlaceConfig
+// and keep the pointer for the whole calculation.
+type PlaceConfig struct {
+	MaxStake             decimal.Decimal
+	TargetProfit         decimal.Decimal
+	TargetAmount         decimal.Decimal
+	ReferralRate         decimal.Decimal
+	BinFtxVolumeRatio    decimal.Decimal
+	ProfitDiffRatio      decimal.Decimal
+	AvgPriceDiffRatio    decimal.Decimal
+	ProfitIncRatio       decimal.Decimal
+	MinVolume            decimal.Decimal
+	RehealThreshold      decimal.Decimal
+	SizeRatioMultiplayer decimal.Decimal
+	InventoryTarget      decimal.Decimal
+	InventorySkew        decimal.Decimal
+}
+
+func newPlaceConfig(cfg *config.Config) *PlaceConfig {
+	return &PlaceConfig{
+		MaxStake:             decimal.NewFromInt(cfg.Service.MaxStake),
+		TargetProfit:         decimal.NewFromFloat(cfg.Service.TargetProfit),
+		RehealThreshold:      decimal.NewFromFloat(cfg.Service.RehealThreshold),
+		BinFtxVolumeRatio:    decimal.NewFromInt(cfg.Service.BinFtxVolumeRatio),
+		TargetAmount:         decimal.NewFromInt(cfg.Service.TargetAmount),
+		ReferralRate:         decimal.NewFromFloat(cfg.Service.ReferralRate),
+		ProfitDiffRatio:      decimal.NewFromInt(cfg.Service.ProfitDiffRatio),
+		AvgPriceDiffRatio:    decimal.NewFromInt(cfg.Service.AvgPriceDiffRatio),
+		ProfitIncRatio:       decimal.NewFromInt(cfg.Service.ProfitIncRatio),
+		MinVolume:            decimal.NewFromInt(cfg.Service.MinVolume),
+		SizeRatioMultiplayer: decimal.NewFromInt(cfg.Service.SizeRatioMultiplayer),
+		InventoryTarget:      decimal.NewFromFloat(cfg.Service.InventoryTarget),
+		InventorySkew:        decimal.NewFromFloat(cfg.Service.InventorySkew),
+	}
+}
+
+type namedValue struct {
+	name  string
+	value decimal.Decimal
+}
+
+// values uses the config file names.
+func (pc *PlaceConfig) values() []namedValue {
+	return []namedValue{
+		{"max_stake", pc.MaxStake},
+		{"target_profit", pc.TargetProfit},
+		{"target_amount", pc.TargetAmount},
+		{"referral_rate", pc.ReferralRate},
+		{"bin_ftx_volume_ratio", pc.BinFtxVolumeRatio},
+		{"profit_diff_ratio", pc.ProfitDiffRatio},
+		{"avg_price_diff_ratio", pc.AvgPriceDiffRatio},
+		{"profit_inc_ratio", pc.ProfitIncRatio},
+		{"min_volume", pc.MinVolume},
+		{"reheal_threshold", pc.RehealThreshold},
+		{"size_ratio_multiplayer", pc.SizeRatioMultiplayer},
+		{"inventory_target", pc.InventoryTarget},
+		{"inventory_skew", pc.InventorySkew},
+	}
+}
+
+// validate rejects values Calc divides by.
+func (pc *PlaceConfig) validate() error {
+	for _, v := range []namedValue{
+		{"target_amount", pc.TargetAmount},
+		{"bin_ftx_volume_ratio", pc.BinFtxVolumeRatio},
+		{"profit_diff_ratio", pc.ProfitDiffRatio},
+		{"avg_price_diff_ratio", pc.AvgPriceDiffRatio},
+	} {
+		if !v.value.IsPositive() {
+			return fmt.Errorf("place_config_invalid: %s=%s must be positive", v.name, v.value)
+		}
+	}
+	if pc.SizeRatioMultiplayer.IsNegative() {
+		return fmt.Errorf("place_config_invalid: size_ratio_multiplayer=%s is negative", pc.SizeRatioMultiplayer)
+	}
+	return nil
+}
+
+func (p *Placer) loadPlaceConfig() *PlaceConfig {
+	return p.placeConfig.Load().(*PlaceConfig)
+}
+
+// SetPlaceConfig swaps the config for all following calculations, every changed
+// value is logged and saved. Returns the changes, an invalid config is rejected.
+func (p *Placer) SetPlaceConfig(pc *PlaceConfig, source string) ([]store.ConfigChange, error) {
+	err := pc.validate()
+	if err != nil {
+		p.log.Error("set_place_config_error", zap.String("source", source), zap.Error(err))
+		return nil, err
+	}
+	p.configLock.Lock()
+	defer p.configLock.Unlock()
+	old := p.loadPlaceConfig()
+	oldValues := old.values()
+	now := time.Now()
+	var changes []store.ConfigChange
+	for i, v := range pc.values() {
+		if v.value.Equal(oldValues[i].value) {
+			continue
+		}
+		changes = append(changes, store.ConfigChange{
+			CreatedAt: now,
+			Source:    source,
+			Name:      v.name,
+			OldValue:  oldValues[i].value,
+			NewValue:  v.value,
+		})
+	}
+	if len(changes) == 0 {
+		p.log.Info("place_config_not_changed", zap.String("source", source))
+		return nil, nil
+	}
+	p.placeConfig.Store(pc)
+	for _, c := range changes {
+		p.log.Info("place_config_changed",
+			zap.String("source", source),
+			zap.String("name", c.Name),
+			zap.String("old", c.OldValue.String()),
+			zap.String("new", c.NewValue.String()),
+		)
+	}
+	err = p.store.SaveConfigChanges(changes)
+	if err != nil {
+		p.log.Error("save_config_changes_error", zap.Error(err))
+	}
+	return changes, nil
+}
+
+// ReloadConfig reads config files and env again and applies the PlaceConfig part,
+// other settings need a restart. Overrides and the market filter are reloaded too.
+func (p *Placer) ReloadConfig(source string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		p.log.Error("reload_config_error", zap.String("source", source), zap.Error(err))
+		return err
+	}
+	_, err = p.SetPlaceConfig(newPlaceConfig(cfg), source)
+	if err != nil {
+		return err
+	}
+	err = p.LoadOverrides()
+	if err != nil {
+		return err
+	}
+	return p.LoadMarketFilter()
+}
+
+// configModTime returns the latest modification time of the config files.
+func configModTime() time.Time {
+	var last time.Time
+	for _, name := range config.Files {
+		info, err := os.Stat(name)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+	return last
+}
+
+func (pc PlaceConfig) with(o store.PlaceOverride) *PlaceConfig {
+	set := func(dst *decimal.Decimal, v decimal.NullDecimal) {
+		if v.Valid {
+			*dst = v.Decimal
+		}
+	}
+	set(&pc.MaxStake, o.MaxStake)
+	set(&pc.TargetProfit, o.TargetProfit)
+	set(&pc.TargetAmount, o.TargetAmount)
+	set(&pc.BinFtxVolumeRatio, o.BinFtxVolumeRatio)
+	set(&pc.ProfitDiffRatio, o.ProfitDiffRatio)
+	set(&pc.AvgPriceDiffRatio, o.AvgPriceDiffRatio)
+	set(&pc.ProfitIncRatio, o.ProfitIncRatio)
+	set(&pc.MinVolume, o.MinVolume)
+	set(&pc.RehealThreshold, o.RehealThreshold)
+	set(&pc.SizeRatioMultiplayer, o.SizeRatioMultiplayer)
+	set(&pc.InventoryTarget, o.InventoryTarget)
+	set(&pc.InventorySkew, o.InventorySkew)
+	return &pc
+}
+
+// LoadOverrides reads the per market and per base currency overrides from the store,
+// an override making the config invalid is skipped.
+func (p *Placer) LoadOverrides() error {
+	data, err := p.store.SelectPlaceOverrides()
+	if err != nil {
+		return fmt.Errorf("select_place_overrides_error: %w", err)
+	}
+	p.configLock.Lock()
+	defer p.configLock.Unlock()
+	pc := p.loadPlaceConfig()
+	overrides := make(map[string]store.PlaceOverride, len(data))
+	for _, o := range data {
+		err := pc.with(o).validate()
+		if err != nil {
+			p.log.Error("place_override_invalid", zap.String("key", o.Key), zap.Error(err))
+			continue
+		}
+		overrides[o.Key] = o
+	}
+	old := p.overrides.Load().(map[string]store.PlaceOverride)
+	for key, o := range overrides {
+		if prev, ok := old[key]; !ok || !prev.UpdatedAt.Equal(o.UpdatedAt) {
+			p.log.Info("place_override_loaded", zap.String("key", key), zap.Any("override", o))
+		}
+	}
+	for key := range old {
+		if _, ok := overrides[key]; !ok {
+			p.log.Info("place_override_removed", zap.String("key", key))
+		}
+	}
+	p.overrides.Store(overrides)
+	return nil
+}
+
+// placeConfigFor returns the config with the base currency and then the market
+// override applied, and the keys of the applied overrides.
+func (p *Placer) placeConfigFor(market string, base string) (*PlaceConfig, string) {
+	pc := p.loadPlaceConfig()
+	overrides := p.overrides.Load().(map[string]store.PlaceOverride)
+	if len(overrides) == 0 {
+		return pc, ""
+	}
+	var keys []string
+	for _, key := range []string{base, market} {
+		o, ok := overrides[key]
+		if !ok || key == "" {
+			continue
+		}
+		pc = pc.with(o)
+		keys = append(keys, key)
+	}
+	return pc, strings.Join(keys, ",")
+}
+
+func (p *Placer) placeConfigForMarket(market string) *PlaceConfig {
+	var base string
+	if m := p.FindMarket(market); m != nil {
+		base = m.BaseCurrency
+	}
+	pc, _ := p.placeConfigFor(market, base)
+	return pc
+}
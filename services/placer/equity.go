@@ -0,0 +1,171 @@
+package placer
+
+import (
+	"github.com/aibotsoft/crypto-surebet/pkg/store"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"sort"
+	"time"
+)
+
+// EquityTotal is the coin of the snapshot row summing the others.
+const EquityTotal = "TOTAL"
+
+// healUnrealized marks the size of h not healed yet against the last price of its
+// market, it is what closing the bet position now would earn over the bet fill.
+func (p *Placer) healUnrealized(h *store.Heal) (string, decimal.Decimal) {
+	coin := symbolFromMarket(h.PlaceParams.Market)
+	got, ok := p.lastFtxPriceMap.Load(h.PlaceParams.Market)
+	if !ok {
+		return coin, decimal.Zero
+	}
+	var healed decimal.Decimal
+	for _, o := range h.Orders {
+		filled := o.FilledSize
+		if open, ok := p.openOrderMap.Load(o.ID); ok {
+			filled = open.(store.Order).FilledSize
+		}
+		healed = healed.Add(decimal.NewFromFloat(filled))
+	}
+	open := h.FilledSize.Sub(healed)
+	if !open.IsPositive() {
+		return coin, decimal.Zero
+	}
+	diff := got.(decimal.Decimal).Sub(h.AvgFillPrice)
+	if h.PlaceParams.Side == store.SideBuy {
+		diff = diff.Neg()
+	}
+	return coin, open.Mul(diff)
+}
+
+// Equity values every balance and the open heals at the last prices, the TOTAL row
+// comes last. Rows of paper mode are marked paper.
+func (p *Placer) Equity() []store.EquitySnapshot {
+	now := p.clock.Now()
+	paper := p.cfg.Service.PaperMode
+	unrealized := make(map[string]decimal.Decimal)
+	for _, h := range p.PendingHeals() {
+		coin, u := p.healUnrealized(&h)
+		unrealized[coin] = unrealized[coin].Add(u)
+	}
+	total := store.EquitySnapshot{CreatedAt: now, Coin: EquityTotal, Paper: paper}
+	var list []store.EquitySnapshot
+	for coin, b := range p.Balances() {
+		if b.Total.IsZero() && unrealized[coin].IsZero() {
+			continue
+		}
+		e := store.EquitySnapshot{
+			CreatedAt:     now,
+			Coin:          coin,
+			Total:         b.Total,
+			UsdValue:      p.coinUsd(coin, b),
+			UnrealizedPnl: unrealized[coin],
+			Paper:         paper,
+		}
+		if !b.Total.IsZero() {
+			e.Price = e.UsdValue.Div(b.Total).Round(8)
+		}
+		total.UsdValue = total.UsdValue.Add(e.UsdValue)
+		total.UnrealizedPnl = total.UnrealizedPnl.Add(e.UnrealizedPnl)
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Coin < list[j].Coin })
+	return append(list, total)
+}
+
+func (p *Placer) SnapshotEquity() error {
+	list := p.Equity()
+	err := p.store.SaveEquitySnapshots(list)
+	if err != nil {
+		return err
+	}
+	total := list[len(list)-1]
+	p.log.Info("equity",
+		zap.Float64("usd", total.UsdValue.InexactFloat64()),
+		zap.Float64("unrealized", total.UnrealizedPnl.InexactFloat64()),
+		zap.Int("coins", len(list)-1))
+	return nil
+}
+
+// EquityChange splits the equity change of a period into realized trading by the
+// ledger, price moves of the coins held at the start and the rest, which is
+// inventory drift: unhealed positions, rebalances, transfers and fees outside cycles.
+type EquityChange struct {
+	From              time.Time       `json:"from"`
+	To                time.Time       `json:"to"`
+	StartUsd          decimal.Decimal `json:"start_usd"`
+	EndUsd            decimal.Decimal `json:"end_usd"`
+	Change            decimal.Decimal `json:"change"`
+	Realized          decimal.Decimal `json:"realized"`
+	PriceMove         decimal.Decimal `json:"price_move"`
+	Inventory         decimal.Decimal `json:"inventory"`
+	StartUnrealized   decimal.Decimal `json:"start_unrealized"`
+	EndUnrealized     decimal.Decimal `json:"end_unrealized"`
+	FirstSnapshotTime time.Time       `json:"first_snapshot"`
+	LastSnapshotTime  time.Time       `json:"last_snapshot"`
+}
+
+// snapshotGroups splits snapshots ordered by time into the ones taken together.
+func snapshotGroups(list []store.EquitySnapshot) [][]store.EquitySnapshot {
+	var groups [][]store.EquitySnapshot
+	for i, e := range list {
+		if i == 0 || !e.CreatedAt.Equal(list[i-1].CreatedAt) {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], e)
+	}
+	return groups
+}
+
+func equityChange(start []store.EquitySnapshot, end []store.EquitySnapshot) EquityChange {
+	var c EquityChange
+	startPrice := make(map[string]store.EquitySnapshot)
+	for _, e := range start {
+		if e.Coin == EquityTotal {
+			c.StartUsd, c.StartUnrealized = e.UsdValue, e.UnrealizedPnl
+			continue
+		}
+		startPrice[e.Coin] = e
+	}
+	for _, e := range end {
+		if e.Coin == EquityTotal {
+			c.EndUsd, c.EndUnrealized = e.UsdValue, e.UnrealizedPnl
+			continue
+		}
+		if s, ok := startPrice[e.Coin]; ok {
+			c.PriceMove = c.PriceMove.Add(s.Total.Mul(e.Price.Sub(s.Price)))
+		}
+	}
+	c.Change = c.EndUsd.Sub(c.StartUsd)
+	return c
+}
+
+// EquityChanges attributes the equity change of every step from from to to, using
+// the first and the last snapshot of the step. Steps without snapshots are skipped.
+func (p *Placer) EquityChanges(from time.Time, to time.Time, step time.Duration) ([]EquityChange, error) {
+	var list []EquityChange
+	for t := from; t.Before(to); t = t.Add(step) {
+		end := t.Add(step)
+		snapshots, err := p.store.SelectEquitySnapshots(t, end, "", p.cfg.Service.PaperMode)
+		if err != nil {
+			return nil, err
+		}
+		groups := snapshotGroups(snapshots)
+		if len(groups) == 0 {
+			continue
+		}
+		first, last := groups[0], groups[len(groups)-1]
+		c := equityChange(first, last)
+		c.From, c.To = t, end
+		c.FirstSnapshotTime, c.LastSnapshotTime = first[0].CreatedAt, last[0].CreatedAt
+		cycles, err := p.store.SelectPnlCycles(c.FirstSnapshotTime, c.LastSnapshotTime, "", p.cfg.Service.PaperMode)
+		if err != nil {
+			return nil, err
+		}
+		total, _ := SumCycles(cycles)
+		c.Realized = total.RealizedPnl
+		c.Inventory = c.Change.Sub(c.Realized).Sub(c.PriceMove)
+		list = append(list, c)
+	}
+	return list, nil
+}
@@ -0,0 +1,119 @@
+package placer
+
+import (
+	"github.com/aibotsoft/crypto-surebet/pkg/store"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"strings"
+)
+
+const (
+	rejectExposureCoin      rejectReason = "exposure_coin"
+	rejectExposureOpen      rejectReason = "exposure_open"
+	rejectExposureInventory rejectReason = "exposure_inventory"
+)
+
+type Exposure struct {
+	Coins        map[string]decimal.Decimal `json:"coins"`
+	OpenNotional decimal.Decimal            `json:"open_notional"`
+	InventoryUsd decimal.Decimal            `json:"inventory_usd"`
+}
+
+// coinUsd values a balance by the last ftx price of its usd market, falling back to
+// the usd value from the last balance update.
+func (p *Placer) coinUsd(coin string, b store.BalanceEmb) decimal.Decimal {
+	if got, ok := p.lastFtxPriceMap.Load(coin + "/USD"); ok {
+		return b.Total.Mul(got.(decimal.Decimal))
+	}
+	return b.UsdValue
+}
+
+// quoteUsd converts an amount of the quote currency to usd, by its usd market or by
+// the usd value of its balance.
+func (p *Placer) quoteUsd(quote string, amount decimal.Decimal) decimal.Decimal {
+	if quote == "USD" {
+		return amount
+	}
+	if got, ok := p.lastFtxPriceMap.Load(quote + "/USD"); ok {
+		return amount.Mul(got.(decimal.Decimal))
+	}
+	b := p.FindBalance(quote)
+	if b.Total.IsZero() {
+		return amount
+	}
+	return amount.Mul(b.UsdValue).Div(b.Total)
+}
+
+func (p *Placer) stableCoin(coin string) bool {
+	for _, s := range strings.Split(p.cfg.Exposure.StableCoins, ",") {
+		if strings.TrimSpace(s) == coin {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Placer) openNotional() decimal.Decimal {
+	byQuote := make(map[string]float64)
+	p.openOrderMap.Range(func(key, value interface{}) bool {
+		o := value.(store.Order)
+		byQuote[quoteFromMarket(o.Market)] += (o.Size - o.FilledSize) * o.Price
+		return true
+	})
+	var sum decimal.Decimal
+	for quote, notional := range byQuote {
+		sum = sum.Add(p.quoteUsd(quote, decimal.NewFromFloat(notional)))
+	}
+	return sum
+}
+
+// Exposure reports the usd values the exposure limits are checked against.
+func (p *Placer) Exposure() Exposure {
+	e := Exposure{Coins: make(map[string]decimal.Decimal), OpenNotional: p.openNotional()}
+	for coin, b := range p.Balances() {
+		if p.stableCoin(coin) {
+			continue
+		}
+		usd := p.coinUsd(coin, b)
+		e.Coins[coin] = usd
+		e.InventoryUsd = e.InventoryUsd.Add(usd)
+	}
+	return e
+}
+
+// checkExposure returns the limit the bet would break, buys add their usd volume to
+// the coin and inventory values, every bet adds it to the open notional.
+func (p *Placer) checkExposure(sb *store.Surebet) rejectReason {
+	cfg := p.cfg.Exposure
+	if cfg.CoinUsd <= 0 && cfg.OpenNotional <= 0 && cfg.InventoryUsd <= 0 {
+		return ""
+	}
+	coin := sb.Market.BaseCurrency
+	buy := sb.PlaceParams.Side == store.SideBuy
+	volumeUsd := p.quoteUsd(sb.Market.QuoteCurrency, sb.Volume)
+	if cfg.OpenNotional > 0 {
+		open := p.openNotional().Add(volumeUsd)
+		if open.GreaterThan(decimal.NewFromFloat(cfg.OpenNotional)) {
+			p.log.Info("exposure_open", zap.String("m", sb.FtxTicker.Symbol), zap.Float64("open", open.InexactFloat64()))
+			return rejectExposureOpen
+		}
+	}
+	if !buy || p.stableCoin(coin) {
+		return ""
+	}
+	if cfg.CoinUsd > 0 {
+		usd := p.coinUsd(coin, *p.FindBalance(coin)).Add(volumeUsd)
+		if usd.GreaterThan(decimal.NewFromFloat(cfg.CoinUsd)) {
+			p.log.Info("exposure_coin", zap.String("coin", coin), zap.Float64("usd", usd.InexactFloat64()))
+			return rejectExposureCoin
+		}
+	}
+	if cfg.InventoryUsd > 0 {
+		inv := p.Exposure().InventoryUsd.Add(volumeUsd)
+		if inv.GreaterThan(decimal.NewFromFloat(cfg.InventoryUsd)) {
+			p.log.Info("exposure_inventory", zap.String("coin", coin), zap.Float64("usd", inv.InexactFloat64()))
+			return rejectExposureInventory
+		}
+	}
+	return ""
+}
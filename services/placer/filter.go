@@ -0,0 +1,167 @@
+package placer
+
+import (
+	"fmt"
+	"github.com/aibotsoft/crypto-surebet/pkg/store"
+	"go.uber.org/zap"
+	"sync"
+	"sync/atomic"
+)
+
+type rejectReason string
+
+const (
+	rejectUnknownMarket    rejectReason = "unknown_market"
+	rejectMarketDisabled   rejectReason = "market_disabled"
+	rejectMarketRestricted rejectReason = "market_restricted"
+	rejectMarketPostOnly   rejectReason = "market_post_only"
+	rejectMarketDenied     rejectReason = "market_denied"
+	rejectBaseDenied       rejectReason = "base_denied"
+	rejectTypeDenied       rejectReason = "type_denied"
+	rejectMarketNotAllowed rejectReason = "market_not_allowed"
+	rejectBaseNotAllowed   rejectReason = "base_not_allowed"
+	rejectTypeNotAllowed   rejectReason = "type_not_allowed"
+	rejectTradingWindow    rejectReason = "trading_window"
+)
+
+const (
+	ruleAllow = "allow"
+	ruleDeny  = "deny"
+
+	fieldMarket = "market"
+	fieldBase   = "base"
+	fieldType   = "type"
+
+	windowAll = "*"
+)
+
+var deniedReasons = map[string]rejectReason{fieldMarket: rejectMarketDenied, fieldBase: rejectBaseDenied, fieldType: rejectTypeDenied}
+var notAllowedReasons = map[string]rejectReason{fieldMarket: rejectMarketNotAllowed, fieldBase: rejectBaseNotAllowed, fieldType: rejectTypeNotAllowed}
+
+type marketFilter struct {
+	allow   map[string]map[string]bool
+	deny    map[string]map[string]bool
+	windows []store.TradingWindow
+}
+
+// LoadMarketFilter reads allow/deny rules and trading windows from the store.
+func (p *Placer) LoadMarketFilter() error {
+	rules, err := p.store.SelectMarketRules()
+	if err != nil {
+		return fmt.Errorf("select_market_rules_error: %w", err)
+	}
+	windows, err := p.store.SelectTradingWindows(p.clock.Now())
+	if err != nil {
+		return fmt.Errorf("select_trading_windows_error: %w", err)
+	}
+	f := &marketFilter{
+		allow:   make(map[string]map[string]bool),
+		deny:    make(map[string]map[string]bool),
+		windows: windows,
+	}
+	for _, r := range rules {
+		var list map[string]map[string]bool
+		switch r.Kind {
+		case ruleAllow:
+			list = f.allow
+		case ruleDeny:
+			list = f.deny
+		default:
+			p.log.Warn("unknown_market_rule_kind", zap.Any("rule", r))
+			continue
+		}
+		if _, ok := deniedReasons[r.Field]; !ok {
+			p.log.Warn("unknown_market_rule_field", zap.Any("rule", r))
+			continue
+		}
+		if list[r.Field] == nil {
+			list[r.Field] = make(map[string]bool)
+		}
+		list[r.Field][r.Value] = true
+	}
+	p.marketFilter.Store(f)
+	p.log.Debug("market_filter_loaded", zap.Int("rules", len(rules)), zap.Int("windows", len(windows)))
+	return nil
+}
+
+// checkMarket returns why the market can not be traded now, or an empty reason.
+func (p *Placer) checkMarket(symbol string, m *store.MarketEmb) rejectReason {
+	switch {
+	case m == nil:
+		return rejectUnknownMarket
+	case !m.Enabled:
+		return rejectMarketDisabled
+	case m.Restricted:
+		return rejectMarketRestricted
+	//bets are taker orders, they are rejected on a post only market
+	case m.PostOnly:
+		return rejectMarketPostOnly
+	}
+	f, _ := p.marketFilter.Load().(*marketFilter)
+	if f == nil {
+		return ""
+	}
+	values := map[string]string{fieldMarket: symbol, fieldBase: m.BaseCurrency, fieldType: m.Type}
+	for _, field := range []string{fieldMarket, fieldBase, fieldType} {
+		v := values[field]
+		if f.deny[field][v] {
+			return deniedReasons[field]
+		}
+		if len(f.allow[field]) > 0 && !f.allow[field][v] {
+			return notAllowedReasons[field]
+		}
+	}
+	if len(f.windows) > 0 {
+		now := p.clock.Now()
+		for _, w := range f.windows {
+			if now.Before(w.Start) || !now.Before(w.End) {
+				continue
+			}
+			if w.Key == windowAll || w.Key == symbol || w.Key == values[fieldBase] {
+				return rejectTradingWindow
+			}
+		}
+	}
+	return ""
+}
+
+type rejectCounter struct {
+	counts sync.Map
+}
+
+func (c *rejectCounter) add(reason rejectReason) {
+	got, ok := c.counts.Load(reason)
+	if !ok {
+		got, _ = c.counts.LoadOrStore(reason, new(int64))
+	}
+	atomic.AddInt64(got.(*int64), 1)
+}
+
+func (c *rejectCounter) stats() map[string]int64 {
+	stats := make(map[string]int64)
+	c.counts.Range(func(key, value interface{}) bool {
+		stats[string(key.(rejectReason))] = atomic.LoadInt64(value.(*int64))
+		return true
+	})
+	return stats
+}
+
+// reportRejects logs rejections since the last report and the totals.
+func (p *Placer) reportRejects(last map[string]int64) map[string]int64 {
+	stats := p.rejects.stats()
+	recent := make(map[string]int64)
+	for reason, count := range stats {
+		if d := count - last[reason]; d > 0 {
+			recent[reason] = d
+		}
+	}
+	if len(recent) > 0 {
+		p.log.Info("reject_report", zap.Any("recent", recent), zap.Any("total", stats))
+	}
+	return stats
+}
+
+// RejectStats returns the count of rejected surebets by reason since start.
+func (p *Placer) RejectStats() map[string]int64 {
+	return p.rejects.stats()
+}
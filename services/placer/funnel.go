@@ -0,0 +1,289 @@
+package placer
+
+import (
+	"encoding/json"
+	"github.com/aibotsoft/crypto-surebet/pkg/store"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Stage int
+
+const (
+	StageReceived Stage = iota
+	StageLocked
+	StageProfitable
+	StageSized
+	StagePlaced
+	StageFilled
+	StageHealed
+	stageCount
+)
+
+var stageNames = [stageCount]string{"received", "locked", "profitable", "sized", "placed", "filled", "healed"}
+
+func (s Stage) String() string {
+	if s < 0 || s >= stageCount {
+		return "unknown"
+	}
+	return stageNames[s]
+}
+
+func (s Stage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+const (
+	rejectDelay       rejectReason = "delay_too_high"
+	rejectLockTimeout rejectReason = "lock_timeout"
+	rejectZeroBase    rejectReason = "zero_base_total"
+	rejectProfitLow   rejectReason = "profit_too_low"
+	rejectVolumeLow   rejectReason = "volume_low"
+	rejectDemoMode    rejectReason = "demo_mode"
+	rejectRateLimit   rejectReason = "rate_limit"
+	rejectPlaceError  rejectReason = "place_error"
+)
+
+// Decision is the outcome of one Calc call, Stage is the last stage reached and
+// Reason is empty when the bet was placed.
+type Decision struct {
+	Time           time.Time       `json:"time"`
+	ID             int64           `json:"id"`
+	Market         string          `json:"market"`
+	Stage          Stage           `json:"stage"`
+	Reason         string          `json:"reason,omitempty"`
+	Delay          time.Duration   `json:"delay"`
+	Profit         decimal.Decimal `json:"profit"`
+	RequiredProfit decimal.Decimal `json:"required_profit"`
+	Volume         decimal.Decimal `json:"volume"`
+}
+
+const funnelBucket = time.Minute
+const funnelKeep = 24 * time.Hour
+const recentDecisions = 1000
+
+type funnelCounts struct {
+	Stages  [stageCount]int64
+	Rejects map[string]int64
+}
+
+func (c *funnelCounts) add(o *funnelCounts) {
+	for i := range c.Stages {
+		c.Stages[i] += o.Stages[i]
+	}
+	for reason, n := range o.Rejects {
+		if c.Rejects == nil {
+			c.Rejects = make(map[string]int64)
+		}
+		c.Rejects[reason] += n
+	}
+}
+
+// FunnelRow counts surebets per stage, every stage includes the ones that went further.
+type FunnelRow struct {
+	Market     string           `json:"market"`
+	Received   int64            `json:"received"`
+	Locked     int64            `json:"locked"`
+	Profitable int64            `json:"profitable"`
+	Sized      int64            `json:"sized"`
+	Placed     int64            `json:"placed"`
+	Filled     int64            `json:"filled"`
+	Healed     int64            `json:"healed"`
+	Rejects    map[string]int64 `json:"rejects,omitempty"`
+}
+
+func newFunnelRow(market string, c *funnelCounts) FunnelRow {
+	return FunnelRow{
+		Market:     market,
+		Received:   c.Stages[StageReceived],
+		Locked:     c.Stages[StageLocked],
+		Profitable: c.Stages[StageProfitable],
+		Sized:      c.Stages[StageSized],
+		Placed:     c.Stages[StagePlaced],
+		Filled:     c.Stages[StageFilled],
+		Healed:     c.Stages[StageHealed],
+		Rejects:    c.Rejects,
+	}
+}
+
+// funnel keeps per minute counts for a day and the last decisions.
+type funnel struct {
+	mu        sync.Mutex
+	buckets   map[int64]map[string]*funnelCounts
+	recent    []Decision
+	next      int
+	persisted int64
+}
+
+func newFunnel() *funnel {
+	return &funnel{buckets: make(map[int64]map[string]*funnelCounts)}
+}
+
+func (f *funnel) counts(t time.Time, market string) *funnelCounts {
+	key := t.Truncate(funnelBucket).UnixNano()
+	bucket, ok := f.buckets[key]
+	if !ok {
+		bucket = make(map[string]*funnelCounts)
+		f.buckets[key] = bucket
+		for k := range f.buckets {
+			if k < key-int64(funnelKeep) {
+				delete(f.buckets, k)
+			}
+		}
+	}
+	c, ok := bucket[market]
+	if !ok {
+		c = &funnelCounts{}
+		bucket[market] = c
+	}
+	return c
+}
+
+// decision counts every stage up to d.Stage and the reject reason.
+func (f *funnel) decision(d Decision) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := f.counts(d.Time, d.Market)
+	for s := StageReceived; s <= d.Stage; s++ {
+		c.Stages[s]++
+	}
+	if d.Reason != "" {
+		if c.Rejects == nil {
+			c.Rejects = make(map[string]int64)
+		}
+		c.Rejects[d.Reason]++
+	}
+	if len(f.recent) < recentDecisions {
+		f.recent = append(f.recent, d)
+	} else {
+		f.recent[f.next] = d
+	}
+	f.next = (f.next + 1) % recentDecisions
+}
+
+// stage counts a stage reached after Calc, filled or healed.
+func (f *funnel) stage(t time.Time, market string, s Stage) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.counts(t, market).Stages[s]++
+}
+
+func (f *funnel) rows(from time.Time, to time.Time) []FunnelRow {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	total := make(map[string]*funnelCounts)
+	for key, bucket := range f.buckets {
+		if key < from.Truncate(funnelBucket).UnixNano() || key >= to.UnixNano() {
+			continue
+		}
+		for market, c := range bucket {
+			if total[market] == nil {
+				total[market] = &funnelCounts{}
+			}
+			total[market].add(c)
+		}
+	}
+	rows := make([]FunnelRow, 0, len(total))
+	for market, c := range total {
+		rows = append(rows, newFunnelRow(market, c))
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Market < rows[j].Market })
+	return rows
+}
+
+func (f *funnel) lastDecisions(n int) []Decision {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if n > len(f.recent) {
+		n = len(f.recent)
+	}
+	list := make([]Decision, 0, n)
+	for i := 1; i <= n; i++ {
+		list = append(list, f.recent[(f.next-i+recentDecisions)%recentDecisions])
+	}
+	return list
+}
+
+// Funnel returns per market counts over the last window, rounded to whole minutes.
+func (p *Placer) Funnel(window time.Duration) []FunnelRow {
+	now := p.clock.Now()
+	return p.funnel.rows(now.Add(-window), now.Add(funnelBucket))
+}
+
+// Decisions returns the last n Calc decisions, newest first.
+func (p *Placer) Decisions(n int) []Decision {
+	return p.funnel.lastDecisions(n)
+}
+
+func (p *Placer) decide(sb *store.Surebet, stage Stage, reason rejectReason) {
+	d := Decision{
+		Time:           p.clock.Now(),
+		ID:             sb.ID,
+		Stage:          stage,
+		Reason:         string(reason),
+		Delay:          time.Duration(sb.StartTime - sb.ID),
+		Profit:         sb.ProfitSubAvg,
+		RequiredProfit: sb.RequiredProfit,
+		Volume:         sb.Volume,
+	}
+	if sb.FtxTicker != nil {
+		d.Market = sb.FtxTicker.Symbol
+	}
+	p.funnel.decision(d)
+	p.metrics.received.Inc()
+	p.metrics.natsDelay.Observe(d.Delay.Seconds())
+	if stage == StagePlaced {
+		p.metrics.placed.WithLabelValues(d.Market).Inc()
+	}
+	if reason != "" {
+		p.rejects.add(reason)
+		p.metrics.rejected.WithLabelValues(d.Reason).Inc()
+		p.log.Debug("surebet_rejected",
+			zap.Int64("i", sb.ID),
+			zap.String("s", d.Market),
+			zap.String("stage", stage.String()),
+			zap.String("reason", d.Reason),
+		)
+	}
+}
+
+// persistFunnel saves the finished minutes not saved yet.
+func (p *Placer) persistFunnel() {
+	now := p.clock.Now().Truncate(funnelBucket)
+	f := p.funnel
+	f.mu.Lock()
+	from := f.persisted
+	if from == 0 {
+		from = now.Add(-funnelKeep).UnixNano()
+	}
+	f.persisted = now.UnixNano()
+	f.mu.Unlock()
+	rows := f.rows(time.Unix(0, from), now)
+	if len(rows) == 0 {
+		return
+	}
+	data := make([]store.FunnelStat, 0, len(rows))
+	for _, r := range rows {
+		rejects, _ := json.Marshal(r.Rejects)
+		data = append(data, store.FunnelStat{
+			Start:      time.Unix(0, from),
+			End:        now,
+			Market:     r.Market,
+			Received:   r.Received,
+			Locked:     r.Locked,
+			Profitable: r.Profitable,
+			Sized:      r.Sized,
+			Placed:     r.Placed,
+			Filled:     r.Filled,
+			Healed:     r.Healed,
+			Rejects:    string(rejects),
+		})
+	}
+	err := p.store.SaveFunnelStats(data)
+	if err != nil {
+		p.log.Error("save_funnel_stats_error", zap.Error(err))
+	}
+}
@@ -3,35 +3,76 @@ package placer
 import (
 	"fmt"
 	"github.com/aibotsoft/crypto-surebet/pkg/store"
-	"github.com/aibotsoft/ftx-api"
 	"github.com/shopspring/decimal"
 	"go.uber.org/zap"
-	"time"
 )
 
 const million = 1000000
 const thousand = 1000
 
-func (p *Placer) placeHeal(h *store.Heal) {
+// cloneHeal copies h with its orders, the caller holds healLock or h is not in
+// healMap yet.
+func cloneHeal(h *store.Heal) store.Heal {
+	c := *h
+	c.Orders = nil
+	for _, o := range h.Orders {
+		o := *o
+		c.Orders = append(c.Orders, &o)
+	}
+	return c
+}
+
+// healCopy returns a copy of h safe to read while heal goroutines change h.
+func (p *Placer) healCopy(h *store.Heal) store.Heal {
+	p.healLock.Lock()
+	defer p.healLock.Unlock()
+	return cloneHeal(h)
+}
+
+// placeHeal places the next order of h and returns a copy of h after the placement.
+// Once h is in healMap its fields are changed under healLock only.
+func (p *Placer) placeHeal(h *store.Heal) store.Heal {
 	p.healMap.Store(h.ID, h)
+	if p.holdHeal(h) {
+		p.log.Warn("heal_held_by_kill_switch", zap.Int64("i", h.ID))
+		return p.healCopy(h)
+	}
+	p.healLock.Lock()
+	params := h.PlaceParams
+	p.healLock.Unlock()
 
+	var placed bool
 	for i := 0; i < 10; i++ {
-		resp, err := p.PlaceOrder(p.ctx, h.PlaceParams)
+		resp, err := p.PlaceOrder(p.ctx, params)
 		if err != nil {
 			p.log.Error("heal_error", zap.Int64("i", h.ID), zap.Error(err))
+			p.placeFailed(err)
+		}
+		p.healLock.Lock()
+		if err != nil {
 			msg := fmt.Sprintf("try:%d err:%s", i, err.Error())
 			if h.ErrorMsg != nil {
 				msg = fmt.Sprintf("%s :: %s", msg, *h.ErrorMsg)
 			}
-			h.ErrorMsg = ftxapi.StringPointer(msg)
+			h.ErrorMsg = stringPointer(msg)
 		}
 		if resp != nil {
 			h.Orders = append(h.Orders, resp)
+			placed = true
+		}
+		p.healLock.Unlock()
+		if placed {
 			break
 		}
 	}
-	h.Done = time.Now().UnixNano()
-	p.saveHealCh <- h
+	if !placed {
+		p.healFailed()
+	}
+	p.healLock.Lock()
+	h.Done = p.clock.Now().UnixNano()
+	p.healLock.Unlock()
+	p.saveHeal(h)
+	return p.healCopy(h)
 }
 
 func (p *Placer) FindHeal(id int64, withOrders bool) *store.Heal {
@@ -55,8 +96,10 @@ func (p *Placer) reHeal(order store.Order, clientID ClientID) {
 	h := p.FindHeal(clientID.ID, true)
 	if h == nil {
 		p.log.Error("not_found_heal", zap.Any("id", clientID.ID))
+		p.orphan(orphanHealMissing)
 		return
 	}
+	p.healLock.Lock()
 	for i := 0; i < len(h.Orders); i++ {
 		if h.Orders[i].ID == order.ID {
 			h.Orders[i] = &order
@@ -64,14 +107,14 @@ func (p *Placer) reHeal(order store.Order, clientID ClientID) {
 		}
 	}
 	var reverseInc bool
-	if time.Since(order.CreatedAt) > p.cfg.Service.ReHealPeriod {
+	if p.since(order.CreatedAt) > p.cfg.Service.ReHealPeriod {
 		p.log.Info("re_heal",
 			zap.Int64("i", h.ID),
 			zap.String("m", order.Market),
 			zap.String("s", string(order.Side)),
 			zap.Int64("order_id", order.ID),
 			zap.Int("order_count", len(h.Orders)),
-			zap.Duration("since", time.Since(order.CreatedAt)),
+			zap.Duration("since", p.since(order.CreatedAt)),
 			zap.Duration("period", p.cfg.Service.ReHealPeriod),
 			//zap.Any("orders", h.Orders),
 		)
@@ -85,6 +128,9 @@ func (p *Placer) reHeal(order store.Order, clientID ClientID) {
 	h.PlaceParams.Size = h.FilledSize.Sub(filledSizeSum).Div(h.MinSize).Floor().Mul(h.MinSize)
 	if h.PlaceParams.Size.LessThan(h.MinSize) {
 		p.healMap.Delete(clientID.ID)
+		filled := cloneHeal(h)
+		p.healLock.Unlock()
+		h = &filled
 		p.log.Info("heal_filled",
 			zap.Int64("i", h.ID),
 			zap.String("m", h.PlaceParams.Market),
@@ -95,10 +141,13 @@ func (p *Placer) reHeal(order store.Order, clientID ClientID) {
 			zap.Float64("min_size", h.MinSize.InexactFloat64()),
 			zap.Int("h_count", len(h.Orders)),
 			//zap.Any("order", order),
-			zap.Int64("el", (time.Now().UnixNano()-h.ID)/million),
+			zap.Int64("el", (p.clock.Now().UnixNano()-h.ID)/million),
 			zap.Int64("done_id_el", (h.Done-h.ID)/million),
-			zap.Duration("since_created", time.Since(order.CreatedAt)),
+			zap.Duration("since_created", p.since(order.CreatedAt)),
 		)
+		p.funnel.stage(p.clock.Now(), h.PlaceParams.Market, StageHealed)
+		p.metrics.healsFilled.Inc()
+		p.healDone(h)
 		p.checkBalanceCh <- h.Done
 		return
 	}
@@ -106,7 +155,7 @@ func (p *Placer) reHeal(order store.Order, clientID ClientID) {
 	clientID.Try = clientID.Try + 1
 	h.PlaceParams.ClientID = marshalClientID(clientID)
 	//TargetProfit*2 from original price
-	priceInc := h.PlaceParams.Price.Div(d100).Mul(p.placeConfig.TargetProfit.Mul(d2))
+	priceInc := h.PlaceParams.Price.Div(d100).Mul(p.placeConfigForMarket(h.PlaceParams.Market).TargetProfit.Mul(d2))
 
 	if h.PlaceParams.Side == store.SideSell {
 		if reverseInc {
@@ -123,8 +172,11 @@ func (p *Placer) reHeal(order store.Order, clientID ClientID) {
 	if h.ErrorMsg != nil {
 		msg = fmt.Sprintf("%s :: %s", msg, *h.ErrorMsg)
 	}
-	h.ErrorMsg = ftxapi.StringPointer(msg)
-	p.placeHeal(h)
+	h.ErrorMsg = stringPointer(msg)
+	p.healLock.Unlock()
+	p.metrics.healAttempts.WithLabelValues("retry").Inc()
+	placed := p.placeHeal(h)
+	h = &placed
 	p.log.Info("heal_add",
 		zap.Int64("i", h.ID),
 		zap.String("m", h.PlaceParams.Market),
@@ -139,7 +191,7 @@ func (p *Placer) reHeal(order store.Order, clientID ClientID) {
 		zap.Float64("mp_inc", h.PriceIncrement.InexactFloat64()),
 		zap.Int("h_count", len(h.Orders)),
 		zap.Int64("full_el", (h.Done-h.ID)/million),
-		zap.Duration("since_created", time.Since(order.CreatedAt)),
+		zap.Duration("since_created", p.since(order.CreatedAt)),
 	)
 }
 
@@ -147,26 +199,29 @@ func (p *Placer) heal(order store.Order, clientID ClientID) {
 	got, ok := p.surebetMap.LoadAndDelete(clientID.ID)
 	if !ok {
 		p.log.Warn("not_found_surebet_in_map", zap.Any("order", order))
+		p.orphan(orphanSurebetMissing)
 		return
 	}
 	lock := p.Lock(symbolFromMarket(order.Market))
 	defer func() {
 		id := <-lock
-		p.log.Debug("unlock", zap.Int64("id", id), zap.String("m", order.Market), zap.Int64("elapsed", (time.Now().UnixNano()-id)/1000000))
+		p.log.Debug("unlock", zap.Int64("id", id), zap.String("m", order.Market), zap.Int64("elapsed", (p.clock.Now().UnixNano()-id)/1000000))
 	}()
 	if order.FilledSize == 0 {
-		p.deleteSbCh <- order.ID
-		p.checkBalanceCh <- time.Now().UnixNano()
+		p.deleteSurebet(order.ID)
+		p.checkBalanceCh <- p.clock.Now().UnixNano()
 		return
 	}
 	sb := got.(*store.Surebet)
+	p.funnel.stage(p.clock.Now(), order.Market, StageFilled)
 	h := &store.Heal{
 		ID:             sb.ID,
-		Start:          time.Now().UnixNano(),
+		Start:          p.clock.Now().UnixNano(),
 		FilledSize:     decimal.NewFromFloat(order.FilledSize),
 		AvgFillPrice:   decimal.NewFromFloat(order.AvgFillPrice),
 		MinSize:        sb.Market.MinProvideSize,
 		PriceIncrement: sb.Market.PriceIncrement,
+		Paper:          sb.Paper,
 		PlaceParams: store.PlaceParamsEmb{
 			Market:   sb.PlaceParams.Market,
 			Type:     store.OrderTypeLimit,
@@ -196,13 +251,15 @@ func (p *Placer) heal(order store.Order, clientID ClientID) {
 	if h.PlaceParams.Size.LessThan(h.MinSize) {
 		p.log.Warn("size_too_small_to_heal", zap.Any("h", h))
 		msg := fmt.Sprintf("size:%v min_provide:%v", h.PlaceParams.Size, sb.Market.MinProvideSize)
-		h.ErrorMsg = ftxapi.StringPointer(msg)
-		h.Done = time.Now().UnixNano()
+		h.ErrorMsg = stringPointer(msg)
+		h.Done = p.clock.Now().UnixNano()
 		h.ProfitPart = decimal.Zero
-		p.saveHealCh <- h
+		p.saveHeal(h)
 		return
 	}
-	p.placeHeal(h)
+	p.metrics.healAttempts.WithLabelValues("first").Inc()
+	placed := p.placeHeal(h)
+	h = &placed
 	p.log.Info("heal",
 		zap.Int64("i", h.ID),
 		zap.String("m", h.PlaceParams.Market),
@@ -0,0 +1,40 @@
+package placer
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+// Handler serves the metrics and the admin api.
+func (p *Placer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", p.MetricsHandler())
+	if p.cfg.Http.Admin {
+		p.adminRoutes(mux)
+	}
+	return mux
+}
+
+// ListenHTTP listens on cfg.Http.Addr until the context is done.
+func (p *Placer) ListenHTTP(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:              p.cfg.Http.Addr,
+		Handler:           p.Handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	p.log.Info("http_server_start", zap.String("addr", p.cfg.Http.Addr))
+	err := srv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
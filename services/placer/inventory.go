@@ -0,0 +1,184 @@
+package placer
+
+import (
+	"context"
+	"github.com/aibotsoft/crypto-surebet/pkg/store"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"sort"
+	"sync"
+	"time"
+)
+
+// REBALANCE orders move a coin back into the inventory band, they have no heal.
+const REBALANCE = "r"
+
+var dMinus1 = decimal.NewFromInt(-1)
+
+// inventoryDev is how far the usd value of coin is from target, as a fraction of
+// target limited to -1..1. Positive means too much of the coin.
+func (p *Placer) inventoryDev(coin string, target decimal.Decimal) decimal.Decimal {
+	if !target.IsPositive() {
+		return decimal.Zero
+	}
+	dev := p.FindBalance(coin).UsdValue.Sub(target).Div(target)
+	return decimal.Max(dMinus1, decimal.Min(d1, dev)).Round(4)
+}
+
+// inventorySkew is positive when the bet moves the coin further from target, it is
+// added to the required profit in target profit units and cuts the size.
+func inventorySkew(dev decimal.Decimal, ratio decimal.Decimal, side store.Side) decimal.Decimal {
+	skew := dev.Mul(ratio)
+	if side == store.SideSell {
+		skew = skew.Neg()
+	}
+	return skew.Round(4)
+}
+
+type CoinInventory struct {
+	Coin      string          `json:"coin"`
+	Market    string          `json:"market"`
+	UsdValue  decimal.Decimal `json:"usd_value"`
+	Target    decimal.Decimal `json:"target"`
+	Deviation decimal.Decimal `json:"deviation"`
+	OrderID   int64           `json:"order_id,omitempty"`
+}
+
+type rebalanceOrder struct {
+	id       int64
+	placedAt time.Time
+}
+
+type rebalancer struct {
+	mu     sync.Mutex
+	orders map[string]rebalanceOrder
+}
+
+// Inventory lists the coins of the usd markets with their deviation from target.
+func (p *Placer) Inventory() []CoinInventory {
+	markets := make(map[string]string)
+	p.marketLock.Lock()
+	for name, m := range p.marketMap {
+		if m.QuoteCurrency == "USD" {
+			markets[m.BaseCurrency] = name
+		}
+	}
+	p.marketLock.Unlock()
+	p.rebalance.mu.Lock()
+	orders := make(map[string]rebalanceOrder, len(p.rebalance.orders))
+	for coin, o := range p.rebalance.orders {
+		orders[coin] = o
+	}
+	p.rebalance.mu.Unlock()
+	var list []CoinInventory
+	for coin, b := range p.Balances() {
+		market, ok := markets[coin]
+		if !ok {
+			continue
+		}
+		pc := p.placeConfigForMarket(market)
+		list = append(list, CoinInventory{
+			Coin:      coin,
+			Market:    market,
+			UsdValue:  b.UsdValue,
+			Target:    pc.InventoryTarget,
+			Deviation: p.inventoryDev(coin, pc.InventoryTarget),
+			OrderID:   orders[coin].id,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Coin < list[j].Coin })
+	return list
+}
+
+// Rebalance places a post only order for every coin out of the band without one,
+// and cancels its orders older than the rebalance period to follow the price.
+func (p *Placer) Rebalance() {
+	cfg := p.cfg.Inventory
+	if p.killed() || p.stopping() {
+		return
+	}
+	band := decimal.NewFromFloat(cfg.Band)
+	for _, inv := range p.Inventory() {
+		if inv.OrderID != 0 {
+			p.rebalance.mu.Lock()
+			o := p.rebalance.orders[inv.Coin]
+			p.rebalance.mu.Unlock()
+			if p.since(o.placedAt) > cfg.RebalancePeriod {
+				ctx, cancel := context.WithTimeout(p.ctx, 5*time.Second)
+				err := p.venue.CancelOrder(ctx, o.id)
+				cancel()
+				if err != nil {
+					p.log.Info("cancel_rebalance_error", zap.Int64("order_id", o.id), zap.Error(err))
+				}
+			}
+			continue
+		}
+		if inv.Deviation.Abs().LessThanOrEqual(band) {
+			continue
+		}
+		if _, ok := p.paused(inv.Market, inv.Coin); ok {
+			continue
+		}
+		p.placeRebalance(inv)
+	}
+}
+
+func (p *Placer) placeRebalance(inv CoinInventory) {
+	m := p.FindMarket(inv.Market)
+	got, ok := p.lastFtxPriceMap.Load(inv.Market)
+	if m == nil || !ok || m.MinProvideSize.IsZero() || m.PriceIncrement.IsZero() {
+		return
+	}
+	bid := got.(decimal.Decimal)
+	usd := decimal.Min(decimal.NewFromFloat(p.cfg.Inventory.OrderUsd), inv.Deviation.Abs().Mul(inv.Target))
+	params := store.PlaceParamsEmb{
+		Market:   inv.Market,
+		Type:     store.OrderTypeLimit,
+		PostOnly: true,
+		ClientID: marshalClientID(ClientID{ID: p.clock.Now().UnixNano(), Side: REBALANCE}),
+	}
+	if inv.Deviation.IsPositive() {
+		params.Side = store.SideSell
+		params.Price = bid.Add(m.PriceIncrement)
+	} else {
+		params.Side = store.SideBuy
+		params.Price = bid
+	}
+	params.Size = usd.Div(params.Price).Div(m.MinProvideSize).Floor().Mul(m.MinProvideSize)
+	if params.Size.IsZero() {
+		return
+	}
+	order, err := p.PlaceOrder(p.ctx, params)
+	if err != nil {
+		p.log.Warn("rebalance_error", zap.String("m", inv.Market), zap.Error(err))
+		return
+	}
+	p.rebalance.mu.Lock()
+	if p.rebalance.orders == nil {
+		p.rebalance.orders = make(map[string]rebalanceOrder)
+	}
+	p.rebalance.orders[inv.Coin] = rebalanceOrder{id: order.ID, placedAt: p.clock.Now()}
+	p.rebalance.mu.Unlock()
+	p.log.Info("rebalance",
+		zap.String("m", inv.Market),
+		zap.String("s", string(params.Side)),
+		zap.Float64("pr", params.Price.InexactFloat64()),
+		zap.Float64("sz", params.Size.InexactFloat64()),
+		zap.Float64("dev", inv.Deviation.InexactFloat64()),
+	)
+}
+
+// rebalanceClosed frees the coin for the next rebalance order.
+func (p *Placer) rebalanceClosed(o store.Order) {
+	coin := symbolFromMarket(o.Market)
+	p.rebalance.mu.Lock()
+	if p.rebalance.orders[coin].id == o.ID {
+		delete(p.rebalance.orders, coin)
+	}
+	p.rebalance.mu.Unlock()
+	if o.FilledSize == 0 {
+		return
+	}
+	p.log.Info("rebalance_filled", zap.String("m", o.Market), zap.String("s", string(o.Side)), zap.Float64("filled", o.FilledSize))
+	p.checkBalanceCh <- p.clock.Now().UnixNano()
+}
@@ -0,0 +1,214 @@
+package placer
+
+import (
+	"context"
+	"errors"
+	"github.com/aibotsoft/crypto-surebet/pkg/store"
+	"github.com/aibotsoft/crypto-surebet/pkg/venue"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+const rejectKillSwitch rejectReason = "kill_switch"
+
+const (
+	KillSourceAdmin  = "admin"
+	KillSourceSignal = "signal"
+	KillSourceAuto   = "auto"
+)
+
+var ErrKillSwitchNotActive = errors.New("kill_switch_not_active")
+
+// killSwitch halts betting until an explicit Rearm. Heals that could not be placed
+// while it is active without KeepHeals are held and placed on Rearm.
+type killSwitch struct {
+	mu    sync.Mutex
+	event *store.KillEvent
+	// save orders the writes of kill events, Rearm updates the row Kill inserted
+	save      sync.Mutex
+	held      map[int64]*store.Heal
+	healFails []time.Time
+}
+
+// Killed returns the active kill event, nil when trading is armed.
+func (p *Placer) Killed() *store.KillEvent {
+	p.kill.mu.Lock()
+	defer p.kill.mu.Unlock()
+	if p.kill.event == nil {
+		return nil
+	}
+	e := *p.kill.event
+	return &e
+}
+
+func (p *Placer) killed() bool {
+	p.kill.mu.Lock()
+	defer p.kill.mu.Unlock()
+	return p.kill.event != nil
+}
+
+// Kill stops placing bets and cancels every open bet and rebalance order, heal
+// orders are canceled too unless keepHeals. An active kill event is returned as is.
+func (p *Placer) Kill(source string, reason string, keepHeals bool) (*store.KillEvent, error) {
+	p.kill.save.Lock()
+	p.kill.mu.Lock()
+	if p.kill.event != nil {
+		e := *p.kill.event
+		p.kill.mu.Unlock()
+		p.kill.save.Unlock()
+		p.log.Info("kill_switch_already_active", zap.Int64("id", e.ID), zap.String("source", source), zap.String("reason", reason))
+		return &e, nil
+	}
+	e := store.KillEvent{
+		CreatedAt: p.clock.Now(),
+		Source:    source,
+		Reason:    reason,
+		KeepHeals: keepHeals,
+	}
+	p.kill.event = &e
+	p.kill.mu.Unlock()
+	p.log.Warn("kill_switch", zap.String("source", source), zap.String("reason", reason), zap.Bool("keep_heals", keepHeals))
+	saved := e
+	err := p.store.SaveKillEvent(&saved)
+	if err != nil {
+		p.log.Error("save_kill_event_error", zap.Error(err))
+	}
+	p.kill.mu.Lock()
+	e.ID = saved.ID
+	p.kill.mu.Unlock()
+	p.kill.save.Unlock()
+
+	canceled, cancelErr := p.cancelOnKill(keepHeals)
+	p.kill.save.Lock()
+	defer p.kill.save.Unlock()
+	p.kill.mu.Lock()
+	// a Rearm during the cancel saved the event armed, it must not be killed again
+	active := p.kill.event == &e
+	e.Canceled = canceled
+	saved = e
+	p.kill.mu.Unlock()
+	if active {
+		err = p.store.SaveKillEvent(&saved)
+		if err != nil {
+			p.log.Error("save_kill_event_error", zap.Error(err))
+		}
+	}
+	p.log.Warn("kill_switch_done", zap.Int64("id", saved.ID), zap.Int("canceled", canceled), zap.Bool("active", active), zap.Error(cancelErr))
+	return &saved, cancelErr
+}
+
+func (p *Placer) cancelOnKill(keepHeals bool) (int, error) {
+	ctx, cancel := context.WithTimeout(p.ctx, 10*time.Second)
+	defer cancel()
+	orders, err := p.venue.GetOpenOrders(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if !keepHeals {
+		return len(orders), p.venue.CancelAllOrders(ctx)
+	}
+	var canceled int
+	for _, o := range orders {
+		if o.ClientID == nil {
+			continue
+		}
+		clientID, err := UnmarshalClientID(*o.ClientID)
+		if err != nil || healSide(clientID.Side) {
+			continue
+		}
+		err = p.venue.CancelOrder(ctx, o.ID)
+		switch err {
+		case nil:
+			canceled++
+		case venue.ErrOrderAlreadyClosed, venue.ErrOrderAlreadyQueued:
+		default:
+			p.log.Error("kill_cancel_order_error", zap.Int64("order_id", o.ID), zap.Error(err))
+		}
+	}
+	return canceled, nil
+}
+
+// Rearm lets trading resume after a kill and places the heals held meanwhile.
+func (p *Placer) Rearm(by string) (*store.KillEvent, error) {
+	p.kill.save.Lock()
+	defer p.kill.save.Unlock()
+	p.kill.mu.Lock()
+	if p.kill.event == nil {
+		p.kill.mu.Unlock()
+		return nil, ErrKillSwitchNotActive
+	}
+	e := *p.kill.event
+	p.kill.event = nil
+	held := p.kill.held
+	p.kill.held = nil
+	p.kill.healFails = nil
+	p.kill.mu.Unlock()
+
+	now := p.clock.Now()
+	e.ArmedAt = &now
+	e.ArmedBy = stringPointer(by)
+	err := p.store.SaveKillEvent(&e)
+	if err != nil {
+		p.log.Error("save_kill_event_error", zap.Error(err))
+	}
+	p.log.Warn("kill_switch_rearmed", zap.Int64("id", e.ID), zap.String("by", by), zap.Int("held_heals", len(held)))
+	for _, h := range held {
+		h := h
+		p.async(func() { p.placeHeal(h) })
+	}
+	return &e, nil
+}
+
+// holdHeal keeps h from being placed while the kill switch cancels heals too.
+func (p *Placer) holdHeal(h *store.Heal) bool {
+	p.kill.mu.Lock()
+	defer p.kill.mu.Unlock()
+	if p.kill.event == nil || p.kill.event.KeepHeals {
+		return false
+	}
+	if p.kill.held == nil {
+		p.kill.held = make(map[int64]*store.Heal)
+	}
+	p.kill.held[h.ID] = h
+	return true
+}
+
+// healFailed trips the kill switch when too many heals could not be placed at all,
+// the bet side stays unhedged then.
+func (p *Placer) healFailed() {
+	limit := p.cfg.Kill.HealFailLimit
+	if limit <= 0 {
+		return
+	}
+	now := p.clock.Now()
+	p.kill.mu.Lock()
+	fails := p.kill.healFails[:0]
+	for _, t := range p.kill.healFails {
+		if now.Sub(t) < p.cfg.Kill.HealFailWindow {
+			fails = append(fails, t)
+		}
+	}
+	fails = append(fails, now)
+	p.kill.healFails = fails
+	trip := len(fails) >= limit && p.kill.event == nil
+	p.kill.mu.Unlock()
+	if trip {
+		p.async(func() {
+			_, _ = p.Kill(KillSourceAuto, "heal_fail_limit", p.cfg.Kill.KeepHeals)
+		})
+	}
+}
+
+// loadKill restores a kill event not re-armed before the restart.
+func (p *Placer) loadKill() error {
+	e, err := p.store.SelectActiveKillEvent()
+	if err != nil || e == nil {
+		return err
+	}
+	p.kill.mu.Lock()
+	p.kill.event = e
+	p.kill.mu.Unlock()
+	p.log.Warn("kill_switch_active", zap.Int64("id", e.ID), zap.Time("since", e.CreatedAt), zap.String("reason", e.Reason))
+	return nil
+}
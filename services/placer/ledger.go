@@ -0,0 +1,259 @@
+package placer
+
+import (
+	"github.com/aibotsoft/crypto-surebet/pkg/store"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"sort"
+	"time"
+)
+
+const ledgerPageSize = 1000
+
+// fillFee converts the fee to the quote currency, fees in the base currency are
+// valued at the fill price and fees in other coins are taken as quote.
+func fillFee(f store.Fills) decimal.Decimal {
+	fee := decimal.NewFromFloat(f.Fee)
+	if f.FeeCurrency != f.QuoteCurrency && f.BaseCurrency != nil && f.FeeCurrency == *f.BaseCurrency {
+		return fee.Mul(decimal.NewFromFloat(f.Price))
+	}
+	return fee
+}
+
+// BuildCycle computes the realized pnl of a bet and its heal from their fills. Only
+// the size matched by heal fills is realized, fees of all fills are subtracted.
+func BuildCycle(sb store.Surebet, h *store.Heal, fills []store.Fills) store.PnlCycle {
+	c := store.PnlCycle{
+		ID:     sb.ID,
+		Market: sb.PlaceParams.Market,
+		Side:   sb.PlaceParams.Side,
+		Paper:  sb.Paper,
+	}
+	healOrders := make(map[int64]bool)
+	if h != nil {
+		c.ExpectedProfit = h.ProfitPart
+		for _, o := range h.Orders {
+			healOrders[o.ID] = true
+		}
+		if len(h.Orders) > 1 {
+			c.ReHeals = len(h.Orders) - 1
+		}
+	}
+	for _, f := range fills {
+		size := decimal.NewFromFloat(f.Size)
+		value := size.Mul(decimal.NewFromFloat(f.Price))
+		if f.OrderID == sb.OrderID {
+			c.BetSize = c.BetSize.Add(size)
+			c.BetValue = c.BetValue.Add(value)
+		} else if healOrders[f.OrderID] {
+			c.HealSize = c.HealSize.Add(size)
+			c.HealValue = c.HealValue.Add(value)
+		} else {
+			continue
+		}
+		c.Fees = c.Fees.Add(fillFee(f))
+		c.Fills++
+	}
+	c.OpenSize = c.BetSize.Sub(c.HealSize)
+	matched := decimal.Min(c.BetSize, c.HealSize)
+	if matched.IsPositive() {
+		spread := c.HealValue.Div(c.HealSize).Sub(c.BetValue.Div(c.BetSize))
+		if c.Side == store.SideSell {
+			spread = spread.Neg()
+		}
+		c.RealizedPnl = matched.Mul(spread)
+	}
+	c.RealizedPnl = c.RealizedPnl.Sub(c.Fees)
+	if h != nil && c.BetSize.IsPositive() {
+		c.Closed = c.OpenSize.LessThan(h.MinSize) || !c.OpenSize.IsPositive()
+	}
+	return c
+}
+
+// CycleSource is the part of the store cycles are loaded from, *store.Store or
+// *store.Memory.
+type CycleSource interface {
+	SelectSurebets(afterID int64, toID int64, limit int) ([]store.Surebet, error)
+	SelectHealsPage(afterID int64, toID int64, limit int) ([]store.Heal, error)
+	SelectFillsByOrderIDs(ids []int64) ([]store.Fills, error)
+}
+
+// CycleData is a placed surebet with its heal, nil when there is none, and the fills
+// of the bet and heal orders.
+type CycleData struct {
+	Surebet store.Surebet
+	Heal    *store.Heal
+	Fills   []store.Fills
+}
+
+// LoadCycles loads placed surebets with id in (afterID, toID] with their heals and
+// fills, paper surebets only when paper is set. Heals and fills are selected by pages,
+// a busy day of them is over the postgres bind parameter limit.
+func LoadCycles(src CycleSource, afterID int64, toID int64, paper bool) ([]CycleData, error) {
+	var surebets []store.Surebet
+	for id := afterID; ; {
+		data, err := src.SelectSurebets(id, toID, ledgerPageSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, sb := range data {
+			if sb.OrderID != 0 && sb.Paper == paper {
+				surebets = append(surebets, sb)
+			}
+		}
+		if len(data) < ledgerPageSize {
+			break
+		}
+		id = data[len(data)-1].ID
+	}
+	if len(surebets) == 0 {
+		return nil, nil
+	}
+	healMap := make(map[int64]*store.Heal)
+	for id := surebets[0].ID - 1; ; {
+		heals, err := src.SelectHealsPage(id, toID, ledgerPageSize)
+		if err != nil {
+			return nil, err
+		}
+		for i := range heals {
+			healMap[heals[i].ID] = &heals[i]
+		}
+		if len(heals) < ledgerPageSize {
+			break
+		}
+		id = heals[len(heals)-1].ID
+	}
+	var ids []int64
+	for _, sb := range surebets {
+		ids = append(ids, sb.OrderID)
+		if h := healMap[sb.ID]; h != nil {
+			for _, o := range h.Orders {
+				ids = append(ids, o.ID)
+			}
+		}
+	}
+	byOrder := make(map[int64][]store.Fills)
+	for len(ids) > 0 {
+		n := ledgerPageSize
+		if n > len(ids) {
+			n = len(ids)
+		}
+		fills, err := src.SelectFillsByOrderIDs(ids[:n])
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range fills {
+			byOrder[f.OrderID] = append(byOrder[f.OrderID], f)
+		}
+		ids = ids[n:]
+	}
+	list := make([]CycleData, 0, len(surebets))
+	for _, sb := range surebets {
+		d := CycleData{Surebet: sb, Heal: healMap[sb.ID]}
+		d.Fills = append(d.Fills, byOrder[sb.OrderID]...)
+		if d.Heal != nil {
+			for _, o := range d.Heal.Orders {
+				d.Fills = append(d.Fills, byOrder[o.ID]...)
+			}
+		}
+		list = append(list, d)
+	}
+	return list, nil
+}
+
+// UpdateLedger rebuilds the pnl cycles of surebets placed in the current mode, live
+// or paper, within the ledger lookback and saves them, it returns how many were saved.
+func (p *Placer) UpdateLedger() (int, error) {
+	now := p.clock.Now()
+	data, err := LoadCycles(p.store, now.Add(-p.cfg.Ledger.Lookback).UnixNano(), now.UnixNano(), p.cfg.Service.PaperMode)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) == 0 {
+		return 0, nil
+	}
+	cycles := make([]store.PnlCycle, 0, len(data))
+	var fills int
+	for _, d := range data {
+		c := BuildCycle(d.Surebet, d.Heal, d.Fills)
+		c.UpdatedAt = now
+		cycles = append(cycles, c)
+		fills += len(d.Fills)
+	}
+	err = p.store.SavePnlCycles(cycles)
+	if err != nil {
+		return 0, err
+	}
+	p.log.Debug("ledger_updated", zap.Int("cycles", len(cycles)), zap.Int("fills", fills))
+	return len(cycles), nil
+}
+
+// LedgerTotal sums pnl cycles, of one market or of all when Market is empty.
+type LedgerTotal struct {
+	Market         string          `json:"market"`
+	Count          int             `json:"count"`
+	Closed         int             `json:"closed"`
+	ReHeals        int             `json:"re_heals"`
+	BetValue       decimal.Decimal `json:"bet_value"`
+	Fees           decimal.Decimal `json:"fees"`
+	ExpectedProfit decimal.Decimal `json:"expected_profit"`
+	RealizedPnl    decimal.Decimal `json:"realized_pnl"`
+}
+
+func (t *LedgerTotal) add(c store.PnlCycle) {
+	t.Count++
+	if c.Closed {
+		t.Closed++
+	}
+	t.ReHeals += c.ReHeals
+	t.BetValue = t.BetValue.Add(c.BetValue)
+	t.Fees = t.Fees.Add(c.Fees)
+	t.ExpectedProfit = t.ExpectedProfit.Add(c.ExpectedProfit)
+	t.RealizedPnl = t.RealizedPnl.Add(c.RealizedPnl)
+}
+
+type LedgerReport struct {
+	From    time.Time        `json:"from"`
+	To      time.Time        `json:"to"`
+	Total   LedgerTotal      `json:"total"`
+	Markets []LedgerTotal    `json:"markets"`
+	Cycles  []store.PnlCycle `json:"cycles,omitempty"`
+}
+
+// SumCycles totals cycles overall and per market, markets sorted by realized pnl.
+func SumCycles(cycles []store.PnlCycle) (LedgerTotal, []LedgerTotal) {
+	var total LedgerTotal
+	byMarket := make(map[string]*LedgerTotal)
+	for _, c := range cycles {
+		total.add(c)
+		t, ok := byMarket[c.Market]
+		if !ok {
+			t = &LedgerTotal{Market: c.Market}
+			byMarket[c.Market] = t
+		}
+		t.add(c)
+	}
+	markets := make([]LedgerTotal, 0, len(byMarket))
+	for _, t := range byMarket {
+		markets = append(markets, *t)
+	}
+	sort.Slice(markets, func(i, j int) bool {
+		return markets[i].RealizedPnl.GreaterThan(markets[j].RealizedPnl)
+	})
+	return total, markets
+}
+
+// Ledger reports the saved pnl cycles of surebets placed in [from, to), live or paper
+// ones.
+func (p *Placer) Ledger(from time.Time, to time.Time, market string, paper bool, withCycles bool) (*LedgerReport, error) {
+	cycles, err := p.store.SelectPnlCycles(from, to, market, paper)
+	if err != nil {
+		return nil, err
+	}
+	r := &LedgerReport{From: from, To: to}
+	r.Total, r.Markets = SumCycles(cycles)
+	if withCycles {
+		r.Cycles = cycles
+	}
+	return r, nil
+}
@@ -7,13 +7,7 @@ import (
 
 func (p *Placer) GetMarkets() error {
 	//start := time.Now()
-	resp, err := p.client.NewGetMarketsService().Do(p.ctx)
-	if err != nil {
-		return err
-	}
-	//fmt.Println(resp)
-	var data []store.Market
-	err = copier.Copy(&data, resp)
+	data, err := p.venue.GetMarkets(p.ctx)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,186 @@
+package placer
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"time"
+)
+
+const metricsNamespace = "placer"
+
+// metrics are registered in a registry of the placer, so simulations can run
+// many placers in one process.
+type metrics struct {
+	registry *prometheus.Registry
+
+	received       prometheus.Counter
+	rejected       *prometheus.CounterVec
+	placed         *prometheus.CounterVec
+	natsDelay      prometheus.Histogram
+	placeLatency   *prometheus.HistogramVec
+	placeErrors    *prometheus.CounterVec
+	cancels        *prometheus.CounterVec
+	healAttempts   *prometheus.CounterVec
+	healsFilled    prometheus.Counter
+	fills          *prometheus.CounterVec
+	balanceRefresh *prometheus.CounterVec
+	breakerTrips   *prometheus.CounterVec
+	orphans        *prometheus.CounterVec
+	inventoryDrift *prometheus.GaugeVec
+	writerRows     *prometheus.CounterVec
+	writerBatches  *prometheus.CounterVec
+	writerRetries  *prometheus.CounterVec
+	writerDropped  *prometheus.CounterVec
+	writerLatency  *prometheus.HistogramVec
+}
+
+func newMetrics(p *Placer) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		received: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Name: "surebets_received_total",
+			Help: "Surebets received by Calc.",
+		}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Name: "surebets_rejected_total",
+			Help: "Surebets rejected by Calc by reason.",
+		}, []string{"reason"}),
+		placed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Name: "surebets_placed_total",
+			Help: "Bets placed by market.",
+		}, []string{"market"}),
+		natsDelay: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace, Name: "nats_delay_seconds",
+			Help:    "Time from surebet creation to Calc start.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 2, 16),
+		}),
+		placeLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace, Name: "order_place_seconds",
+			Help:    "Order placement latency by kind, bet or heal.",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+		}, []string{"kind"}),
+		placeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Name: "order_place_errors_total",
+			Help: "Failed order placements by kind.",
+		}, []string{"kind"}),
+		cancels: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Name: "bet_cancels_total",
+			Help: "Bet cancel outcomes: canceled, already_canceled, queued_cancel, error.",
+		}, []string{"outcome"}),
+		healAttempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Name: "heal_attempts_total",
+			Help: "Heal placements, first heal or retry.",
+		}, []string{"kind"}),
+		healsFilled: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Name: "heals_filled_total",
+			Help: "Heals filled completely.",
+		}),
+		fills: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Name: "fills_total",
+			Help: "Fills by liquidity.",
+		}, []string{"liquidity"}),
+		balanceRefresh: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Name: "balance_refresh_total",
+			Help: "Balance refreshes by result.",
+		}, []string{"result"}),
+		breakerTrips: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Name: "breaker_trips_total",
+			Help: "Circuit breaker trips by breaker.",
+		}, []string{"breaker"}),
+		orphans: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Name: "orphan_events_total",
+			Help: "Order events without a known surebet or heal by kind.",
+		}, []string{"kind"}),
+		inventoryDrift: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace, Name: "inventory_drift_usd",
+			Help: "Balance minus expected inventory by coin, in usd.",
+		}, []string{"coin"}),
+		writerRows: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Name: "writer_rows_total",
+			Help: "Rows written by the writer by table.",
+		}, []string{"table"}),
+		writerBatches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Name: "writer_batches_total",
+			Help: "Batches written by the writer by table.",
+		}, []string{"table"}),
+		writerRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Name: "writer_retries_total",
+			Help: "Batch writes retried after a transient error by table.",
+		}, []string{"table"}),
+		writerDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Name: "writer_dropped_total",
+			Help: "Rows dropped by the writer by table.",
+		}, []string{"table"}),
+		writerLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace, Name: "writer_batch_seconds",
+			Help:    "Batch write latency with retries by table.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+		}, []string{"table"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.received, m.rejected, m.placed, m.natsDelay, m.placeLatency, m.placeErrors,
+		m.cancels, m.healAttempts, m.healsFilled, m.fills, m.balanceRefresh, m.breakerTrips,
+		m.orphans, m.inventoryDrift,
+		m.writerRows, m.writerBatches, m.writerRetries, m.writerDropped, m.writerLatency,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace, Name: "nats_delay_avg_seconds",
+			Help: "Moving average of the nats delay.",
+		}, func() float64 { return p.delay.Avg() / float64(time.Second) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace, Name: "active_locks",
+			Help: "Symbol locks held now.",
+		}, func() float64 { return float64(len(p.activeLocks())) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace, Name: "kill_switch_active",
+			Help: "1 while the kill switch halts trading.",
+		}, func() float64 {
+			if p.killed() {
+				return 1
+			}
+			return 0
+		}),
+	)
+	channels := map[string]func() int{
+		"check_balance": func() int { return len(p.checkBalanceCh) },
+		"open_order":    func() int { return len(p.openOrderCh) },
+	}
+	for name, length := range channels {
+		length := length
+		m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   metricsNamespace,
+			Name:        "channel_depth",
+			Help:        "Items waiting in the Serve loop channels.",
+			ConstLabels: prometheus.Labels{"channel": name},
+		}, func() float64 { return float64(length()) }))
+	}
+	for _, table := range []string{tableOrders, tableSurebets, tableHeals, tableFills, tableDeletes} {
+		table := table
+		m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   metricsNamespace,
+			Name:        "writer_queue_depth",
+			Help:        "Rows waiting for the writer by table.",
+			ConstLabels: prometheus.Labels{"table": table},
+		}, func() float64 { return float64(p.queued(table)) }))
+	}
+	return m
+}
+
+// MetricsHandler serves the placer metrics in the prometheus format.
+func (p *Placer) MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(p.metrics.registry, promhttp.HandlerOpts{})
+}
+
+func orderKind(clientID string) string {
+	c, err := UnmarshalClientID(clientID)
+	if err == nil && healSide(c.Side) {
+		return "heal"
+	}
+	if err == nil && c.Side == REBALANCE {
+		return "rebalance"
+	}
+	return "bet"
+}
@@ -4,8 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/aibotsoft/crypto-surebet/pkg/store"
-	ftxapi "github.com/aibotsoft/ftx-api"
-	"github.com/jinzhu/copier"
+	"github.com/aibotsoft/crypto-surebet/pkg/venue"
 	"github.com/shopspring/decimal"
 	"go.uber.org/zap"
 	"runtime"
@@ -13,80 +12,51 @@ import (
 	"time"
 )
 
-func DecimalToFloat64(d decimal.Decimal) float64 {
-	f, _ := d.Float64()
-	return f
-}
 func (p *Placer) PlaceOrder(ctx context.Context, param store.PlaceParamsEmb) (*store.Order, error) {
-	//start := time.Now()
-	data := ftxapi.PlaceOrderParams{
-		Market:   param.Market,
-		Side:     ftxapi.Side(param.Side),
-		Price:    DecimalToFloat64(param.Price),
-		Type:     ftxapi.OrderType(param.Type),
-		Size:     DecimalToFloat64(param.Size),
-		Ioc:      &param.Ioc,
-		PostOnly: &param.PostOnly,
-		ClientID: ftxapi.StringPointer(param.ClientID),
-	}
-	//p.log.Info("params", zap.Any("p", data))
-	resp, err := p.client.NewPlaceOrderService().Params(data).Do(ctx)
+	start := time.Now()
+	order, err := p.venue.PlaceOrder(ctx, param)
+	kind := orderKind(param.ClientID)
+	p.metrics.placeLatency.WithLabelValues(kind).Observe(time.Since(start).Seconds())
 	if err != nil {
-		return nil, err
+		p.metrics.placeErrors.WithLabelValues(kind).Inc()
 	}
-	var o store.Order
-	err = copier.Copy(&o, resp)
-	if err != nil {
-		return nil, err
-	}
-	//p.log.Info("place_done",
-	//	zap.Duration("elapsed", time.Since(start)),
-	//	zap.Any("params", data),
-	//	//zap.Any("resp", resp),
-	//)
-	//fmt.Println(resp)
-	return &o, nil
-
+	return order, err
 }
 
-func (p *Placer) processOrder(order *ftxapi.WsOrdersEvent) {
-	if order.Data.ClientID == nil {
-		p.log.Info("order_client_id_null", zap.Any("data", order.Data))
-		return
-	}
-	var o store.Order
-	err := copier.Copy(&o, order.Data)
-	if err != nil {
-		p.log.Error("copy_order_error", zap.Error(err))
+func (p *Placer) processOrder(order *store.Order) {
+	if order.ClientID == nil {
+		p.log.Info("order_client_id_null", zap.Any("data", order))
+		p.orphan(orphanNullClientID)
 		return
 	}
-	clientID, err := unmarshalClientID(*o.ClientID)
+	o := *order
+	clientID, err := UnmarshalClientID(*o.ClientID)
 	if err != nil {
 		return
 	}
 	if o.Status == store.OrderStatusClosed {
 		p.openOrderMap.Delete(o.ID)
-		o.ClosedAt = ftxapi.Int64Pointer(time.Now().UnixNano())
+		o.ClosedAt = int64Pointer(p.clock.Now().UnixNano())
 		if clientID.Side == BET {
-			go p.heal(o, clientID)
+			p.async(func() { p.heal(o, clientID) })
+		} else if clientID.Side == REBALANCE {
+			p.rebalanceClosed(o)
 		} else {
-			go p.reHeal(o, clientID)
+			if clientID.Side == CORRECT {
+				p.correctionClosed(o)
+			}
+			p.async(func() { p.reHeal(o, clientID) })
 		}
 
 	} else {
 		p.openOrderMap.Store(o.ID, o)
 	}
-	p.store.SaveOrder(&o)
+	p.saveOrder(o)
 }
 func (p *Placer) GetOpenOrders() error {
 	ctx, cancel := context.WithTimeout(p.ctx, 5*time.Second)
 	defer cancel()
-	resp, err := p.client.NewGetOpenOrdersService().Do(ctx)
-	if err != nil {
-		return err
-	}
-	var data []store.Order
-	err = copier.Copy(&data, resp)
+	data, err := p.venue.GetOpenOrders(ctx)
 	if err != nil {
 		return err
 	}
@@ -124,7 +94,7 @@ func (p *Placer) GetOpenBuySell(coin string) (decimal.Decimal, decimal.Decimal)
 }
 func (p *Placer) GetOrdersHistory() error {
 	start := time.Now()
-	resp, _, err := p.client.NewGetOrderHistoryService().Do(p.ctx)
+	resp, err := p.venue.GetOrderHistory(p.ctx)
 	if err != nil {
 		return fmt.Errorf("GetOrdersHistory_error: %w", err)
 	}
@@ -145,24 +115,19 @@ func (p *Placer) GetOrdersHistory() error {
 	)
 	return nil
 }
-func (p *Placer) processFills(fills *ftxapi.WsFillsEvent) {
-	p.log.Debug("fills", zap.Any("data", fills.Data))
-	var data store.Fills
-	err := copier.Copy(&data, fills.Data)
-	if err != nil {
-		p.log.Warn("copy_fills_error", zap.Error(err))
-		return
-	}
-	p.saveFillsCh <- &data
+func (p *Placer) processFills(fills *store.Fills) {
+	p.log.Debug("fills", zap.Any("data", fills))
+	p.metrics.fills.WithLabelValues(fills.Liquidity).Inc()
+	p.saveFills(fills)
 }
 func (p *Placer) processOpenOrder(order *store.Order) {
 	if order.ClientID == nil {
 		return
 	}
-	if time.Since(order.CreatedAt) < p.cfg.Service.ReHealPeriod {
+	if p.since(order.CreatedAt) < p.cfg.Service.ReHealPeriod {
 		return
 	}
-	clientID, err := unmarshalClientID(*order.ClientID)
+	clientID, err := UnmarshalClientID(*order.ClientID)
 	if err != nil {
 		return
 	}
@@ -177,8 +142,9 @@ func (p *Placer) processOpenOrder(order *store.Order) {
 	lastPrice := got.(decimal.Decimal)
 	price := decimal.NewFromFloat(order.Price)
 	//percentage difference = 100 * |a - b| / ((a + b) / 2)
+	rehealThreshold := p.placeConfigForMarket(order.Market).RehealThreshold
 	percentDiff := price.Sub(lastPrice).Abs().Div(price.Add(lastPrice).Div(d2)).Mul(d100)
-	if percentDiff.LessThanOrEqual(p.placeConfig.RehealThreshold) {
+	if percentDiff.LessThanOrEqual(rehealThreshold) {
 		p.log.Info("stale_near",
 			zap.Int64("i", heal.ID),
 			zap.String("m", order.Market),
@@ -186,9 +152,9 @@ func (p *Placer) processOpenOrder(order *store.Order) {
 			zap.Float64("pr", order.Price),
 			zap.Float64("last_price", lastPrice.InexactFloat64()),
 			zap.Float64("percent_diff", percentDiff.Round(4).InexactFloat64()),
-			zap.Float64("inc_percent", p.placeConfig.RehealThreshold.InexactFloat64()),
+			zap.Float64("inc_percent", rehealThreshold.InexactFloat64()),
 			zap.Float64("sz", order.Size),
-			zap.Duration("since", time.Since(order.CreatedAt)),
+			zap.Duration("since", p.since(order.CreatedAt)),
 			//zap.Int("order_count", len(heal.Orders)),
 			//zap.Any("clientID", clientID),
 			zap.Int64("order_id", order.ID),
@@ -203,9 +169,9 @@ func (p *Placer) processOpenOrder(order *store.Order) {
 		zap.Float64("pr", order.Price),
 		zap.Float64("last_price", lastPrice.InexactFloat64()),
 		zap.Float64("percent_diff", percentDiff.Round(4).InexactFloat64()),
-		zap.Float64("inc_percent", p.placeConfig.RehealThreshold.InexactFloat64()),
+		zap.Float64("inc_percent", rehealThreshold.InexactFloat64()),
 		zap.Float64("sz", order.Size),
-		zap.Duration("since", time.Since(order.CreatedAt)),
+		zap.Duration("since", p.since(order.CreatedAt)),
 		//zap.Int("order_count", len(heal.Orders)),
 		//zap.Any("clientID", clientID),
 		zap.Int64("order_id", order.ID),
@@ -213,40 +179,42 @@ func (p *Placer) processOpenOrder(order *store.Order) {
 	)
 	ctx, cancel := context.WithTimeout(p.ctx, 5*time.Second)
 	defer cancel()
-	err = p.client.NewCancelOrderService().OrderID(order.ID).Do(ctx)
+	err = p.venue.CancelOrder(ctx, order.ID)
 	if err != nil {
 		p.log.Error("cancel_stale_order_error", zap.Error(err))
 	}
 }
 func (p *Placer) cancelBetOrder(orderID int64, id int64) {
-	timer := time.NewTimer(p.cfg.Service.BetCancelPeriod)
-	<-timer.C
 	start := time.Now()
 
 	for i := 0; i < 10; i++ {
 		ctx, cancel := context.WithTimeout(p.ctx, 5*time.Second)
-		err := p.client.NewCancelOrderService().OrderID(orderID).Do(ctx)
+		err := p.venue.CancelOrder(ctx, orderID)
 		cancel()
 		switch err {
 		case nil:
+			p.metrics.cancels.WithLabelValues("canceled").Inc()
 			p.log.Info("canceled", zap.Int64("i", id), zap.Int64("order_id", orderID),
 				zap.Duration("cancel_delay", p.cfg.Service.BetCancelPeriod),
 				zap.Duration("cancel_elapsed", time.Since(start)),
 			)
 			return
-		case ftxapi.OrderAlreadyClosed:
+		case venue.ErrOrderAlreadyClosed:
+			p.metrics.cancels.WithLabelValues("already_canceled").Inc()
 			p.log.Info("already_canceled", zap.Int64("i", id), zap.Int64("order_id", orderID),
 				zap.Duration("cancel_delay", p.cfg.Service.BetCancelPeriod),
 				zap.Duration("cancel_elapsed", time.Since(start)),
 			)
 			return
-		case ftxapi.OrderAlreadyQueued:
+		case venue.ErrOrderAlreadyQueued:
+			p.metrics.cancels.WithLabelValues("queued_cancel").Inc()
 			p.log.Info("queued_cancel", zap.Int64("i", id), zap.Int64("order_id", orderID),
 				zap.Duration("cancel_delay", p.cfg.Service.BetCancelPeriod),
 				zap.Duration("cancel_elapsed", time.Since(start)),
 			)
 			return
 		}
+		p.metrics.cancels.WithLabelValues("error").Inc()
 		p.log.Error("cancel_bet_order_error", zap.Int64("i", id), zap.Int64("order_id", orderID), zap.Error(err))
 	}
 }
@@ -0,0 +1,117 @@
+package placer
+
+import (
+	"go.uber.org/zap"
+	"sort"
+	"sync"
+	"time"
+)
+
+// pauseAll is the key of the global pause.
+const pauseAll = "*"
+
+const rejectPaused rejectReason = "paused"
+
+// Pause stops new bets globally (*), for a market or for a base currency. Heals go on.
+type Pause struct {
+	Key    string    `json:"key"`
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until,omitempty"`
+	Reason string    `json:"reason"`
+}
+
+type pauses struct {
+	mu   sync.Mutex
+	list map[string]Pause
+}
+
+// Pause stops betting on key until Resume, or for d when d > 0.
+func (p *Placer) Pause(key string, reason string, d time.Duration) Pause {
+	if key == "" {
+		key = pauseAll
+	}
+	now := p.clock.Now()
+	ps := Pause{Key: key, Since: now, Reason: reason}
+	if d > 0 {
+		ps.Until = now.Add(d)
+	}
+	p.pauses.mu.Lock()
+	if p.pauses.list == nil {
+		p.pauses.list = make(map[string]Pause)
+	}
+	p.pauses.list[key] = ps
+	p.pauses.mu.Unlock()
+	p.log.Info("pause", zap.String("key", key), zap.String("reason", reason), zap.Duration("duration", d))
+	return ps
+}
+
+// Resume removes the pause of key and reports whether there was one.
+func (p *Placer) Resume(key string) bool {
+	if key == "" {
+		key = pauseAll
+	}
+	p.pauses.mu.Lock()
+	_, ok := p.pauses.list[key]
+	delete(p.pauses.list, key)
+	p.pauses.mu.Unlock()
+	if ok {
+		p.log.Info("resume", zap.String("key", key))
+	}
+	return ok
+}
+
+func (p *Placer) Pauses() []Pause {
+	p.expirePauses()
+	p.pauses.mu.Lock()
+	defer p.pauses.mu.Unlock()
+	list := make([]Pause, 0, len(p.pauses.list))
+	for _, ps := range p.pauses.list {
+		list = append(list, ps)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	return list
+}
+
+func (p *Placer) expirePauses() {
+	now := p.clock.Now()
+	p.pauses.mu.Lock()
+	var expired []Pause
+	for key, ps := range p.pauses.list {
+		if !ps.Until.IsZero() && !now.Before(ps.Until) {
+			delete(p.pauses.list, key)
+			expired = append(expired, ps)
+		}
+	}
+	p.pauses.mu.Unlock()
+	for _, ps := range expired {
+		p.log.Info("pause_expired", zap.String("key", ps.Key), zap.String("reason", ps.Reason))
+	}
+}
+
+// pauseOf returns the pause set for exactly key.
+func (p *Placer) pauseOf(key string) (Pause, bool) {
+	p.expirePauses()
+	p.pauses.mu.Lock()
+	defer p.pauses.mu.Unlock()
+	ps, ok := p.pauses.list[key]
+	return ps, ok
+}
+
+// paused returns the pause stopping bets on the market, if any.
+func (p *Placer) paused(market string, base string) (Pause, bool) {
+	p.pauses.mu.Lock()
+	n := len(p.pauses.list)
+	p.pauses.mu.Unlock()
+	if n == 0 {
+		return Pause{}, false
+	}
+	p.expirePauses()
+	p.pauses.mu.Lock()
+	defer p.pauses.mu.Unlock()
+	for _, key := range []string{pauseAll, market, base} {
+		if ps, ok := p.pauses.list[key]; ok {
+			return ps, true
+		}
+	}
+	return Pause{}, false
+}
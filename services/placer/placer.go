@@ -4,15 +4,17 @@ import (
 	"context"
 	"fmt"
 	"github.com/RobinUS2/golang-moving-average"
+	"github.com/aibotsoft/crypto-surebet/pkg/capture"
+	"github.com/aibotsoft/crypto-surebet/pkg/clock"
 	"github.com/aibotsoft/crypto-surebet/pkg/config"
 	"github.com/aibotsoft/crypto-surebet/pkg/store"
-	"github.com/aibotsoft/ftx-api"
-	"github.com/jinzhu/copier"
+	"github.com/aibotsoft/crypto-surebet/pkg/venue"
 	"github.com/nats-io/nats.go"
 	"github.com/shopspring/decimal"
 	"go.uber.org/zap"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -27,10 +29,12 @@ type Placer struct {
 	cfg         *config.Config
 	log         *zap.Logger
 	ctx         context.Context
-	store       *store.Store
+	store       Storage
 	nc          *nats.Conn
 	ec          *nats.EncodedConn
-	client      *ftxapi.Client
+	sub         *nats.Subscription
+	venue       venue.Venue
+	tickerSink  venue.TickerSink
 	accountInfo store.Account
 
 	marketMap       map[string]*store.MarketEmb
@@ -38,93 +42,139 @@ type Placer struct {
 	balanceMap      map[string]*store.BalanceEmb
 	balanceLock     sync.Mutex
 	symbolMap       sync.Map
-	ws              *ftxapi.WebsocketService
 	checkBalanceCh  chan int64
-	placeConfig     PlaceConfig
-	saveSbCh        chan *store.Surebet
-	saveFillsCh     chan *store.Fills
+	placeConfig     atomic.Value
+	configLock      sync.Mutex
+	overrides       atomic.Value
+	marketFilter    atomic.Value
+	rejects         rejectCounter
+	funnel          *funnel
+	metrics         *metrics
+	pauses          pauses
+	kill            killSwitch
+	breakers        breakers
+	reconcile       reconciler
+	rebalance       rebalancer
+	writer          writer
 	openOrderCh     chan store.Order
-	deleteSbCh      chan int64
 	surebetMap      sync.Map
+	surebetLock     sync.Mutex
 	healMap         sync.Map
+	healLock        sync.Mutex
 	openOrderMap    sync.Map
 	lastFtxPriceMap sync.Map
 	//healOrderMap   sync.Map
-	saveHealCh chan *store.Heal
-	delay      *movingaverage.MovingAverage
-}
-type PlaceConfig struct {
-	MaxStake             decimal.Decimal
-	TargetProfit         decimal.Decimal
-	TargetAmount         decimal.Decimal
-	ReferralRate         decimal.Decimal
-	BinFtxVolumeRatio    decimal.Decimal
-	ProfitDiffRatio      decimal.Decimal
-	AvgPriceDiffRatio    decimal.Decimal
-	ProfitIncRatio       decimal.Decimal
-	MinVolume            decimal.Decimal
-	RehealThreshold      decimal.Decimal
-	SizeRatioMultiplayer decimal.Decimal
+	delay     *movingaverage.ConcurrentMovingAverage
+	clock     clock.Clock
+	wg        sync.WaitGroup
+	recorder  *capture.Recorder
+	stopped   int32
+	placing   int32 // Calc calls past the last stopping check, their bet not placed yet
+	serving   int32
+	stopServe chan chan struct{}
 }
 
-func NewPlacer(cfg *config.Config, log *zap.Logger, ctx context.Context, sto *store.Store) (*Placer, error) {
-	ftxConfig := ftxapi.Config{
-		ApiKey:     cfg.Ftx.Key,
-		ApiSecret:  cfg.Ftx.Secret,
-		Logger:     log.WithOptions(zap.IncreaseLevel(zap.InfoLevel)).Sugar(),
-		SubAccount: ftxapi.StringPointer(cfg.Ftx.SubAccount),
-	}
-	client := ftxapi.NewClient(ftxConfig)
-	ws := ftxapi.NewWebsocketService(cfg.Ftx.Key, cfg.Ftx.Secret, ftxapi.WebsocketEndpoint, log.Sugar()).AutoReconnect()
-	ws.SubAccount(cfg.Ftx.SubAccount)
-	return &Placer{
+func NewPlacer(cfg *config.Config, log *zap.Logger, ctx context.Context, sto Storage, v venue.Venue) (*Placer, error) {
+	tickerSink, _ := v.(venue.TickerSink)
+	p := &Placer{
 		cfg:        cfg,
 		log:        log,
 		ctx:        ctx,
 		store:      sto,
-		client:     client,
-		ws:         ws,
+		venue:      v,
+		tickerSink: tickerSink,
 		marketMap:  make(map[string]*store.MarketEmb),
 		balanceMap: make(map[string]*store.BalanceEmb),
 		//symbolMap:      make(map[string]chan int64),
 		checkBalanceCh: make(chan int64, 200),
-		saveSbCh:       make(chan *store.Surebet, 200),
-		saveHealCh:     make(chan *store.Heal, 200),
-		saveFillsCh:    make(chan *store.Fills, 200),
 		openOrderCh:    make(chan store.Order, 1000),
-		deleteSbCh:     make(chan int64, 200),
-		delay:          movingaverage.New(10000),
-		placeConfig: PlaceConfig{
-			MaxStake:             decimal.NewFromInt(cfg.Service.MaxStake),
-			TargetProfit:         decimal.NewFromFloat(cfg.Service.TargetProfit),
-			RehealThreshold:      decimal.NewFromFloat(cfg.Service.RehealThreshold),
-			BinFtxVolumeRatio:    decimal.NewFromInt(cfg.Service.BinFtxVolumeRatio),
-			TargetAmount:         decimal.NewFromInt(cfg.Service.TargetAmount),
-			ReferralRate:         decimal.NewFromFloat(cfg.Service.ReferralRate),
-			ProfitDiffRatio:      decimal.NewFromInt(cfg.Service.ProfitDiffRatio),
-			AvgPriceDiffRatio:    decimal.NewFromInt(cfg.Service.AvgPriceDiffRatio),
-			ProfitIncRatio:       decimal.NewFromInt(cfg.Service.ProfitIncRatio),
-			MinVolume:            decimal.NewFromInt(cfg.Service.MinVolume),
-			SizeRatioMultiplayer: decimal.NewFromInt(cfg.Service.SizeRatioMultiplayer),
-		},
-	}, nil
+		delay:          movingaverage.Concurrent(movingaverage.New(10000)),
+		clock:          clock.Real{},
+		funnel:         newFunnel(),
+		stopServe:      make(chan chan struct{}),
+		writer:         writer{kick: make(chan struct{}, 1)},
+	}
+	pc := newPlaceConfig(cfg)
+	err := pc.validate()
+	if err != nil {
+		return nil, err
+	}
+	p.metrics = newMetrics(p)
+	p.placeConfig.Store(pc)
+	p.overrides.Store(make(map[string]store.PlaceOverride))
+	return p, nil
 }
 
 func (p *Placer) Close() {
-	p.ws.Close()
+	p.venue.Close()
+}
+
+// SetClock replaces the wall clock, used by simulations to replay history.
+func (p *Placer) SetClock(c clock.Clock) {
+	p.clock = c
+}
+
+// SetRecorder enables capture of every surebet received from nats.
+func (p *Placer) SetRecorder(r *capture.Recorder) {
+	p.recorder = r
+}
+
+func (p *Placer) since(t time.Time) time.Duration {
+	return p.clock.Now().Sub(t)
+}
+
+// async runs f in its own goroutine, Wait blocks until all of them are done.
+func (p *Placer) async(f func()) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		f()
+	}()
+}
+
+func (p *Placer) Wait() {
+	p.wg.Wait()
+}
+
+// Drain handles everything queued for the Serve loop without blocking, flushes the
+// writer and reports whether there was anything to do.
+func (p *Placer) Drain() bool {
+	var done bool
+	for {
+		select {
+		case <-p.checkBalanceCh:
+			_ = p.GetBalances()
+		case order := <-p.openOrderCh:
+			p.processOpenOrder(&order)
+		default:
+			if p.Flush() > 0 {
+				done = true
+			}
+			return done
+		}
+		done = true
+	}
 }
 func (p *Placer) Run() error {
-	err := p.ws.Connect(p.handler, p.errHandler)
+	err := p.Start()
 	if err != nil {
-		return fmt.Errorf("ws_connect_error: %w", err)
+		return err
 	}
-	err = p.ws.Subscribe(ftxapi.Subscription{Channel: ftxapi.WsChannelOrders})
+	err = p.ConnectAndSubscribe()
 	if err != nil {
-		return fmt.Errorf("ws_subscribe_error: %w", err)
+		return err
+	}
+	return p.Serve()
+}
+
+// Start subscribes to venue events and loads account, balances, orders and markets.
+func (p *Placer) Start() error {
+	if p.cfg.Service.PaperMode {
+		p.log.Info("paper_mode_enabled")
 	}
-	err = p.ws.Subscribe(ftxapi.Subscription{Channel: ftxapi.WsChannelFills})
+	err := p.venue.Subscribe(p.processOrder, p.processFills, p.errHandler)
 	if err != nil {
-		return fmt.Errorf("ws_subscribe_error: %w", err)
+		return err
 	}
 	err = p.AccountInfo()
 	if err != nil {
@@ -143,51 +193,128 @@ func (p *Placer) Run() error {
 		p.log.Warn("get_orders_history_error", zap.Error(err))
 		//return err
 	}
-	err = p.GetMarkets()
+	err = p.loadKill()
 	if err != nil {
 		return err
 	}
-	err = p.ConnectAndSubscribe()
+	err = p.LoadOverrides()
 	if err != nil {
 		return err
 	}
+	err = p.LoadMarketFilter()
+	if err != nil {
+		return err
+	}
+	err = p.GetMarkets()
+	if err != nil {
+		return err
+	}
+	return p.Recover()
+}
+
+// Serve runs the writer and the maintenance loop until the context is done.
+func (p *Placer) Serve() error {
+	atomic.StoreInt32(&p.serving, 1)
+	defer atomic.StoreInt32(&p.serving, 0)
 	marketTick := time.Tick(time.Minute * 5)
 	orderTick := time.Tick(time.Minute * 10)
 	openOrderTick := time.Tick(p.cfg.Service.ReHealPeriod + time.Second)
+	var configTick <-chan time.Time
+	if p.cfg.Service.ConfigWatchPeriod > 0 {
+		configTick = time.Tick(p.cfg.Service.ConfigWatchPeriod)
+	}
+	configTime := configModTime()
 	var lastBalanceCheck time.Time
+	var lastRejects map[string]int64
+	var funnelTick <-chan time.Time
+	if p.cfg.Service.FunnelPersistPeriod > 0 {
+		funnelTick = time.Tick(p.cfg.Service.FunnelPersistPeriod)
+	}
+	var rebalanceTick <-chan time.Time
+	if p.cfg.Inventory.Rebalance && p.cfg.Inventory.RebalancePeriod > 0 {
+		rebalanceTick = time.Tick(p.cfg.Inventory.RebalancePeriod)
+	}
+	var breakerTick <-chan time.Time
+	if p.cfg.Breaker.CheckPeriod > 0 {
+		breakerTick = time.Tick(p.cfg.Breaker.CheckPeriod)
+	}
+	var ledgerTick <-chan time.Time
+	if p.cfg.Ledger.Period > 0 {
+		ledgerTick = time.Tick(p.cfg.Ledger.Period)
+	}
+	var equityTick <-chan time.Time
+	if p.cfg.Equity.SnapshotPeriod > 0 {
+		equityTick = time.Tick(p.cfg.Equity.SnapshotPeriod)
+	}
+	var reconcileTick <-chan time.Time
+	if p.cfg.Reconcile.Period > 0 {
+		reconcileTick = time.Tick(p.cfg.Reconcile.Period)
+	}
+	stopWriter := p.startWriter()
 	for {
 		select {
-		case sb := <-p.saveSbCh:
-			p.store.SaveSurebet(sb)
 		case <-p.checkBalanceCh:
 			if time.Since(lastBalanceCheck) > time.Millisecond*150 {
 				_ = p.GetBalances()
 				lastBalanceCheck = time.Now()
 			}
-		case h := <-p.saveHealCh:
-			p.store.SaveHeal(h)
-		case orderID := <-p.deleteSbCh:
-			p.store.DeleteSurebetByOrderID(orderID)
-			p.store.DeleteOrderByID(orderID)
-		case fills := <-p.saveFillsCh:
-			p.store.SaveFills(fills)
 		case <-openOrderTick:
 			_ = p.GetOpenOrders()
 		case <-marketTick:
 			_ = p.GetMarkets()
+			lastRejects = p.reportRejects(lastRejects)
+			err := p.LoadOverrides()
+			if err != nil {
+				p.log.Error("load_overrides_error", zap.Error(err))
+			}
+			err = p.LoadMarketFilter()
+			if err != nil {
+				p.log.Error("load_market_filter_error", zap.Error(err))
+			}
 			p.printLockStatus()
 		case order := <-p.openOrderCh:
 			p.processOpenOrder(&order)
 		case <-orderTick:
 			_ = p.GetOrdersHistory()
+		case <-funnelTick:
+			p.persistFunnel()
+		case <-breakerTick:
+			p.checkBreakers()
+		case <-rebalanceTick:
+			p.Rebalance()
+		case <-ledgerTick:
+			_, err := p.UpdateLedger()
+			if err != nil {
+				p.log.Error("update_ledger_error", zap.Error(err))
+			}
+		case <-equityTick:
+			err := p.SnapshotEquity()
+			if err != nil {
+				p.log.Error("snapshot_equity_error", zap.Error(err))
+			}
+		case <-reconcileTick:
+			_, err := p.Reconcile()
+			if err != nil {
+				p.log.Error("reconcile_error", zap.Error(err))
+			}
+		case <-configTick:
+			if t := configModTime(); t.After(configTime) {
+				configTime = t
+				_ = p.ReloadConfig("file")
+			}
+		case done := <-p.stopServe:
+			stopWriter()
+			close(done)
+			return nil
 		case <-p.ctx.Done():
+			stopWriter()
 			p.Close()
 			return p.ctx.Err()
 		}
 	}
 }
 
-func (p *Placer) printLockStatus() {
+func (p *Placer) activeLocks() []string {
 	var lockSym []string
 	p.symbolMap.Range(func(key, value interface{}) bool {
 		ch := value.(chan int64)
@@ -196,20 +323,17 @@ func (p *Placer) printLockStatus() {
 		}
 		return true
 	})
+	return lockSym
+}
+
+func (p *Placer) printLockStatus() {
+	lockSym := p.activeLocks()
 	if len(lockSym) > 0 {
 		p.log.Info("active_locks", zap.Any("list", lockSym))
 	}
 }
-func (p *Placer) handler(res ftxapi.WsReponse) {
-	if res.Orders != nil {
-		p.processOrder(res.Orders)
-	} else if res.Fills != nil {
-		p.processFills(res.Fills)
-	}
-}
-
 func (p *Placer) errHandler(err error) {
-	p.log.Error("ftx_websocket_error", zap.Error(err))
+	p.log.Error("venue_stream_error", zap.Error(err))
 }
 
 func (p *Placer) ConnectAndSubscribe() error {
@@ -224,38 +348,39 @@ func (p *Placer) ConnectAndSubscribe() error {
 		return fmt.Errorf("encoded_connection_error: %w", err)
 	}
 	p.ec = ec
-	_, err = ec.Subscribe(cryptoSubject, p.SurebetHandler)
+	p.sub, err = ec.Subscribe(cryptoSubject, p.SurebetHandler)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 func (p *Placer) SurebetHandler(sb *store.Surebet) {
-	go func() {
+	if p.recorder != nil {
+		p.recorder.Record(p.clock.Now().UnixNano(), sb)
+	}
+	if p.tickerSink != nil && sb.FtxTicker != nil {
+		p.tickerSink.SetTicker(*sb.FtxTicker)
+	}
+	p.async(func() {
 		lock := p.Calc(sb)
 		if lock != nil {
 			<-lock
 			//p.log.Debug("unlock", zap.Int64("id", id))
 		}
-	}()
+	})
 }
 
 func (p *Placer) AccountInfo() error {
 	start := time.Now()
-	resp, err := p.client.NewGetAccountService().Do(p.ctx)
-	if err != nil {
-		return err
-	}
-	var data store.Account
-	err = copier.Copy(&data, resp)
+	data, err := p.venue.GetAccount(p.ctx)
 	if err != nil {
 		return err
 	}
-	err = p.store.SaveAccount(&data)
+	err = p.store.SaveAccount(data)
 	if err != nil {
 		return err
 	}
-	p.accountInfo = data
+	p.accountInfo = *data
 	p.log.Debug("account_info_done", zap.Duration("elapsed", time.Since(start)), zap.Int("goroutine", runtime.NumGoroutine()))
 	return nil
 }
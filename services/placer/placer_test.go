@@ -0,0 +1,1347 @@
+package placer
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aibotsoft/crypto-surebet/pkg/config"
+	"github.com/aibotsoft/crypto-surebet/pkg/store"
+	"github.com/aibotsoft/crypto-surebet/pkg/venue"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+)
+
+const simMarket = "BTC/USD"
+
+func testConfig() *config.Config {
+	var cfg config.Config
+	cfg.Service.TargetProfit = 0.1
+	cfg.Service.TargetAmount = 10
+	cfg.Service.BinFtxVolumeRatio = 2
+	cfg.Service.ProfitDiffRatio = 2
+	cfg.Service.AvgPriceDiffRatio = 10
+	cfg.Service.ProfitIncRatio = 1
+	cfg.Service.MaxStake = 100
+	cfg.Service.MinVolume = 10
+	cfg.Service.RehealThreshold = 0.1
+	cfg.Service.SizeRatioMultiplayer = 1
+	cfg.Service.SendReceiveMaxDelay = time.Second
+	cfg.Service.MaxLockTime = 100 * time.Millisecond
+	cfg.Service.ReHealPeriod = 200 * time.Millisecond
+	cfg.Service.BetCancelPeriod = 50 * time.Millisecond
+	return &cfg
+}
+
+func ticker(symbol string, bid, ask float64) *store.TickerData {
+	return &store.TickerData{
+		Symbol:   symbol,
+		BidPrice: decimal.NewFromFloat(bid),
+		BidQty:   decimal.NewFromInt(1),
+		AskPrice: decimal.NewFromFloat(ask),
+		AskQty:   decimal.NewFromInt(1),
+	}
+}
+
+func newSimPlacer(t *testing.T) (*Placer, *venue.Sim, *store.Memory) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	sim := venue.NewSim(zap.NewNop())
+	sim.SetFees(decimal.NewFromFloat(0.0002), decimal.NewFromFloat(0.0007))
+	sim.AddMarket(venue.SimMarket(simMarket, 0.0001, 1))
+	sim.SetBalance("USD", decimal.NewFromInt(10000))
+	sim.SetBalance("BTC", decimal.NewFromInt(1))
+	sim.SetTicker(*ticker(simMarket, 19990, 20000))
+	mem := store.NewMemory()
+	p, err := NewPlacer(testConfig(), zap.NewNop(), ctx, mem, sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = p.Serve()
+	}()
+	return p, sim, mem
+}
+
+func eventually(t *testing.T, msg string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timeout waiting for %s", msg)
+}
+
+func placeBuySurebet(p *Placer) *store.Surebet {
+	sb := &store.Surebet{
+		ID:        time.Now().UnixNano(),
+		FtxTicker: ticker(simMarket, 19990, 20000),
+		BinTicker: ticker(simMarket, 20100, 20110),
+		UsdtPrice: decimal.NewFromInt(1),
+	}
+	p.SurebetHandler(sb)
+	return sb
+}
+
+// decisionFor waits for the Calc decision on the surebet id.
+func decisionFor(t *testing.T, p *Placer, id int64) Decision {
+	t.Helper()
+	var d Decision
+	eventually(t, "decision", func() bool {
+		for _, got := range p.Decisions(recentDecisions) {
+			if got.ID == id {
+				d = got
+				return true
+			}
+		}
+		return false
+	})
+	return d
+}
+
+func healByID(mem *store.Memory, id int64) *store.Heal {
+	for _, h := range mem.Heals() {
+		if h.ID == id {
+			return &h
+		}
+	}
+	return nil
+}
+
+func TestSimBetFillHeal(t *testing.T) {
+	p, sim, mem := newSimPlacer(t)
+	sb := placeBuySurebet(p)
+
+	eventually(t, "heal order", func() bool {
+		h := healByID(mem, sb.ID)
+		return h != nil && len(h.Orders) == 1
+	})
+	h := healByID(mem, sb.ID)
+	if h.PlaceParams.Side != store.SideSell {
+		t.Fatalf("heal side %s, want sell", h.PlaceParams.Side)
+	}
+	if !h.AvgFillPrice.Equal(decimal.NewFromInt(20000)) {
+		t.Fatalf("bet fill price %v, want 20000", h.AvgFillPrice)
+	}
+	if !h.PlaceParams.Price.GreaterThan(h.AvgFillPrice) {
+		t.Fatalf("heal price %v not above bet price %v", h.PlaceParams.Price, h.AvgFillPrice)
+	}
+	if len(mem.Surebets()) != 1 {
+		t.Fatalf("surebets saved %d, want 1", len(mem.Surebets()))
+	}
+
+	sim.SetTicker(*ticker(simMarket, 20050, 20060))
+	eventually(t, "heal filled", func() bool {
+		_, ok := p.healMap.Load(sb.ID)
+		return !ok
+	})
+	eventually(t, "fills saved", func() bool {
+		return len(mem.Fills()) == 2
+	})
+}
+
+func TestSimReHeal(t *testing.T) {
+	p, sim, mem := newSimPlacer(t)
+	sb := placeBuySurebet(p)
+	eventually(t, "heal order", func() bool {
+		h := healByID(mem, sb.ID)
+		return h != nil && len(h.Orders) == 1
+	})
+	first := healByID(mem, sb.ID).PlaceParams.Price
+
+	// price moved away, stale heal order must be replaced by a cheaper one
+	p.lastFtxPriceMap.Store(simMarket, decimal.NewFromInt(19000))
+	time.Sleep(p.cfg.Service.ReHealPeriod)
+	err := p.GetOpenOrders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "re heal order", func() bool {
+		h := healByID(mem, sb.ID)
+		return h != nil && len(h.Orders) == 2
+	})
+	second := healByID(mem, sb.ID).PlaceParams.Price
+	if !second.LessThan(first) {
+		t.Fatalf("re heal price %v not below %v", second, first)
+	}
+
+	sim.SetTicker(*ticker(simMarket, second.InexactFloat64(), second.InexactFloat64()+10))
+	eventually(t, "re heal filled", func() bool {
+		_, ok := p.healMap.Load(sb.ID)
+		return !ok
+	})
+}
+
+func TestPaperMode(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	source := venue.NewSim(zap.NewNop())
+	source.SetFees(decimal.NewFromFloat(0.0002), decimal.NewFromFloat(0.0007))
+	source.AddMarket(venue.SimMarket(simMarket, 0.0001, 1))
+	source.SetBalance("USD", decimal.NewFromInt(10000))
+	source.SetBalance("BTC", decimal.NewFromInt(1))
+	cfg := testConfig()
+	cfg.Service.PaperMode = true
+	mem := store.NewMemory()
+	p, err := NewPlacer(cfg, zap.NewNop(), ctx, mem, venue.NewPaper(zap.NewNop(), source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = p.Serve()
+	}()
+	sb := placeBuySurebet(p)
+	eventually(t, "paper heal order", func() bool {
+		h := healByID(mem, sb.ID)
+		return h != nil && len(h.Orders) == 1
+	})
+	h := healByID(mem, sb.ID)
+	if !h.Paper || !h.Orders[0].Paper || !mem.Surebets()[0].Paper {
+		t.Fatal("paper flag not set")
+	}
+	orders, _ := source.GetOrderHistory(ctx)
+	if len(orders) != 0 {
+		t.Fatalf("paper orders reached source venue: %d", len(orders))
+	}
+	if !p.FindBalance("USD").Free.LessThan(decimal.NewFromInt(10000)) {
+		t.Fatal("virtual quote balance not spent")
+	}
+}
+
+func TestSetPlaceConfig(t *testing.T) {
+	p, _, mem := newSimPlacer(t)
+	cfg := testConfig()
+	cfg.Service.TargetProfit = 5
+	cfg.Service.MaxStake = 200
+	changes, err := p.SetPlaceConfig(newPlaceConfig(cfg), "test")
+	if err != nil || len(changes) != 2 {
+		t.Fatalf("changes %d %v, want 2", len(changes), err)
+	}
+	saved := mem.ConfigChanges()
+	if len(saved) != 2 || saved[0].Name != "max_stake" || !saved[0].OldValue.Equal(decimal.NewFromInt(100)) || !saved[0].NewValue.Equal(decimal.NewFromInt(200)) {
+		t.Fatalf("saved changes %+v", saved)
+	}
+	if changes, _ := p.SetPlaceConfig(newPlaceConfig(cfg), "test"); len(changes) != 0 {
+		t.Fatalf("same config changes %d", len(changes))
+	}
+	cfg.Service.ProfitDiffRatio = 0
+	if _, err := p.SetPlaceConfig(newPlaceConfig(cfg), "test"); err == nil || p.loadPlaceConfig().ProfitDiffRatio.IsZero() {
+		t.Fatalf("zero profit_diff_ratio applied, err %v", err)
+	}
+
+	//target profit is above the surebet profit now
+	sb := placeBuySurebet(p)
+	if d := decisionFor(t, p, sb.ID); d.Reason != string(rejectProfitLow) {
+		t.Fatalf("decision %+v, want %s", d, rejectProfitLow)
+	}
+}
+
+func TestPlaceOverride(t *testing.T) {
+	p, _, mem := newSimPlacer(t)
+	mem.SavePlaceOverride(store.PlaceOverride{Key: "BTC", TargetProfit: decimal.NewNullDecimal(decimal.NewFromInt(5))})
+	err := p.LoadOverrides()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sb := placeBuySurebet(p)
+	if d := decisionFor(t, p, sb.ID); d.Reason != string(rejectProfitLow) {
+		t.Fatalf("decision %+v, want %s with base currency override", d, rejectProfitLow)
+	}
+
+	mem.SavePlaceOverride(store.PlaceOverride{Key: "ETH", TargetAmount: decimal.NewNullDecimal(decimal.Zero)})
+	mem.SavePlaceOverride(store.PlaceOverride{Key: simMarket, TargetProfit: decimal.NewNullDecimal(decimal.NewFromFloat(0.2)), MaxStake: decimal.NewNullDecimal(decimal.NewFromInt(50))})
+	err = p.LoadOverrides()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.overrides.Load().(map[string]store.PlaceOverride)["ETH"]; ok {
+		t.Fatal("override with zero target_amount loaded")
+	}
+	sb = placeBuySurebet(p)
+	eventually(t, "surebet saved", func() bool {
+		return len(mem.Surebets()) == 1
+	})
+	saved := mem.Surebets()[0]
+	if saved.Override != "BTC,"+simMarket || !saved.TargetProfit.Equal(decimal.NewFromFloat(0.2)) || !saved.MaxStake.Equal(decimal.NewFromInt(50)) {
+		t.Fatalf("override %s target_profit %v max_stake %v", saved.Override, saved.TargetProfit, saved.MaxStake)
+	}
+	if !saved.MinVolume.Equal(decimal.NewFromInt(10)) {
+		t.Fatalf("min_volume %v, want config value", saved.MinVolume)
+	}
+}
+
+func TestMarketFilter(t *testing.T) {
+	p, _, mem := newSimPlacer(t)
+	mem.SaveMarketRule(store.MarketRule{Kind: ruleAllow, Field: fieldType, Value: "spot"})
+	mem.SaveMarketRule(store.MarketRule{Kind: ruleDeny, Field: fieldBase, Value: "BTC"})
+	now := time.Now()
+	err := p.LoadMarketFilter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := p.FindMarket(simMarket)
+	if reason := p.checkMarket(simMarket, m); reason != rejectBaseDenied {
+		t.Fatalf("reason %q, want %q", reason, rejectBaseDenied)
+	}
+	if reason := p.checkMarket("ETH/USD", &store.MarketEmb{BaseCurrency: "ETH", Type: "future", Enabled: true}); reason != rejectTypeNotAllowed {
+		t.Fatalf("reason %q, want %q", reason, rejectTypeNotAllowed)
+	}
+	if reason := p.checkMarket("ETH/USD", &store.MarketEmb{BaseCurrency: "ETH", Type: "spot", Enabled: true}); reason != "" {
+		t.Fatalf("reason %q, want none", reason)
+	}
+
+	p, _, mem = newSimPlacer(t)
+	mem.SaveTradingWindow(store.TradingWindow{Key: "BTC", Start: now.Add(-time.Minute), End: now.Add(time.Minute)})
+	err = p.LoadMarketFilter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason := p.checkMarket(simMarket, m); reason != rejectTradingWindow {
+		t.Fatalf("reason %q, want %q", reason, rejectTradingWindow)
+	}
+	sb := placeBuySurebet(p)
+	if d := decisionFor(t, p, sb.ID); d.Reason != string(rejectTradingWindow) {
+		t.Fatalf("decision %+v, want %s", d, rejectTradingWindow)
+	}
+	if got := p.RejectStats()[string(rejectTradingWindow)]; got != 1 {
+		t.Fatalf("trading_window rejects %d, want 1", got)
+	}
+}
+
+func TestRejectUnknownMarket(t *testing.T) {
+	p, sim, _ := newSimPlacer(t)
+	sb := &store.Surebet{
+		ID:        time.Now().UnixNano(),
+		FtxTicker: ticker("ETH/USD", 1000, 1001),
+		BinTicker: ticker("ETH/USD", 1010, 1011),
+		UsdtPrice: decimal.NewFromInt(1),
+	}
+	if lock := p.Calc(sb); lock != nil {
+		t.Fatal("unknown market got a lock")
+	}
+	m := venue.SimMarket("ETH/USD", 0.001, 0.1)
+	m.Enabled = false
+	sim.AddMarket(m)
+	err := p.GetMarkets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sb.ID = time.Now().UnixNano()
+	if lock := p.Calc(sb); lock != nil {
+		t.Fatal("disabled market got a lock")
+	}
+	stats := p.RejectStats()
+	if stats[string(rejectUnknownMarket)] != 1 || stats[string(rejectMarketDisabled)] != 1 {
+		t.Fatalf("reject stats %v", stats)
+	}
+}
+
+func TestFunnel(t *testing.T) {
+	p, sim, mem := newSimPlacer(t)
+	sb := placeBuySurebet(p)
+	eventually(t, "heal order", func() bool {
+		h := healByID(mem, sb.ID)
+		return h != nil && len(h.Orders) == 1
+	})
+	sim.SetTicker(*ticker(simMarket, 20050, 20060))
+	eventually(t, "heal filled", func() bool {
+		_, ok := p.healMap.Load(sb.ID)
+		return !ok
+	})
+	p.SurebetHandler(&store.Surebet{
+		ID:        time.Now().UnixNano(),
+		FtxTicker: ticker(simMarket, 20050, 20060),
+		BinTicker: ticker(simMarket, 20050, 20060),
+		UsdtPrice: decimal.NewFromInt(1),
+	})
+	eventually(t, "second decision", func() bool {
+		return len(p.Decisions(10)) == 2
+	})
+	rows := p.Funnel(time.Hour)
+	if len(rows) != 1 {
+		t.Fatalf("funnel rows %d", len(rows))
+	}
+	r := rows[0]
+	if r.Received != 2 || r.Locked != 2 || r.Profitable != 1 || r.Placed != 1 || r.Filled != 1 || r.Healed != 1 {
+		t.Fatalf("funnel %+v", r)
+	}
+	if r.Rejects[string(rejectProfitLow)] != 1 {
+		t.Fatalf("rejects %v", r.Rejects)
+	}
+	if d := p.Decisions(1)[0]; d.Reason != string(rejectProfitLow) || d.Stage != StageLocked {
+		t.Fatalf("last decision %+v", d)
+	}
+
+	p.funnel = newFunnel()
+	p.funnel.stage(time.Now().Add(-2*funnelBucket), simMarket, StageFilled)
+	p.funnel.stage(time.Now(), simMarket, StageFilled)
+	p.persistFunnel()
+	stats := mem.FunnelStats()
+	if len(stats) != 1 || stats[0].Filled != 1 || stats[0].Received != 0 {
+		t.Fatalf("persisted %+v", stats)
+	}
+	p.persistFunnel()
+	if len(mem.FunnelStats()) != 1 {
+		t.Fatal("persisted twice")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	p, _, mem := newSimPlacer(t)
+	sb := placeBuySurebet(p)
+	eventually(t, "heal order", func() bool {
+		h := healByID(mem, sb.ID)
+		return h != nil && len(h.Orders) == 1
+	})
+	rec := httptest.NewRecorder()
+	p.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		"placer_surebets_received_total 1",
+		`placer_surebets_placed_total{market="BTC/USD"} 1`,
+		`placer_heal_attempts_total{kind="first"} 1`,
+		`placer_order_place_seconds_count{kind="bet"} 1`,
+		`placer_writer_queue_depth{table="surebets"}`,
+		"placer_active_locks",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("metrics miss %q", want)
+		}
+	}
+}
+
+func TestAdminPause(t *testing.T) {
+	p, sim, mem := newSimPlacer(t)
+	p.cfg.Http.Admin = true
+	h := p.Handler()
+	call := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+	if rec := call(http.MethodGet, "/admin/pause?market=BTC/USD"); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("get pause code %d", rec.Code)
+	}
+	if rec := call(http.MethodPost, "/admin/pause?market=BTC/USD&duration=1h&reason=test"); rec.Code != http.StatusOK {
+		t.Fatalf("pause code %d: %s", rec.Code, rec.Body)
+	}
+	sb := &store.Surebet{
+		ID:        time.Now().UnixNano(),
+		FtxTicker: ticker(simMarket, 19990, 20000),
+		BinTicker: ticker(simMarket, 20100, 20110),
+		UsdtPrice: decimal.NewFromInt(1),
+	}
+	if lock := p.Calc(sb); lock != nil {
+		t.Fatal("paused market got a lock")
+	}
+	if stats := p.RejectStats(); stats[string(rejectPaused)] != 1 {
+		t.Fatalf("reject stats %v", stats)
+	}
+	var list []Pause
+	rec := call(http.MethodGet, "/admin/pauses")
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || len(list) != 1 || list[0].Reason != "test" {
+		t.Fatalf("pauses %s", rec.Body)
+	}
+	if rec := call(http.MethodPost, "/admin/resume?market=BTC/USD"); !strings.Contains(rec.Body.String(), `"resumed":true`) {
+		t.Fatalf("resume %s", rec.Body)
+	}
+	sb = placeBuySurebet(p)
+	eventually(t, "heal order", func() bool {
+		h := healByID(mem, sb.ID)
+		return h != nil && len(h.Orders) == 1
+	})
+	var heals []*store.Heal
+	rec = call(http.MethodGet, "/admin/heals")
+	if err := json.Unmarshal(rec.Body.Bytes(), &heals); err != nil || len(heals) != 1 {
+		t.Fatalf("heals %s", rec.Body)
+	}
+	if rec := call(http.MethodPost, "/admin/heals/retry?id=1"); rec.Code != http.StatusNotFound {
+		t.Fatalf("retry unknown heal code %d", rec.Code)
+	}
+	if rec := call(http.MethodPost, "/admin/heals/retry?id=x"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("retry bad id code %d", rec.Code)
+	}
+	rec = call(http.MethodGet, "/admin/config?market=BTC/USD")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"effective"`) {
+		t.Fatalf("config %s", rec.Body)
+	}
+	// the refreshed balances are returned, not the ones before
+	sim.SetBalance("BTC", decimal.NewFromInt(2))
+	var balances map[string]store.BalanceEmb
+	rec = call(http.MethodPost, "/admin/refresh/balances")
+	if err := json.Unmarshal(rec.Body.Bytes(), &balances); err != nil || !balances["BTC"].Total.Equal(decimal.NewFromInt(2)) {
+		t.Fatalf("refreshed balances %s", rec.Body)
+	}
+}
+
+func TestKillSwitch(t *testing.T) {
+	p, sim, mem := newSimPlacer(t)
+	sb := placeBuySurebet(p)
+	eventually(t, "heal order", func() bool {
+		h := healByID(mem, sb.ID)
+		return h != nil && len(h.Orders) == 1
+	})
+	openOrders := func() int {
+		orders, _ := sim.GetOpenOrders(context.Background())
+		return len(orders)
+	}
+	e, err := p.Kill(KillSourceAdmin, "test", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Canceled != 0 || openOrders() != 1 {
+		t.Fatalf("keep heals canceled %d, open %d", e.Canceled, openOrders())
+	}
+	sb2 := &store.Surebet{
+		ID:        time.Now().UnixNano(),
+		FtxTicker: ticker(simMarket, 19990, 20000),
+		BinTicker: ticker(simMarket, 20100, 20110),
+		UsdtPrice: decimal.NewFromInt(1),
+	}
+	if lock := p.Calc(sb2); lock != nil {
+		t.Fatal("killed placer got a lock")
+	}
+	_, err = p.Rearm("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.Rearm("test"); err != ErrKillSwitchNotActive {
+		t.Fatalf("second rearm error %v", err)
+	}
+
+	_, err = p.Kill(KillSourceAdmin, "test", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Wait()
+	if openOrders() != 0 {
+		t.Fatalf("open orders after kill %d", openOrders())
+	}
+	_, err = p.Rearm("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Wait()
+	if openOrders() != 1 {
+		t.Fatalf("held heal not placed on rearm, open %d", openOrders())
+	}
+	events := mem.KillEvents()
+	if len(events) != 2 || events[0].ArmedAt == nil || events[1].Canceled != 1 || *events[1].ArmedBy != "test" {
+		t.Fatalf("kill events %+v", events)
+	}
+}
+
+// hookVenue runs onOpenOrders once when open orders are asked for and onPlaceOrder
+// once before an order is placed.
+type hookVenue struct {
+	*venue.Sim
+	onOpenOrders func()
+	onPlaceOrder func()
+}
+
+func (v *hookVenue) PlaceOrder(ctx context.Context, param store.PlaceParamsEmb) (*store.Order, error) {
+	if f := v.onPlaceOrder; f != nil {
+		v.onPlaceOrder = nil
+		f()
+	}
+	return v.Sim.PlaceOrder(ctx, param)
+}
+
+func (v *hookVenue) GetOpenOrders(ctx context.Context) ([]store.Order, error) {
+	if f := v.onOpenOrders; f != nil {
+		v.onOpenOrders = nil
+		f()
+	}
+	return v.Sim.GetOpenOrders(ctx)
+}
+
+func TestRearmDuringKill(t *testing.T) {
+	mem := store.NewMemory()
+	v := &hookVenue{Sim: venue.NewSim(zap.NewNop())}
+	p, err := NewPlacer(testConfig(), zap.NewNop(), context.Background(), mem, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.onOpenOrders = func() {
+		if _, err := p.Rearm("test"); err != nil {
+			t.Errorf("rearm during cancel %v", err)
+		}
+	}
+	e, err := p.Kill(KillSourceAdmin, "test", false)
+	if err != nil || e.ID == 0 {
+		t.Fatalf("kill %+v %v", e, err)
+	}
+	if p.killed() {
+		t.Fatal("kill switch tripped again after rearm")
+	}
+	events := mem.KillEvents()
+	if len(events) != 1 || events[0].ArmedAt == nil {
+		t.Fatalf("kill events %+v", events)
+	}
+}
+
+func TestBreakers(t *testing.T) {
+	p, _, _ := newSimPlacer(t)
+	p.cfg.Breaker.ErrorWindow = time.Minute
+	p.cfg.Breaker.ErrorLimit = 2
+	p.cfg.Breaker.RateLimitLimit = 3
+	p.cfg.Breaker.PnlWindow = time.Hour
+	p.cfg.Breaker.MarketMaxDrawdown = 5
+	p.cfg.Breaker.CoolDown = time.Minute
+	p.cfg.Breaker.MaxCoolDown = 3 * time.Minute
+	p.cfg.Breaker.TripReset = time.Hour
+
+	p.placeFailed(venue.ErrRateLimit)
+	p.placeFailed(errors.New("bet_error"))
+	p.checkBreakers()
+	if len(p.Pauses()) != 0 {
+		t.Fatalf("tripped under limit %v", p.Pauses())
+	}
+	for i, want := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute} {
+		p.placeFailed(errors.New("bet_error"))
+		p.placeFailed(errors.New("heal_error"))
+		p.checkBreakers()
+		ps, ok := p.pauseOf(pauseAll)
+		if !ok || ps.Reason != "breaker_"+breakerErrors {
+			t.Fatalf("trip %d pauses %v", i, p.Pauses())
+		}
+		if d := ps.Until.Sub(ps.Since); d != want {
+			t.Fatalf("trip %d cool down %v, want %v", i, d, want)
+		}
+		p.Resume(pauseAll)
+	}
+	// no max keeps doubling instead of pausing for good
+	p.cfg.Breaker.MaxCoolDown = 0
+	p.placeFailed(errors.New("bet_error"))
+	p.placeFailed(errors.New("heal_error"))
+	p.checkBreakers()
+	if ps, ok := p.pauseOf(pauseAll); !ok || ps.Until.Sub(ps.Since) != 8*time.Minute {
+		t.Fatalf("uncapped pauses %v", p.Pauses())
+	}
+	p.Resume(pauseAll)
+
+	// bought 0.01 at 20000 and sold back at 19000
+	p.healDone(&store.Heal{
+		FilledSize:   decimal.NewFromFloat(0.01),
+		AvgFillPrice: decimal.NewFromInt(20000),
+		FeePart:      decimal.NewFromFloat(0.14),
+		PlaceParams:  store.PlaceParamsEmb{Market: simMarket, Side: store.SideSell},
+		Orders:       []*store.Order{{FilledSize: 0.01, AvgFillPrice: 19000}},
+	})
+	p.checkBreakers()
+	ps, ok := p.pauseOf(simMarket)
+	if !ok || ps.Reason != "breaker_"+breakerMarketDrawdown {
+		t.Fatalf("drawdown pauses %v", p.Pauses())
+	}
+	if _, ok := p.pauseOf(pauseAll); ok {
+		t.Fatal("market drawdown paused all markets")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	p, sim, mem := newSimPlacer(t)
+	p.cfg.Shutdown.CancelBets = true
+	sb := placeBuySurebet(p)
+	p.Wait()
+	p.Shutdown(3 * time.Second)
+	if n := len(p.InflightSurebets()); n != 0 {
+		t.Fatalf("in-flight surebets after shutdown %d", n)
+	}
+	saved := false
+	for _, s := range mem.Surebets() {
+		saved = saved || s.ID == sb.ID
+	}
+	if !saved {
+		t.Fatal("surebet not saved on shutdown")
+	}
+	if h := healByID(mem, sb.ID); h == nil || len(h.Orders) != 1 {
+		t.Fatalf("heal not saved on shutdown %+v", h)
+	}
+	orders, _ := sim.GetOpenOrders(context.Background())
+	if len(orders) != 1 {
+		t.Fatalf("heal order not left open, open %d", len(orders))
+	}
+	sb2 := &store.Surebet{
+		ID:        time.Now().UnixNano(),
+		FtxTicker: ticker(simMarket, 19990, 20000),
+		BinTicker: ticker(simMarket, 20100, 20110),
+		UsdtPrice: decimal.NewFromInt(1),
+	}
+	if lock := p.Calc(sb2); lock != nil {
+		t.Fatal("stopped placer got a lock")
+	}
+}
+
+func TestShutdownWhilePlacing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	sim := venue.NewSim(zap.NewNop())
+	sim.AddMarket(venue.SimMarket(simMarket, 0.0001, 1))
+	sim.SetBalance("USD", decimal.NewFromInt(10000))
+	sim.SetBalance("BTC", decimal.NewFromInt(1))
+	sim.SetTicker(*ticker(simMarket, 19990, 20000))
+	placing, release := make(chan struct{}), make(chan struct{})
+	v := &hookVenue{Sim: sim, onPlaceOrder: func() {
+		close(placing)
+		<-release
+	}}
+	p, err := NewPlacer(testConfig(), zap.NewNop(), ctx, store.NewMemory(), v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = p.Serve()
+	}()
+	p.cfg.Shutdown.CancelBets = true
+	sb := placeBuySurebet(p)
+	<-placing
+	done := make(chan struct{})
+	go func() {
+		p.Shutdown(3 * time.Second)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("shutdown did not wait for the bet being placed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-done
+	if n := len(p.InflightSurebets()); n != 0 {
+		t.Fatalf("in-flight surebets after shutdown %d", n)
+	}
+	if d := decisionFor(t, p, sb.ID); d.Stage != StagePlaced {
+		t.Fatalf("decision %+v", d)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	sim := venue.NewSim(zap.NewNop())
+	sim.SetFees(decimal.NewFromFloat(0.0002), decimal.NewFromFloat(0.0007))
+	sim.AddMarket(venue.SimMarket(simMarket, 0.0001, 1))
+	sim.SetBalance("USD", decimal.NewFromInt(10000))
+	sim.SetBalance("BTC", decimal.NewFromInt(1))
+	sim.SetTicker(*ticker(simMarket, 19990, 20000))
+	mem := store.NewMemory()
+
+	// the bet filled before the crash, its heal was never placed
+	now := time.Now()
+	sb := &store.Surebet{
+		ID:           now.Add(-time.Minute).UnixNano(),
+		TargetProfit: decimal.NewFromFloat(0.1),
+		RealFee:      decimal.NewFromFloat(0.07),
+		Market: &store.MarketEmb{
+			BaseCurrency:   "BTC",
+			QuoteCurrency:  "USD",
+			MinProvideSize: decimal.NewFromFloat(0.0001),
+			PriceIncrement: decimal.NewFromInt(1),
+		},
+		PlaceParams: store.PlaceParamsEmb{
+			Market:   simMarket,
+			Side:     store.SideBuy,
+			Price:    decimal.NewFromInt(20000),
+			Type:     store.OrderTypeLimit,
+			Size:     decimal.NewFromFloat(0.001),
+			Ioc:      true,
+			ClientID: marshalClientID(ClientID{ID: now.Add(-time.Minute).UnixNano(), Side: BET}),
+		},
+	}
+	bet, err := sim.PlaceOrder(ctx, sb.PlaceParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sb.OrderID = bet.ID
+	mem.SaveSurebet(sb)
+	// the heal got no order before the crash
+	lost := &store.Heal{
+		ID:             now.Add(-2 * time.Minute).UnixNano(),
+		Done:           now.Add(-2 * time.Minute).UnixNano(),
+		FilledSize:     decimal.NewFromFloat(0.001),
+		AvgFillPrice:   decimal.NewFromInt(20000),
+		MinSize:        decimal.NewFromFloat(0.0001),
+		PriceIncrement: decimal.NewFromInt(1),
+		PlaceParams: store.PlaceParamsEmb{
+			Market:   simMarket,
+			Side:     store.SideSell,
+			Price:    decimal.NewFromInt(20050),
+			Type:     store.OrderTypeLimit,
+			Size:     decimal.NewFromFloat(0.001),
+			PostOnly: true,
+			ClientID: marshalClientID(ClientID{ID: now.Add(-2 * time.Minute).UnixNano(), Side: HEAL}),
+		},
+	}
+	mem.SaveHeal(lost)
+
+	cfg := testConfig()
+	cfg.Recovery.Lookback = time.Hour
+	p, err := NewPlacer(cfg, zap.NewNop(), ctx, mem, sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = p.Serve()
+	}()
+	for _, id := range []int64{sb.ID, lost.ID} {
+		id := id
+		eventually(t, "recovered heal order", func() bool {
+			h := healByID(mem, id)
+			return h != nil && len(h.Orders) == 1
+		})
+	}
+	p.Wait()
+	if n := len(p.PendingHeals()); n != 2 {
+		t.Fatalf("pending heals %d", n)
+	}
+	orders, _ := sim.GetOpenOrders(ctx)
+	if len(orders) != 2 {
+		t.Fatalf("open heal orders %d", len(orders))
+	}
+}
+
+func TestRecoverMissedClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	sim := venue.NewSim(zap.NewNop())
+	sim.AddMarket(venue.SimMarket(simMarket, 0.0001, 1))
+	sim.SetBalance("USD", decimal.NewFromInt(10000))
+	sim.SetBalance("BTC", decimal.NewFromInt(1))
+	sim.SetTicker(*ticker(simMarket, 19990, 20000))
+	mem := store.NewMemory()
+	now := time.Now()
+	sb := &store.Surebet{
+		ID:           now.Add(-time.Minute).UnixNano(),
+		TargetProfit: decimal.NewFromFloat(0.1),
+		RealFee:      decimal.NewFromFloat(0.07),
+		Market: &store.MarketEmb{
+			BaseCurrency:   "BTC",
+			QuoteCurrency:  "USD",
+			MinProvideSize: decimal.NewFromFloat(0.0001),
+			PriceIncrement: decimal.NewFromInt(1),
+		},
+		PlaceParams: store.PlaceParamsEmb{
+			Market:   simMarket,
+			Side:     store.SideBuy,
+			Price:    decimal.NewFromInt(19000),
+			Type:     store.OrderTypeLimit,
+			Size:     decimal.NewFromFloat(0.001),
+			ClientID: marshalClientID(ClientID{ID: now.Add(-time.Minute).UnixNano(), Side: BET}),
+		},
+	}
+	bet, err := sim.PlaceOrder(ctx, sb.PlaceParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sb.OrderID = bet.ID
+	mem.SaveSurebet(sb)
+
+	cfg := testConfig()
+	cfg.Recovery.Lookback = time.Hour
+	// the bet fills after recovery read it open, before it is in surebetMap, and
+	// the close event is lost
+	v := &hookVenue{Sim: sim, onOpenOrders: func() { sim.SetTicker(*ticker(simMarket, 18980, 18990)) }}
+	p, err := NewPlacer(cfg, zap.NewNop(), ctx, mem, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	p.Wait()
+	p.Flush()
+	if _, ok := p.surebetMap.Load(sb.ID); ok {
+		t.Fatal("recovered bet left in surebetMap")
+	}
+	if h := healByID(mem, sb.ID); h == nil || len(h.Orders) != 1 {
+		t.Fatalf("heal %+v", h)
+	}
+	if lock := p.Lock("BTC"); len(lock) != 0 {
+		t.Fatal("symbol still locked")
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	p, sim, _ := newSimPlacer(t)
+	p.cfg.Reconcile.Tolerance = 10
+	p.cfg.Reconcile.Correct = true
+	report, err := p.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Drifts) != 1 || !report.Drifts[0].NoBaseline || report.Drifts[0].Flagged {
+		t.Fatalf("run without baseline drifts %+v", report.Drifts)
+	}
+	if err := p.ResetReconcile("test"); err != nil {
+		t.Fatal(err)
+	}
+	report, err = p.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := report.Drifts[0]; !d.Drift.IsZero() || d.NoBaseline {
+		t.Fatalf("first run drift %+v", d)
+	}
+	// 0.01 BTC appeared from nowhere
+	sim.SetBalance("BTC", decimal.NewFromFloat(1.01))
+	report, err = p.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := report.Drifts[0]; !d.Drift.Equal(decimal.NewFromFloat(0.01)) || d.Flagged {
+		t.Fatalf("second run drift %+v", d)
+	}
+	report, err = p.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := report.Drifts[0]; !d.Flagged || d.Heal == 0 || report.Corrections != 1 {
+		t.Fatalf("third run drift %+v", report)
+	}
+	heal := report.Drifts[0].Heal
+	p.Wait()
+	orders, _ := sim.GetOpenOrders(context.Background())
+	if len(orders) != 1 || orders[0].Side != store.SideSell || orders[0].Size != 0.01 {
+		t.Fatalf("correction orders %+v", orders)
+	}
+	if c, _ := UnmarshalClientID(*orders[0].ClientID); c.Side != CORRECT || c.ID != heal {
+		t.Fatalf("correction client id %+v", c)
+	}
+	// the pending correction is not placed again
+	report, err = p.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := report.Drifts[0]; d.Heal != heal || report.Corrections != 0 {
+		t.Fatalf("run with pending correction %+v", report)
+	}
+	sim.SetTicker(*ticker(simMarket, 30000, 30010))
+	eventually(t, "correction filled", func() bool {
+		_, ok := p.healMap.Load(heal)
+		return !ok
+	})
+	p.Wait()
+	report, err = p.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := report.Drifts[0]; !d.Drift.IsZero() || d.Flagged {
+		t.Fatalf("drift after correction %+v", d)
+	}
+}
+
+func TestRetryHealMissedFill(t *testing.T) {
+	p, sim, mem := newSimPlacer(t)
+	sb := placeBuySurebet(p)
+	eventually(t, "heal order", func() bool {
+		h := healByID(mem, sb.ID)
+		return h != nil && len(h.Orders) == 1
+	})
+	// the heal order fills while we are not subscribed
+	sim.Close()
+	sim.SetTicker(*ticker(simMarket, 20050, 20060))
+	p.openOrderMap.Range(func(key, value interface{}) bool {
+		p.openOrderMap.Delete(key)
+		return true
+	})
+	if err := sim.Subscribe(p.processOrder, p.processFills, p.errHandler); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.RetryHeal(sb.ID); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "heal filled", func() bool {
+		_, ok := p.healMap.Load(sb.ID)
+		return !ok
+	})
+	p.Wait()
+	if orders, _ := sim.GetOpenOrders(context.Background()); len(orders) != 0 {
+		t.Fatalf("heal placed again %+v", orders)
+	}
+}
+
+func TestInventory(t *testing.T) {
+	p, sim, _ := newSimPlacer(t)
+	pc := *p.loadPlaceConfig()
+	pc.InventoryTarget = decimal.NewFromInt(5000)
+	pc.InventorySkew = decimal.NewFromFloat(0.5)
+	if _, err := p.SetPlaceConfig(&pc, "test"); err != nil {
+		t.Fatal(err)
+	}
+	// 1 BTC is worth about 20000, four times the target
+	if dev := p.inventoryDev("BTC", pc.InventoryTarget); !dev.Equal(d1) {
+		t.Fatalf("dev %v", dev)
+	}
+	if skew := inventorySkew(d1, pc.InventorySkew, store.SideBuy); !skew.Equal(decimal.NewFromFloat(0.5)) {
+		t.Fatalf("buy skew %v", skew)
+	}
+	if skew := inventorySkew(d1, pc.InventorySkew, store.SideSell); !skew.Equal(decimal.NewFromFloat(-0.5)) {
+		t.Fatalf("sell skew %v", skew)
+	}
+
+	p.cfg.Inventory.Band = 0.5
+	p.cfg.Inventory.OrderUsd = 100
+	p.cfg.Inventory.RebalancePeriod = time.Hour
+	p.lastFtxPriceMap.Store(simMarket, decimal.NewFromInt(19990))
+	p.Rebalance()
+	orders, _ := sim.GetOpenOrders(context.Background())
+	if len(orders) != 1 || orders[0].Side != store.SideSell || orders[0].Size != 0.005 {
+		t.Fatalf("rebalance orders %+v", orders)
+	}
+	inv := p.Inventory()
+	if len(inv) != 1 || inv[0].OrderID != orders[0].ID {
+		t.Fatalf("inventory %+v", inv)
+	}
+	// one order per coin at a time
+	p.Rebalance()
+	if orders, _ = sim.GetOpenOrders(context.Background()); len(orders) != 1 {
+		t.Fatalf("second rebalance orders %d", len(orders))
+	}
+	_, err := p.Kill(KillSourceAdmin, "test", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if orders, _ = sim.GetOpenOrders(context.Background()); len(orders) != 0 {
+		t.Fatalf("rebalance order left after kill %+v", orders)
+	}
+	if inv := p.Inventory(); inv[0].OrderID != 0 {
+		t.Fatalf("inventory after kill %+v", inv)
+	}
+}
+
+func TestExposure(t *testing.T) {
+	p, _, mem := newSimPlacer(t)
+	p.cfg.Exposure.StableCoins = "USD"
+	calc := func() {
+		sb := &store.Surebet{
+			ID:        time.Now().UnixNano(),
+			FtxTicker: ticker(simMarket, 19990, 20000),
+			BinTicker: ticker(simMarket, 20100, 20110),
+			UsdtPrice: decimal.NewFromInt(1),
+		}
+		if lock := p.Calc(sb); lock != nil {
+			<-lock
+		}
+	}
+	// 1 BTC is worth 19990 at the last ftx bid before the bet
+	p.cfg.Exposure.CoinUsd = 20010
+	calc()
+	p.cfg.Exposure.CoinUsd = 0
+	p.cfg.Exposure.InventoryUsd = 20010
+	calc()
+	p.cfg.Exposure.InventoryUsd = 0
+	p.cfg.Exposure.OpenNotional = 5
+	calc()
+	stats := p.RejectStats()
+	for _, r := range []rejectReason{rejectExposureCoin, rejectExposureInventory, rejectExposureOpen} {
+		if stats[string(r)] != 1 {
+			t.Fatalf("reject stats %v", stats)
+		}
+	}
+	if e := p.Exposure(); !e.InventoryUsd.Equal(decimal.NewFromInt(19990)) || len(e.Coins) != 1 {
+		t.Fatalf("exposure %+v", e)
+	}
+	p.cfg.Exposure.OpenNotional = 0
+	// a bet on a eur market adds its volume in usd, 100 EUR is 120 USD
+	p.lastFtxPriceMap.Store("EUR/USD", decimal.NewFromFloat(1.2))
+	p.cfg.Exposure.CoinUsd = 20100
+	eurBet := &store.Surebet{
+		Market:      &store.MarketEmb{BaseCurrency: "BTC", QuoteCurrency: "EUR"},
+		PlaceParams: store.PlaceParamsEmb{Side: store.SideBuy},
+		Volume:      decimal.NewFromInt(100),
+	}
+	if r := p.checkExposure(eurBet); r != rejectExposureCoin {
+		t.Fatalf("eur bet reject %q", r)
+	}
+	p.cfg.Exposure.CoinUsd = 0
+	sb := placeBuySurebet(p)
+	eventually(t, "heal order", func() bool {
+		h := healByID(mem, sb.ID)
+		return h != nil && len(h.Orders) == 1
+	})
+}
+
+func TestLedger(t *testing.T) {
+	p, sim, mem := newSimPlacer(t)
+	p.cfg.Ledger.Lookback = time.Hour
+	sb := placeBuySurebet(p)
+	eventually(t, "heal order", func() bool {
+		h := healByID(mem, sb.ID)
+		return h != nil && len(h.Orders) == 1
+	})
+	sim.SetTicker(*ticker(simMarket, 20050, 20060))
+	eventually(t, "fills saved", func() bool {
+		return len(mem.Fills()) == 2
+	})
+	n, err := p.UpdateLedger()
+	if err != nil || n != 1 {
+		t.Fatalf("update ledger %d %v", n, err)
+	}
+	r, err := p.Ledger(time.Now().Add(-time.Hour), time.Now(), "", false, true)
+	if err != nil || len(r.Cycles) != 1 {
+		t.Fatalf("ledger %+v %v", r, err)
+	}
+	c := r.Cycles[0]
+	if !c.Closed || !c.OpenSize.IsZero() || c.Fills != 2 {
+		t.Fatalf("cycle %+v", c)
+	}
+	var fees decimal.Decimal
+	for _, f := range mem.Fills() {
+		fees = fees.Add(decimal.NewFromFloat(f.Fee))
+	}
+	want := c.HealValue.Sub(c.BetValue).Sub(fees)
+	if !c.RealizedPnl.Equal(want) || !c.RealizedPnl.IsPositive() {
+		t.Fatalf("realized %v, want %v", c.RealizedPnl, want)
+	}
+	if r.Total.Count != 1 || len(r.Markets) != 1 || !r.Total.RealizedPnl.Equal(c.RealizedPnl) {
+		t.Fatalf("totals %+v", r)
+	}
+}
+
+// pageSource records the largest page of order ids and heals.
+type pageSource struct {
+	*store.Memory
+	maxIDs   int
+	maxHeals int
+	healToID int64
+}
+
+func (s *pageSource) SelectHealsPage(afterID int64, toID int64, limit int) ([]store.Heal, error) {
+	data, err := s.Memory.SelectHealsPage(afterID, toID, limit)
+	if len(data) > s.maxHeals {
+		s.maxHeals = len(data)
+	}
+	s.healToID = toID
+	return data, err
+}
+
+func (s *pageSource) SelectFillsByOrderIDs(ids []int64) ([]store.Fills, error) {
+	if len(ids) > s.maxIDs {
+		s.maxIDs = len(ids)
+	}
+	return s.Memory.SelectFillsByOrderIDs(ids)
+}
+
+func TestLoadCycles(t *testing.T) {
+	mem := store.NewMemory()
+	for id := int64(1); id <= ledgerPageSize+1; id++ {
+		healOrder := &store.Order{ID: million + id}
+		mem.SaveSurebet(&store.Surebet{ID: id, OrderID: id})
+		mem.SaveHeal(&store.Heal{ID: id, Orders: []*store.Order{healOrder}})
+		mem.SaveFills(&store.Fills{ID: id, OrderID: id})
+		mem.SaveFills(&store.Fills{ID: million + id, OrderID: healOrder.ID})
+	}
+	src := &pageSource{Memory: mem}
+	data, err := LoadCycles(src, 0, ledgerPageSize, false)
+	if err != nil || len(data) != ledgerPageSize {
+		t.Fatalf("cycles %d %v", len(data), err)
+	}
+	if src.maxIDs > ledgerPageSize || src.maxHeals > ledgerPageSize || src.healToID != ledgerPageSize {
+		t.Fatalf("order id page %d heal page %d to %d over %d", src.maxIDs, src.maxHeals, src.healToID, ledgerPageSize)
+	}
+	if d := data[len(data)-1]; d.Heal == nil || len(d.Fills) != 2 {
+		t.Fatalf("last cycle %+v", d)
+	}
+}
+
+func TestEquity(t *testing.T) {
+	p, _, mem := newSimPlacer(t)
+	sb := placeBuySurebet(p)
+	eventually(t, "heal order", func() bool {
+		h := healByID(mem, sb.ID)
+		return h != nil && len(h.Orders) == 1
+	})
+	list := p.Equity()
+	total := list[len(list)-1]
+	// bought at 20000, marked at the last bid of 19990
+	if total.Coin != EquityTotal || !total.UnrealizedPnl.IsNegative() {
+		t.Fatalf("equity %+v", list)
+	}
+	from := time.Now()
+	if err := p.SnapshotEquity(); err != nil {
+		t.Fatal(err)
+	}
+	p.lastFtxPriceMap.Store(simMarket, decimal.NewFromInt(21000))
+	if err := p.SnapshotEquity(); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := p.EquityChanges(from, from.Add(time.Hour), time.Hour)
+	if err != nil || len(changes) != 1 {
+		t.Fatalf("changes %+v %v", changes, err)
+	}
+	c := changes[0]
+	if !c.Change.IsPositive() || !c.PriceMove.Equal(c.Change) || !c.Inventory.IsZero() {
+		t.Fatalf("change %+v", c)
+	}
+	if !c.EndUnrealized.IsPositive() {
+		t.Fatalf("end unrealized %v", c.EndUnrealized)
+	}
+
+	// paper snapshots stay out of the live series
+	p.cfg.Service.PaperMode = true
+	p.lastFtxPriceMap.Store(simMarket, decimal.NewFromInt(15000))
+	if err := p.SnapshotEquity(); err != nil {
+		t.Fatal(err)
+	}
+	p.cfg.Service.PaperMode = false
+	live, err := p.EquityChanges(from, from.Add(time.Hour), time.Hour)
+	if err != nil || len(live) != 1 || !live[0].EndUsd.Equal(c.EndUsd) {
+		t.Fatalf("live changes %+v %v", live, err)
+	}
+}
+
+// flakyStore fails fills writes, with a transient error while fails > 0 and for
+// good when the batch holds badFill, and surebet writes while surebetFails > 0. It
+// records the largest fills batch.
+type flakyStore struct {
+	*store.Memory
+	mu           sync.Mutex
+	fails        int
+	badFill      int64
+	maxBatch     int
+	surebetFails int
+}
+
+func (s *flakyStore) InsertSurebets(data []*store.Surebet) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.surebetFails > 0 {
+		s.surebetFails--
+		return driver.ErrBadConn
+	}
+	return s.Memory.InsertSurebets(data)
+}
+
+func (s *flakyStore) InsertFills(data []*store.Fills) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(data) > s.maxBatch {
+		s.maxBatch = len(data)
+	}
+	if s.fails > 0 {
+		s.fails--
+		return driver.ErrBadConn
+	}
+	for _, f := range data {
+		if f.ID == s.badFill {
+			return errors.New("bad_fill")
+		}
+	}
+	return s.Memory.InsertFills(data)
+}
+
+func TestWriter(t *testing.T) {
+	mem := store.NewMemory()
+	sto := &flakyStore{Memory: mem}
+	cfg := testConfig()
+	cfg.Writer.BatchSize = 3
+	cfg.Writer.FlushPeriod = time.Hour
+	cfg.Writer.Retries = 1
+	cfg.Writer.MaxQueue = 10
+	p, err := NewPlacer(cfg, zap.NewNop(), context.Background(), sto, venue.NewSim(zap.NewNop()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := p.startWriter()
+	p.saveFills(&store.Fills{ID: 1})
+	p.saveOrder(store.Order{ID: 1, Status: store.OrderStatusNew})
+	if p.queued(tableFills) != 1 || len(mem.Fills()) != 0 {
+		t.Fatal("written before the batch is full")
+	}
+	p.saveOrder(store.Order{ID: 1, Status: store.OrderStatusFilled})
+	eventually(t, "batch", func() bool { return len(mem.Fills()) == 1 && len(mem.Orders()) == 1 })
+	if o := mem.Orders()[0]; o.Status != store.OrderStatusFilled {
+		t.Fatalf("order %+v", o)
+	}
+	stop()
+
+	sto.fails = 2
+	p.saveFills(&store.Fills{ID: 2})
+	if n := p.Flush(); n != 0 || p.queued(tableFills) != 1 {
+		t.Fatalf("transient error written %d queued %d", n, p.queued(tableFills))
+	}
+	sto.badFill = 3
+	p.saveFills(&store.Fills{ID: 3})
+	if !p.Drain() || p.queued(tableFills) != 0 {
+		t.Fatal("drain did not flush")
+	}
+	fills := mem.Fills()
+	if len(fills) != 2 || fills[1].ID != 2 {
+		t.Fatalf("fills %+v", fills)
+	}
+	rec := httptest.NewRecorder()
+	p.MetricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		`placer_writer_retries_total{table="fills"} 1`,
+		`placer_writer_dropped_total{table="fills"} 1`,
+		`placer_writer_rows_total{table="orders"} 1`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Fatalf("metrics miss %q", want)
+		}
+	}
+}
+
+func TestWriterBacklog(t *testing.T) {
+	mem := store.NewMemory()
+	sto := &flakyStore{Memory: mem}
+	cfg := testConfig()
+	cfg.Writer.BatchSize = 3
+	cfg.Writer.FlushPeriod = time.Hour
+	cfg.Writer.Retries = 1
+	cfg.Writer.MaxQueue = 100
+	p, err := NewPlacer(cfg, zap.NewNop(), context.Background(), sto, venue.NewSim(zap.NewNop()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(1); i <= 8; i++ {
+		p.saveFills(&store.Fills{ID: i})
+	}
+	// the first batch fails, it is queued again with the batches after it
+	sto.fails = 2
+	sto.maxBatch = 0
+	if n := p.Flush(); n != 0 || p.queued(tableFills) != 8 {
+		t.Fatalf("written %d queued %d", n, p.queued(tableFills))
+	}
+	if n := p.Flush(); n != 8 || sto.maxBatch != cfg.Writer.BatchSize || len(mem.Fills()) != 8 {
+		t.Fatalf("written %d max batch %d fills %d", n, sto.maxBatch, len(mem.Fills()))
+	}
+
+	sto.surebetFails = 2
+	p.saveSurebet(&store.Surebet{ID: 1, OrderID: 1})
+	p.deleteSurebet(1)
+	p.Flush()
+	if p.queued(tableSurebets) != 1 || p.queued(tableDeletes) != 1 {
+		t.Fatalf("queued surebets %d deletes %d", p.queued(tableSurebets), p.queued(tableDeletes))
+	}
+	p.Flush()
+	if p.queued(tableDeletes) != 0 || len(mem.Surebets()) != 0 {
+		t.Fatalf("delete ran before the insert %+v", mem.Surebets())
+	}
+}
@@ -0,0 +1,410 @@
+package placer
+
+import (
+	"fmt"
+	"github.com/aibotsoft/crypto-surebet/pkg/store"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CORRECT heals place orders for inventory drifts, their ids are not surebet ids.
+const CORRECT = "c"
+
+// healSide reports whether orders of the client id side belong to a heal.
+func healSide(side string) bool {
+	return side == HEAL || side == CORRECT
+}
+
+const (
+	orphanNullClientID   = "null_client_id"
+	orphanSurebetMissing = "surebet_missing"
+	orphanHealMissing    = "heal_missing"
+)
+
+// InventoryDrift compares the balance of a coin with the one expected from its
+// saved baseline and the fills after it. A coin without a baseline is not checked.
+type InventoryDrift struct {
+	Coin       string          `json:"coin"`
+	Actual     decimal.Decimal `json:"actual"`
+	Expected   decimal.Decimal `json:"expected"`
+	Baseline   decimal.Decimal `json:"baseline"`
+	Drift      decimal.Decimal `json:"drift"`
+	DriftUsd   decimal.Decimal `json:"drift_usd"`
+	Flagged    bool            `json:"flagged"`
+	NoBaseline bool            `json:"no_baseline,omitempty"`
+	Heal       int64           `json:"heal,omitempty"`
+}
+
+type ReconcileReport struct {
+	Time        time.Time        `json:"time"`
+	Drifts      []InventoryDrift `json:"drifts"`
+	StuckHeals  []int64          `json:"stuck_heals"`
+	Orphans     map[string]int64 `json:"orphans"`
+	Corrections int              `json:"corrections"`
+}
+
+type reconciler struct {
+	mu sync.Mutex
+	// drift over tolerance on the last run, a drift is flagged when seen twice
+	over    map[string]bool
+	orphans map[string]int64
+	last    *ReconcileReport
+}
+
+func (p *Placer) orphan(kind string) {
+	p.reconcile.mu.Lock()
+	if p.reconcile.orphans == nil {
+		p.reconcile.orphans = make(map[string]int64)
+	}
+	p.reconcile.orphans[kind]++
+	p.reconcile.mu.Unlock()
+	p.metrics.orphans.WithLabelValues(kind).Inc()
+}
+
+// expectedInventory moves the baseline of every coin by the fills after it, the
+// size bought is added, the size sold and the fees paid in the coin are taken off.
+func (p *Placer) expectedInventory(baselines []store.InventoryBaseline) (map[string]decimal.Decimal, error) {
+	expected := make(map[string]decimal.Decimal, len(baselines))
+	after := make(map[int64]time.Time)
+	coins := make(map[int64][]string)
+	for _, b := range baselines {
+		expected[b.Coin] = b.Total
+		key := b.CreatedAt.UnixNano()
+		after[key] = b.CreatedAt
+		coins[key] = append(coins[key], b.Coin)
+	}
+	for key, t := range after {
+		flows, err := p.store.SelectFillFlows(t, p.cfg.Service.PaperMode)
+		if err != nil {
+			return nil, fmt.Errorf("select_fill_flows_error: %w", err)
+		}
+		for _, coin := range coins[key] {
+			expected[coin] = expected[coin].Add(coinFlow(flows, coin))
+		}
+	}
+	return expected, nil
+}
+
+func coinFlow(flows []store.FillFlow, coin string) decimal.Decimal {
+	var sum decimal.Decimal
+	for _, f := range flows {
+		if f.FeeCurrency == coin {
+			sum = sum.Sub(f.Fee)
+		}
+		if symbolFromMarket(f.Market) != coin {
+			continue
+		}
+		if f.Side == store.SideBuy {
+			sum = sum.Add(f.Size)
+		} else {
+			sum = sum.Sub(f.Size)
+		}
+	}
+	return sum
+}
+
+// stuckHeals returns pending heals without an open order for two re-heal periods,
+// their orders were closed without us seeing it.
+func (p *Placer) stuckHeals() []int64 {
+	open := make(map[int64]bool)
+	for _, o := range p.OpenOrders() {
+		if o.ClientID == nil {
+			continue
+		}
+		clientID, err := UnmarshalClientID(*o.ClientID)
+		if err == nil && healSide(clientID.Side) {
+			open[clientID.ID] = true
+		}
+	}
+	var stuck []int64
+	for _, h := range p.PendingHeals() {
+		if open[h.ID] || h.Done == 0 || p.since(time.Unix(0, h.Done)) < 2*p.cfg.Service.ReHealPeriod {
+			continue
+		}
+		stuck = append(stuck, h.ID)
+	}
+	return stuck
+}
+
+// Reconcile compares balances with the inventory expected from the baselines and
+// the fills after them, and flags drifts over the tolerance seen on two runs in a
+// row. With Correct set it places a heal for a flagged drift and retries stuck heals.
+func (p *Placer) Reconcile() (*ReconcileReport, error) {
+	err := p.GetBalances()
+	if err != nil {
+		return nil, err
+	}
+	// fills still queued for the writer are expected too
+	p.Flush()
+	cfg := p.cfg.Reconcile
+	baselines, err := p.store.SelectInventoryBaselines(p.cfg.Service.PaperMode)
+	if err != nil {
+		return nil, fmt.Errorf("select_inventory_baselines_error: %w", err)
+	}
+	expected, err := p.expectedInventory(baselines)
+	if err != nil {
+		return nil, err
+	}
+	baselineTotal := make(map[string]decimal.Decimal, len(baselines))
+	for _, b := range baselines {
+		baselineTotal[b.Coin] = b.Total
+	}
+	quotes := make(map[string]bool)
+	bases := make(map[string]bool)
+	p.marketLock.Lock()
+	for _, m := range p.marketMap {
+		quotes[m.QuoteCurrency] = true
+		bases[m.BaseCurrency] = true
+	}
+	p.marketLock.Unlock()
+
+	report := &ReconcileReport{Time: p.clock.Now(), StuckHeals: p.stuckHeals()}
+	tolerance := decimal.NewFromFloat(cfg.Tolerance)
+	var noBaseline []string
+	p.reconcile.mu.Lock()
+	if p.reconcile.over == nil {
+		p.reconcile.over = make(map[string]bool)
+	}
+	for coin, b := range p.Balances() {
+		if quotes[coin] || !bases[coin] {
+			continue
+		}
+		exp, ok := expected[coin]
+		if !ok {
+			noBaseline = append(noBaseline, coin)
+			report.Drifts = append(report.Drifts, InventoryDrift{Coin: coin, Actual: b.Total, NoBaseline: true})
+			continue
+		}
+		d := InventoryDrift{
+			Coin:     coin,
+			Actual:   b.Total,
+			Expected: exp,
+			Baseline: baselineTotal[coin],
+			Drift:    b.Total.Sub(exp),
+		}
+		if !b.Total.IsZero() {
+			d.DriftUsd = d.Drift.Mul(b.UsdValue).Div(b.Total).Round(2)
+		}
+		over := d.DriftUsd.Abs().GreaterThan(tolerance)
+		d.Flagged = over && p.reconcile.over[coin]
+		p.reconcile.over[coin] = over
+		p.metrics.inventoryDrift.WithLabelValues(coin).Set(d.DriftUsd.InexactFloat64())
+		report.Drifts = append(report.Drifts, d)
+	}
+	report.Orphans = make(map[string]int64, len(p.reconcile.orphans))
+	for kind, n := range p.reconcile.orphans {
+		report.Orphans[kind] = n
+	}
+	p.reconcile.mu.Unlock()
+	sort.Slice(report.Drifts, func(i, j int) bool { return report.Drifts[i].Coin < report.Drifts[j].Coin })
+	if len(noBaseline) > 0 {
+		sort.Strings(noBaseline)
+		p.log.Warn("reconcile_no_baseline", zap.Strings("coins", noBaseline))
+	}
+
+	for i := range report.Drifts {
+		d := &report.Drifts[i]
+		if !d.Flagged {
+			continue
+		}
+		p.log.Warn("inventory_drift",
+			zap.String("coin", d.Coin),
+			zap.Float64("actual", d.Actual.InexactFloat64()),
+			zap.Float64("expected", d.Expected.InexactFloat64()),
+			zap.Float64("baseline", d.Baseline.InexactFloat64()),
+			zap.Float64("drift", d.Drift.InexactFloat64()),
+			zap.Float64("drift_usd", d.DriftUsd.InexactFloat64()),
+		)
+		if id := p.pendingCorrection(d.Coin); id != 0 {
+			// its fills are not in the balance yet
+			d.Heal = id
+			continue
+		}
+		if cfg.Correct {
+			if id := p.correctDrift(*d); id != 0 {
+				d.Heal = id
+				report.Corrections++
+			}
+		}
+	}
+	for _, id := range report.StuckHeals {
+		p.log.Warn("stuck_heal", zap.Int64("i", id))
+		if cfg.Correct {
+			_, err := p.RetryHeal(id)
+			if err != nil {
+				p.log.Error("retry_stuck_heal_error", zap.Int64("i", id), zap.Error(err))
+				continue
+			}
+			report.Corrections++
+		}
+	}
+	p.reconcile.mu.Lock()
+	p.reconcile.last = report
+	p.reconcile.mu.Unlock()
+	return report, nil
+}
+
+// pendingCorrection returns the id of the pending correction of coin, 0 when none.
+func (p *Placer) pendingCorrection(coin string) int64 {
+	for _, h := range p.PendingHeals() {
+		clientID, err := UnmarshalClientID(h.PlaceParams.ClientID)
+		if err == nil && clientID.Side == CORRECT && symbolFromMarket(h.PlaceParams.Market) == coin {
+			return h.ID
+		}
+	}
+	return 0
+}
+
+// correctDrift places a heal selling the extra coin or buying the missing one at
+// the last price, on the USD market of the coin or on another one with a price.
+// Its fills move the baseline, so the drift is gone once it is filled. It returns
+// the heal id, 0 when nothing was placed.
+func (p *Placer) correctDrift(d InventoryDrift) int64 {
+	var m *store.MarketEmb
+	var market string
+	p.marketLock.Lock()
+	for name, mm := range p.marketMap {
+		if mm.BaseCurrency != d.Coin || mm.MinProvideSize.IsZero() || mm.PriceIncrement.IsZero() {
+			continue
+		}
+		if _, ok := p.lastFtxPriceMap.Load(name); !ok && mm.QuoteCurrency != "USD" {
+			continue
+		}
+		if m == nil || mm.QuoteCurrency == "USD" {
+			m, market = mm, name
+		}
+	}
+	p.marketLock.Unlock()
+	if m == nil {
+		p.log.Warn("correct_drift_no_market", zap.String("coin", d.Coin))
+		return 0
+	}
+	var price decimal.Decimal
+	if got, ok := p.lastFtxPriceMap.Load(market); ok {
+		price = got.(decimal.Decimal)
+	} else if !d.Drift.IsZero() {
+		price = d.DriftUsd.Div(d.Drift).Div(m.PriceIncrement).Floor().Mul(m.PriceIncrement)
+	}
+	size := d.Drift.Abs().Div(m.MinProvideSize).Floor().Mul(m.MinProvideSize)
+	if size.IsZero() || !price.IsPositive() {
+		return 0
+	}
+	now := p.clock.Now().UnixNano()
+	h := &store.Heal{
+		ID:             now,
+		Start:          now,
+		FilledSize:     size,
+		AvgFillPrice:   price,
+		MinSize:        m.MinProvideSize,
+		PriceIncrement: m.PriceIncrement,
+		Paper:          p.cfg.Service.PaperMode,
+		ErrorMsg:       stringPointer("reconcile_drift"),
+		PlaceParams: store.PlaceParamsEmb{
+			Market:   market,
+			Type:     store.OrderTypeLimit,
+			Price:    price,
+			Size:     size,
+			PostOnly: true,
+			ClientID: marshalClientID(ClientID{ID: now, Side: CORRECT}),
+		},
+	}
+	if d.Drift.IsPositive() {
+		h.PlaceParams.Side = store.SideSell
+	} else {
+		h.PlaceParams.Side = store.SideBuy
+	}
+	p.log.Warn("correct_drift",
+		zap.Int64("i", h.ID),
+		zap.String("m", market),
+		zap.String("s", string(h.PlaceParams.Side)),
+		zap.Float64("pr", price.InexactFloat64()),
+		zap.Float64("sz", size.InexactFloat64()),
+	)
+	// the heal goroutines own h from here and change it under healLock
+	p.healMap.Store(h.ID, h)
+	p.async(func() { p.placeHeal(h) })
+	return h.ID
+}
+
+// correctionClosed moves the baseline of the coin by the fill of a correction order,
+// it is made to remove a drift and must not be expected.
+func (p *Placer) correctionClosed(o store.Order) {
+	if o.FilledSize == 0 {
+		return
+	}
+	filled := decimal.NewFromFloat(o.FilledSize)
+	if o.Side == store.SideBuy {
+		filled = filled.Neg()
+	}
+	p.rebase(symbolFromMarket(o.Market), filled)
+}
+
+// rebase saves the baseline of coin moved by delta.
+func (p *Placer) rebase(coin string, delta decimal.Decimal) {
+	baselines, err := p.store.SelectInventoryBaselines(p.cfg.Service.PaperMode)
+	if err != nil {
+		p.log.Error("rebase_error", zap.String("coin", coin), zap.Error(err))
+		return
+	}
+	for _, b := range baselines {
+		if b.Coin != coin {
+			continue
+		}
+		b.ID = 0
+		b.Total = b.Total.Add(delta)
+		b.Source = "correct"
+		err = p.store.SaveInventoryBaselines([]store.InventoryBaseline{b})
+		if err != nil {
+			p.log.Error("rebase_error", zap.String("coin", coin), zap.Error(err))
+			return
+		}
+		p.log.Info("rebase", zap.String("coin", coin), zap.Float64("delta", delta.InexactFloat64()))
+	}
+}
+
+func (p *Placer) LastReconcile() *ReconcileReport {
+	p.reconcile.mu.Lock()
+	defer p.reconcile.mu.Unlock()
+	return p.reconcile.last
+}
+
+// ResetReconcile saves the current balances of the base coins as their baseline, to
+// start reconciling and after a deposit or a withdrawal.
+func (p *Placer) ResetReconcile(source string) error {
+	err := p.GetBalances()
+	if err != nil {
+		return err
+	}
+	now := p.clock.Now()
+	bases := make(map[string]bool)
+	p.marketLock.Lock()
+	for _, m := range p.marketMap {
+		bases[m.BaseCurrency] = true
+	}
+	p.marketLock.Unlock()
+	var list []store.InventoryBaseline
+	for coin, b := range p.Balances() {
+		if !bases[coin] {
+			continue
+		}
+		list = append(list, store.InventoryBaseline{
+			CreatedAt: now,
+			Coin:      coin,
+			Total:     b.Total,
+			Source:    source,
+			Paper:     p.cfg.Service.PaperMode,
+		})
+	}
+	err = p.store.SaveInventoryBaselines(list)
+	if err != nil {
+		return fmt.Errorf("save_inventory_baselines_error: %w", err)
+	}
+	p.reconcile.mu.Lock()
+	p.reconcile.over = nil
+	p.reconcile.mu.Unlock()
+	p.log.Info("reconcile_reset", zap.String("source", source), zap.Int("coins", len(list)))
+	return nil
+}
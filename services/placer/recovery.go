@@ -0,0 +1,225 @@
+package placer
+
+import (
+	"context"
+	"fmt"
+	"github.com/aibotsoft/crypto-surebet/pkg/store"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"time"
+)
+
+type recoveryStat struct {
+	BetsOpen     int `json:"bets_open"`
+	BetsHealed   int `json:"bets_healed"`
+	BetsUnfilled int `json:"bets_unfilled"`
+	BetsLost     int `json:"bets_lost"`
+	HealsOpen    int `json:"heals_open"`
+	HealsResumed int `json:"heals_resumed"`
+	HealsPlaced  int `json:"heals_placed"`
+}
+
+// venueOrders returns open and recent orders of the venue by order id.
+func (p *Placer) venueOrders() (map[int64]store.Order, error) {
+	ctx, cancel := context.WithTimeout(p.ctx, 10*time.Second)
+	defer cancel()
+	history, err := p.venue.GetOrderHistory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	open, err := p.venue.GetOpenOrders(ctx)
+	if err != nil {
+		return nil, err
+	}
+	orders := make(map[int64]store.Order, len(history)+len(open))
+	for _, o := range history {
+		orders[o.ID] = o
+	}
+	for _, o := range open {
+		orders[o.ID] = o
+	}
+	return orders, nil
+}
+
+// Recover rebuilds surebetMap and healMap after a restart. Bets saved without a
+// heal get healed or wait for their close event, unfinished heals are resumed.
+func (p *Placer) Recover() error {
+	if p.cfg.Recovery.Lookback <= 0 {
+		return nil
+	}
+	start := time.Now()
+	afterID := p.clock.Now().Add(-p.cfg.Recovery.Lookback).UnixNano()
+	orders, err := p.venueOrders()
+	if err != nil {
+		return fmt.Errorf("recover_orders_error: %w", err)
+	}
+	byClient := make(map[ClientID][]store.Order)
+	for _, o := range orders {
+		if o.ClientID == nil {
+			continue
+		}
+		clientID, err := UnmarshalClientID(*o.ClientID)
+		if err != nil {
+			continue
+		}
+		key := ClientID{ID: clientID.ID, Side: clientID.Side}
+		byClient[key] = append(byClient[key], o)
+	}
+	var stat recoveryStat
+
+	surebets, err := p.store.SelectUnhealedSurebets(afterID)
+	if err != nil {
+		return fmt.Errorf("select_unhealed_surebets_error: %w", err)
+	}
+	for i := range surebets {
+		sb := &surebets[i]
+		if sb.Paper != p.cfg.Service.PaperMode || sb.Market == nil {
+			continue
+		}
+		order, ok := orders[sb.OrderID]
+		if !ok {
+			bets := byClient[ClientID{ID: sb.ID, Side: BET}]
+			if len(bets) == 0 {
+				p.log.Warn("recover_bet_order_not_found", zap.Int64("i", sb.ID), zap.Int64("order_id", sb.OrderID))
+				stat.BetsLost++
+				continue
+			}
+			order = bets[0]
+		}
+		if order.Status == store.OrderStatusClosed && order.FilledSize == 0 {
+			stat.BetsUnfilled++
+			p.deleteSurebet(order.ID)
+			continue
+		}
+		// a placed bet holds the symbol lock until heal releases it
+		lock := p.Lock(symbolFromMarket(sb.PlaceParams.Market))
+		o := order
+		if o.Status != store.OrderStatusClosed {
+			stat.BetsOpen++
+		} else {
+			stat.BetsHealed++
+		}
+		p.async(func() {
+			lock <- sb.ID
+			p.surebetMap.Store(sb.ID, sb)
+			p.replayBet(sb, o)
+		})
+	}
+
+	heals, err := p.store.SelectHeals(afterID)
+	if err != nil {
+		return fmt.Errorf("select_heals_error: %w", err)
+	}
+	for i := range heals {
+		h := &heals[i]
+		if h.Paper != p.cfg.Service.PaperMode || h.Done == 0 || h.MinSize.IsZero() {
+			continue
+		}
+		venueHeals := append(byClient[ClientID{ID: h.ID, Side: HEAL}], byClient[ClientID{ID: h.ID, Side: CORRECT}]...)
+		p.recoverHeal(h, orders, venueHeals, &stat)
+	}
+	p.log.Info("recovery_done", zap.Any("stat", stat), zap.Duration("elapsed", time.Since(start)))
+	return nil
+}
+
+// healState is a heal after its orders were refreshed from the venue.
+type healState struct {
+	filledSizeSum decimal.Decimal
+	open          bool
+	last          *store.Order
+}
+
+// refreshHealOrders replaces the heal orders by their venue state and adds those
+// never saved, the caller owns h or holds healLock.
+func refreshHealOrders(h *store.Heal, orders map[int64]store.Order, venueHeals []store.Order) healState {
+	known := make(map[int64]bool, len(h.Orders))
+	for i, o := range h.Orders {
+		known[o.ID] = true
+		if fresh, ok := orders[o.ID]; ok {
+			fresh := fresh
+			h.Orders[i] = &fresh
+		}
+	}
+	for _, o := range venueHeals {
+		if !known[o.ID] {
+			o := o
+			h.Orders = append(h.Orders, &o)
+		}
+	}
+	var s healState
+	for _, o := range h.Orders {
+		s.filledSizeSum = s.filledSizeSum.Add(decimal.NewFromFloat(o.FilledSize))
+		s.open = s.open || o.Status != store.OrderStatusClosed
+		if s.last == nil || o.CreatedAt.After(s.last.CreatedAt) {
+			s.last = o
+		}
+	}
+	return s
+}
+
+// venueHealOrders returns the venue orders of heal id.
+func venueHealOrders(orders map[int64]store.Order, id int64) []store.Order {
+	var list []store.Order
+	for _, o := range orders {
+		if o.ClientID == nil {
+			continue
+		}
+		clientID, err := UnmarshalClientID(*o.ClientID)
+		if err == nil && healSide(clientID.Side) && clientID.ID == id {
+			list = append(list, o)
+		}
+	}
+	return list
+}
+
+// replayBet heals a recovered bet or cancels its open order, it runs holding the
+// symbol lock once sb is in surebetMap. A close event seen before found no surebet,
+// so the order state is read from the venue again.
+func (p *Placer) replayBet(sb *store.Surebet, o store.Order) {
+	if o.Status != store.OrderStatusClosed {
+		orders, err := p.venueOrders()
+		if err != nil {
+			p.log.Error("replay_bet_orders_error", zap.Int64("i", sb.ID), zap.Error(err))
+		} else if fresh, ok := orders[o.ID]; ok {
+			o = fresh
+		}
+	}
+	if o.Status == store.OrderStatusClosed {
+		p.heal(o, ClientID{ID: sb.ID, Side: BET})
+		return
+	}
+	// the close event after the cancel finds sb in surebetMap
+	p.cancelBetOrder(o.ID, sb.ID)
+}
+
+// recoverHeal refreshes the heal orders from the venue, also those never saved,
+// and resumes the heal if it is not filled and has no open order.
+func (p *Placer) recoverHeal(h *store.Heal, orders map[int64]store.Order, venueHeals []store.Order, stat *recoveryStat) {
+	s := refreshHealOrders(h, orders, venueHeals)
+	filledSizeSum, open, last := s.filledSizeSum, s.open, s.last
+	if h.FilledSize.Sub(filledSizeSum).LessThan(h.MinSize) {
+		return
+	}
+	// log before the heal goroutine owns h
+	p.log.Info("recover_heal",
+		zap.Int64("i", h.ID),
+		zap.String("m", h.PlaceParams.Market),
+		zap.Float64("bf_size", h.FilledSize.InexactFloat64()),
+		zap.Float64("hf_size", filledSizeSum.InexactFloat64()),
+		zap.Int("h_count", len(h.Orders)),
+		zap.Bool("open", open),
+	)
+	p.healMap.Store(h.ID, h)
+	switch {
+	case open:
+		stat.HealsOpen++
+	case last == nil:
+		stat.HealsPlaced++
+		p.async(func() { p.placeHeal(h) })
+	default:
+		stat.HealsResumed++
+		clientID := ClientID{ID: h.ID, Side: HEAL, Try: int64(len(h.Orders) - 1)}
+		o := *last
+		p.async(func() { p.reHeal(o, clientID) })
+	}
+}
@@ -0,0 +1,103 @@
+package placer
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"sync/atomic"
+	"time"
+)
+
+const rejectShutdown rejectReason = "shutdown"
+
+func (p *Placer) stopping() bool {
+	return atomic.LoadInt32(&p.stopped) == 1
+}
+
+// Shutdown stops taking surebets, cancels open bets when configured, waits for the
+// heals of filled bets, then stops Serve, saves what is queued and closes the venue.
+// Everything left after timeout is logged and dropped.
+func (p *Placer) Shutdown(timeout time.Duration) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if !atomic.CompareAndSwapInt32(&p.stopped, 0, 1) {
+		return
+	}
+	p.log.Info("shutdown_start", zap.Duration("timeout", timeout))
+	if p.sub != nil {
+		err := p.sub.Unsubscribe()
+		if err != nil {
+			p.log.Warn("nats_unsubscribe_error", zap.Error(err))
+		}
+	}
+	if p.nc != nil {
+		p.nc.Close()
+	}
+	p.waitPlacing(ctx)
+	if p.cfg.Shutdown.CancelBets {
+		for _, sb := range p.InflightSurebets() {
+			if sb.OrderID != 0 {
+				p.cancelBetOrder(sb.OrderID, sb.ID)
+			}
+		}
+	}
+	unsettled := p.waitSettled(ctx)
+
+	if atomic.LoadInt32(&p.serving) == 1 {
+		done := make(chan struct{})
+		p.stopServe <- done
+		<-done
+	}
+	p.Drain()
+	p.Close()
+	p.log.Info("shutdown_done",
+		zap.Duration("elapsed", time.Since(start)),
+		zap.Int("unsettled_surebets", unsettled),
+		zap.Int("pending_heals", len(p.PendingHeals())),
+	)
+}
+
+// waitPlacing waits for the bets that passed the stopping check before Shutdown to
+// be placed, so they are canceled and settled with the others.
+func (p *Placer) waitPlacing(ctx context.Context) {
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	for atomic.LoadInt32(&p.placing) > 0 {
+		select {
+		case <-ctx.Done():
+			p.log.Warn("shutdown_placing_timeout", zap.Int32("count", atomic.LoadInt32(&p.placing)))
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// waitSettled waits until every placed bet got its close event and the goroutines
+// started for it are done, it returns the number of bets still waiting.
+func (p *Placer) waitSettled(ctx context.Context) int {
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		n := len(p.InflightSurebets()) + int(atomic.LoadInt32(&p.placing))
+		if n == 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			p.log.Warn("shutdown_unsettled_surebets", zap.Int("count", n))
+			return n
+		case <-tick.C:
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		p.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		p.log.Warn("shutdown_wait_timeout")
+	}
+	return 0
+}
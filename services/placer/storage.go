@@ -0,0 +1,41 @@
+package placer
+
+import (
+	"github.com/aibotsoft/crypto-surebet/pkg/store"
+	"time"
+)
+
+// Storage is what the placer persists to, *store.Store in production and
+// *store.Memory in simulations.
+type Storage interface {
+	SaveAccount(resp *store.Account) error
+	SaveBalances(balanceList *[]store.Balance) error
+	SaveMarkets(data *[]store.Market) error
+	SaveOrders(data []store.Order) error
+	UpsertOrders(data []store.Order) error
+	InsertSurebets(data []*store.Surebet) error
+	InsertFills(data []*store.Fills) error
+	UpsertHeals(data []*store.Heal) error
+	SaveConfigChanges(data []store.ConfigChange) error
+	SaveFunnelStats(data []store.FunnelStat) error
+	SaveKillEvent(data *store.KillEvent) error
+	DeleteSurebetsByOrderIDs(ids []int64) error
+	SelectHealByID(id int64) (*store.Heal, error)
+	SelectUnhealedSurebets(afterID int64) ([]store.Surebet, error)
+	SelectHeals(afterID int64) ([]store.Heal, error)
+	SelectHealsPage(afterID int64, toID int64, limit int) ([]store.Heal, error)
+	SelectSurebets(afterID int64, toID int64, limit int) ([]store.Surebet, error)
+	SelectFillsByOrderIDs(ids []int64) ([]store.Fills, error)
+	SavePnlCycles(data []store.PnlCycle) error
+	SelectPnlCycles(from time.Time, to time.Time, market string, paper bool) ([]store.PnlCycle, error)
+	SaveEquitySnapshots(data []store.EquitySnapshot) error
+	SaveInventoryBaselines(data []store.InventoryBaseline) error
+	SelectInventoryBaselines(paper bool) ([]store.InventoryBaseline, error)
+	SelectFillFlows(after time.Time, paper bool) ([]store.FillFlow, error)
+	SelectEquitySnapshots(from time.Time, to time.Time, coin string, paper bool) ([]store.EquitySnapshot, error)
+	FindHealOrders(heal *store.Heal)
+	SelectPlaceOverrides() ([]store.PlaceOverride, error)
+	SelectMarketRules() ([]store.MarketRule, error)
+	SelectTradingWindows(after time.Time) ([]store.TradingWindow, error)
+	SelectActiveKillEvent() (*store.KillEvent, error)
+}
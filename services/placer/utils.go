@@ -15,6 +15,11 @@ func symbolFromMarket(m string) string {
 	return split[0]
 }
 
+func quoteFromMarket(m string) string {
+	split := strings.Split(m, "/")
+	return split[len(split)-1]
+}
+
 type ClientID struct {
 	ID   int64  `json:"i"`
 	Side string `json:"s"`
@@ -25,7 +30,14 @@ func marshalClientID(c ClientID) string {
 	data, _ := json.Marshal(c)
 	return string(data)
 }
-func unmarshalClientID(c string) (clientID ClientID, err error) {
+func UnmarshalClientID(c string) (clientID ClientID, err error) {
 	err = json.Unmarshal([]byte(c), &clientID)
 	return
 }
+
+func stringPointer(s string) *string {
+	return &s
+}
+func int64Pointer(i int64) *int64 {
+	return &i
+}
@@ -0,0 +1,287 @@
+package placer
+
+import (
+	"github.com/aibotsoft/crypto-surebet/pkg/store"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+const (
+	tableOrders   = "orders"
+	tableSurebets = "surebets"
+	tableHeals    = "heals"
+	tableFills    = "fills"
+	tableDeletes  = "deletes"
+)
+
+// writeQueue holds rows waiting for the writer, deletes are order ids of unfilled
+// bets.
+type writeQueue struct {
+	orders   []store.Order
+	surebets []*store.Surebet
+	heals    []*store.Heal
+	fills    []*store.Fills
+	deletes  []int64
+}
+
+func (q *writeQueue) len() int {
+	return len(q.orders) + len(q.surebets) + len(q.heals) + len(q.fills) + len(q.deletes)
+}
+
+func (q *writeQueue) tableLen(table string) int {
+	switch table {
+	case tableOrders:
+		return len(q.orders)
+	case tableSurebets:
+		return len(q.surebets)
+	case tableHeals:
+		return len(q.heals)
+	case tableFills:
+		return len(q.fills)
+	default:
+		return len(q.deletes)
+	}
+}
+
+// writer batches what the placer persists, queueing never blocks and the batches
+// are written by runWriter or by Flush.
+type writer struct {
+	mu    sync.Mutex
+	queue writeQueue
+	flush sync.Mutex
+	kick  chan struct{}
+}
+
+func (p *Placer) enqueue(add func(q *writeQueue)) {
+	w := &p.writer
+	w.mu.Lock()
+	add(&w.queue)
+	n := w.queue.len()
+	w.mu.Unlock()
+	if n >= p.cfg.Writer.BatchSize || p.cfg.Writer.FlushPeriod <= 0 {
+		select {
+		case w.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// saveSurebet and saveHeal queue a copy, the live rows keep changing until the flush.
+func (p *Placer) saveSurebet(sb *store.Surebet) {
+	p.surebetLock.Lock()
+	c := *sb
+	p.surebetLock.Unlock()
+	p.enqueue(func(q *writeQueue) { q.surebets = append(q.surebets, &c) })
+}
+
+func (p *Placer) saveHeal(h *store.Heal) {
+	c := p.healCopy(h)
+	p.enqueue(func(q *writeQueue) { q.heals = append(q.heals, &c) })
+}
+
+func (p *Placer) saveFills(f *store.Fills) {
+	p.enqueue(func(q *writeQueue) { q.fills = append(q.fills, f) })
+}
+
+func (p *Placer) saveOrder(o store.Order) {
+	p.enqueue(func(q *writeQueue) { q.orders = append(q.orders, o) })
+}
+
+func (p *Placer) deleteSurebet(orderID int64) {
+	p.enqueue(func(q *writeQueue) { q.deletes = append(q.deletes, orderID) })
+}
+
+// queued returns the rows waiting by table.
+func (p *Placer) queued(table string) int {
+	p.writer.mu.Lock()
+	defer p.writer.mu.Unlock()
+	return p.writer.queue.tableLen(table)
+}
+
+// requeue puts rows of a failed batch back in front of the queue, it reports false
+// when the queue is full and they have to be dropped.
+func (p *Placer) requeue(n int, add func(q *writeQueue)) bool {
+	w := &p.writer
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.queue.len()+n > p.cfg.Writer.MaxQueue {
+		return false
+	}
+	rest := w.queue
+	w.queue = writeQueue{}
+	add(&w.queue)
+	w.queue.orders = append(w.queue.orders, rest.orders...)
+	w.queue.surebets = append(w.queue.surebets, rest.surebets...)
+	w.queue.heals = append(w.queue.heals, rest.heals...)
+	w.queue.fills = append(w.queue.fills, rest.fills...)
+	w.queue.deletes = append(w.queue.deletes, rest.deletes...)
+	return true
+}
+
+// lastOrders keeps the last state of every order, an upsert can't touch a row twice.
+func lastOrders(list []store.Order) []store.Order {
+	seen := make(map[int64]bool, len(list))
+	out := make([]store.Order, 0, len(list))
+	for i := len(list) - 1; i >= 0; i-- {
+		if !seen[list[i].ID] {
+			seen[list[i].ID] = true
+			out = append(out, list[i])
+		}
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+func lastHeals(list []*store.Heal) []*store.Heal {
+	seen := make(map[int64]bool, len(list))
+	out := make([]*store.Heal, 0, len(list))
+	for i := len(list) - 1; i >= 0; i-- {
+		if !seen[list[i].ID] {
+			seen[list[i].ID] = true
+			out = append(out, list[i])
+		}
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+// Flush writes everything queued table by table, it returns the rows written.
+func (p *Placer) Flush() int {
+	w := &p.writer
+	w.flush.Lock()
+	defer w.flush.Unlock()
+	w.mu.Lock()
+	q := w.queue
+	w.queue = writeQueue{}
+	w.mu.Unlock()
+
+	orders, heals := lastOrders(q.orders), lastHeals(q.heals)
+	var n int
+	written, heldOrders := p.writeTable(tableOrders, len(orders),
+		func(i, j int) error { return p.store.UpsertOrders(orders[i:j]) },
+		func(q *writeQueue, i int) { q.orders = orders[i:] })
+	n += written
+	written, heldSurebets := p.writeTable(tableSurebets, len(q.surebets),
+		func(i, j int) error { return p.store.InsertSurebets(q.surebets[i:j]) },
+		func(r *writeQueue, i int) { r.surebets = q.surebets[i:] })
+	n += written
+	written, _ = p.writeTable(tableHeals, len(heals),
+		func(i, j int) error { return p.store.UpsertHeals(heals[i:j]) },
+		func(q *writeQueue, i int) { q.heals = heals[i:] })
+	n += written
+	written, _ = p.writeTable(tableFills, len(q.fills),
+		func(i, j int) error { return p.store.InsertFills(q.fills[i:j]) },
+		func(r *writeQueue, i int) { r.fills = q.fills[i:] })
+	n += written
+	if (heldOrders || heldSurebets) && len(q.deletes) > 0 {
+		// a delete may be of a requeued surebet or order, it waits for the insert
+		if !p.requeue(len(q.deletes), func(r *writeQueue) { r.deletes = q.deletes }) {
+			p.log.Error("writer_queue_full", zap.String("table", tableDeletes), zap.Int("rows", len(q.deletes)))
+			p.metrics.writerDropped.WithLabelValues(tableDeletes).Add(float64(len(q.deletes)))
+		}
+		return n
+	}
+	written, _ = p.writeTable(tableDeletes, len(q.deletes),
+		func(i, j int) error { return p.store.DeleteSurebetsByOrderIDs(q.deletes[i:j]) },
+		func(r *writeQueue, i int) { r.deletes = q.deletes[i:] })
+	return n + written
+}
+
+// writeTable writes rows [0, n) with write in batches of BatchSize rows. A batch
+// failing with a transient error is queued again with the rows after it and held is
+// set, otherwise its rows are written one by one to drop only bad ones.
+func (p *Placer) writeTable(table string, n int, write func(i, j int) error, requeue func(q *writeQueue, i int)) (written int, held bool) {
+	size := p.cfg.Writer.BatchSize
+	if size <= 0 {
+		size = n
+	}
+	for i := 0; i < n; i += size {
+		j := i + size
+		if j > n {
+			j = n
+		}
+		start := time.Now()
+		err := p.writeRetry(table, func() error { return write(i, j) })
+		p.metrics.writerLatency.WithLabelValues(table).Observe(time.Since(start).Seconds())
+		p.metrics.writerBatches.WithLabelValues(table).Inc()
+		if err == nil {
+			p.metrics.writerRows.WithLabelValues(table).Add(float64(j - i))
+			written += j - i
+			continue
+		}
+		if store.IsTransient(err) {
+			if p.requeue(n-i, func(q *writeQueue) { requeue(q, i) }) {
+				p.log.Warn("writer_requeue", zap.String("table", table), zap.Int("rows", n-i), zap.Error(err))
+				return written, true
+			}
+			p.log.Error("writer_queue_full", zap.String("table", table), zap.Int("rows", n-i), zap.Error(err))
+			p.metrics.writerDropped.WithLabelValues(table).Add(float64(n - i))
+			return written, false
+		}
+		var rows int
+		for k := i; k < j; k++ {
+			err := write(k, k+1)
+			if err != nil {
+				p.log.Error("writer_drop_row", zap.String("table", table), zap.Error(err))
+				p.metrics.writerDropped.WithLabelValues(table).Inc()
+				continue
+			}
+			rows++
+		}
+		p.metrics.writerRows.WithLabelValues(table).Add(float64(rows))
+		written += rows
+	}
+	return written, false
+}
+
+func (p *Placer) writeRetry(table string, f func() error) error {
+	for try := 0; ; try++ {
+		err := f()
+		if err == nil || !store.IsTransient(err) || try >= p.cfg.Writer.Retries {
+			return err
+		}
+		p.metrics.writerRetries.WithLabelValues(table).Inc()
+		time.Sleep(p.cfg.Writer.RetryDelay * time.Duration(try+1))
+	}
+}
+
+// startWriter runs the writer, the returned func stops it after a last flush.
+func (p *Placer) startWriter() func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.runWriter(stop)
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// runWriter flushes when a batch is full or every flush period until stop is
+// closed, then flushes what is left.
+func (p *Placer) runWriter(stop <-chan struct{}) {
+	var tick <-chan time.Time
+	if p.cfg.Writer.FlushPeriod > 0 {
+		t := time.NewTicker(p.cfg.Writer.FlushPeriod)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-p.writer.kick:
+			p.Flush()
+		case <-tick:
+			p.Flush()
+		case <-stop:
+			p.Flush()
+			return
+		}
+	}
+}
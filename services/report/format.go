@@ -0,0 +1,115 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+var columns = []string{"market", "count", "filled", "volume", "realized_pnl", "expected_profit", "fees",
+	"heals", "healed", "heal_rate", "avg_heal_time", "re_heals"}
+
+func (st *MarketStat) row() []string {
+	return []string{
+		st.Market,
+		strconv.FormatInt(st.Count, 10),
+		strconv.FormatInt(st.Filled, 10),
+		st.Volume.StringFixed(2),
+		st.RealizedPnl.StringFixed(4),
+		st.ExpectedProfit.StringFixed(4),
+		st.Fees.StringFixed(4),
+		strconv.FormatInt(st.Heals, 10),
+		strconv.FormatInt(st.Healed, 10),
+		st.HealRate.String(),
+		st.AvgHealTime.Round(time.Millisecond).String(),
+		strconv.FormatInt(st.ReHeals, 10),
+	}
+}
+
+func (rep *Report) JSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rep)
+}
+
+// CSV writes one row per market and period plus a total row per period.
+func (rep *Report) CSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write(append([]string{"from", "to"}, columns...))
+	if err != nil {
+		return err
+	}
+	for _, pr := range rep.Periods {
+		period := []string{pr.From.Format(dateLayout), pr.To.Format(dateLayout)}
+		for _, st := range append(pr.Markets, pr.Total) {
+			err = cw.Write(append(period, st.row()...))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func mdRow(w io.Writer, cells []string) {
+	fmt.Fprint(w, "|")
+	for _, c := range cells {
+		fmt.Fprintf(w, " %s |", c)
+	}
+	fmt.Fprintln(w)
+}
+
+func mdTable(w io.Writer, list []*MarketStat) {
+	mdRow(w, columns)
+	sep := make([]string, len(columns))
+	for i := range sep {
+		sep[i] = "---"
+	}
+	mdRow(w, sep)
+	for _, st := range list {
+		mdRow(w, st.row())
+	}
+	fmt.Fprintln(w)
+}
+
+func (rep *Report) Markdown(w io.Writer) error {
+	var paper string
+	if rep.Paper {
+		paper = " (paper)"
+	}
+	fmt.Fprintf(w, "# Report %s - %s by %s%s\n\n", rep.From.Format(time.RFC3339), rep.To.Format(time.RFC3339), rep.Period, paper)
+	for _, pr := range rep.Periods {
+		fmt.Fprintf(w, "## %s - %s\n\n", pr.From.Format(dateLayout), pr.To.Format(dateLayout))
+		mdTable(w, []*MarketStat{pr.Total})
+		if len(pr.Markets) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "### Top\n\n")
+		mdTable(w, pr.Top)
+		fmt.Fprintf(w, "### Bottom\n\n")
+		mdTable(w, pr.Bottom)
+		fmt.Fprintf(w, "### Markets\n\n")
+		mdTable(w, pr.Markets)
+	}
+	return nil
+}
+
+// Write writes the report in format md, csv or json.
+func (rep *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case "md":
+		return rep.Markdown(w)
+	case "csv":
+		return rep.CSV(w)
+	case "json":
+		return rep.JSON(w)
+	default:
+		return fmt.Errorf("unknown_format: %s", format)
+	}
+}
@@ -0,0 +1,189 @@
+package report
+
+import (
+	"fmt"
+	"github.com/aibotsoft/crypto-surebet/pkg/store"
+	"github.com/aibotsoft/crypto-surebet/services/placer"
+	"github.com/shopspring/decimal"
+	"sort"
+	"time"
+)
+
+// Source is the part of the store the report reads, *store.Store or *store.Memory.
+type Source = placer.CycleSource
+
+type Period string
+
+const (
+	Day  Period = "day"
+	Week Period = "week"
+)
+
+func ParsePeriod(s string) (Period, error) {
+	switch Period(s) {
+	case Day, Week:
+		return Period(s), nil
+	default:
+		return "", fmt.Errorf("unknown_period: %s", s)
+	}
+}
+
+// start returns the utc start of the period holding t, weeks start on monday.
+func (p Period) start(t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if p == Week {
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	}
+	return day
+}
+
+func (p Period) next(t time.Time) time.Time {
+	if p == Week {
+		return t.AddDate(0, 0, 7)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+type MarketStat struct {
+	Market         string          `json:"market"`
+	Count          int64           `json:"count"`
+	Filled         int64           `json:"filled"`
+	Volume         decimal.Decimal `json:"volume"`
+	RealizedPnl    decimal.Decimal `json:"realized_pnl"`
+	ExpectedProfit decimal.Decimal `json:"expected_profit"`
+	Fees           decimal.Decimal `json:"fees"`
+	Heals          int64           `json:"heals"`
+	Healed         int64           `json:"healed"`
+	HealRate       decimal.Decimal `json:"heal_rate"`
+	AvgHealTime    time.Duration   `json:"avg_heal_time"`
+	ReHeals        int64           `json:"re_heals"`
+	healTime       time.Duration
+}
+
+type PeriodReport struct {
+	From    time.Time     `json:"from"`
+	To      time.Time     `json:"to"`
+	Total   *MarketStat   `json:"total"`
+	Markets []*MarketStat `json:"markets"`
+	Top     []*MarketStat `json:"top"`
+	Bottom  []*MarketStat `json:"bottom"`
+}
+
+type Report struct {
+	From    time.Time       `json:"from"`
+	To      time.Time       `json:"to"`
+	Period  Period          `json:"period"`
+	Paper   bool            `json:"paper"`
+	Periods []*PeriodReport `json:"periods"`
+}
+
+// cycle is a surebet with its realized pnl and the time its heal took, zero when
+// not healed.
+type cycle struct {
+	pnl      store.PnlCycle
+	healed   bool
+	healTime time.Duration
+}
+
+// Build reports the surebets placed from from to to by period, the paper ones when
+// paper is set and the live ones otherwise. Top and bottom keep the n markets with
+// the highest and lowest realized pnl.
+func Build(src Source, from time.Time, to time.Time, period Period, paper bool, n int) (*Report, error) {
+	cycles, err := loadCycles(src, from, to, paper)
+	if err != nil {
+		return nil, err
+	}
+	rep := &Report{From: from, To: to, Period: period, Paper: paper}
+	i := 0
+	for start := period.start(from); start.Before(to); start = period.next(start) {
+		end := period.next(start)
+		pr := &PeriodReport{From: start, To: end, Total: &MarketStat{Market: "total"}}
+		stats := make(map[string]*MarketStat)
+		for ; i < len(cycles) && cycles[i].pnl.ID < end.UnixNano(); i++ {
+			c := cycles[i]
+			st, ok := stats[c.pnl.Market]
+			if !ok {
+				st = &MarketStat{Market: c.pnl.Market}
+				stats[c.pnl.Market] = st
+			}
+			st.add(c)
+			pr.Total.add(c)
+		}
+		for _, st := range stats {
+			st.done()
+			pr.Markets = append(pr.Markets, st)
+		}
+		pr.Total.done()
+		sort.Slice(pr.Markets, func(i, j int) bool {
+			if pr.Markets[i].RealizedPnl.Equal(pr.Markets[j].RealizedPnl) {
+				return pr.Markets[i].Market < pr.Markets[j].Market
+			}
+			return pr.Markets[i].RealizedPnl.GreaterThan(pr.Markets[j].RealizedPnl)
+		})
+		pr.Top, pr.Bottom = topBottom(pr.Markets, n)
+		rep.Periods = append(rep.Periods, pr)
+	}
+	return rep, nil
+}
+
+func topBottom(sorted []*MarketStat, n int) ([]*MarketStat, []*MarketStat) {
+	if n > len(sorted) {
+		n = len(sorted)
+	}
+	bottom := make([]*MarketStat, 0, n)
+	for i := len(sorted) - 1; i >= len(sorted)-n; i-- {
+		bottom = append(bottom, sorted[i])
+	}
+	return sorted[:n], bottom
+}
+
+func (st *MarketStat) add(c cycle) {
+	st.Count++
+	if c.pnl.BetSize.IsPositive() {
+		st.Filled++
+		st.Heals++
+	}
+	if c.healed {
+		st.Healed++
+		st.healTime += c.healTime
+	}
+	st.Volume = st.Volume.Add(c.pnl.BetValue)
+	st.RealizedPnl = st.RealizedPnl.Add(c.pnl.RealizedPnl)
+	st.ExpectedProfit = st.ExpectedProfit.Add(c.pnl.ExpectedProfit)
+	st.Fees = st.Fees.Add(c.pnl.Fees)
+	st.ReHeals += int64(c.pnl.ReHeals)
+}
+
+func (st *MarketStat) done() {
+	if st.Heals > 0 {
+		st.HealRate = decimal.NewFromInt(st.Healed).Div(decimal.NewFromInt(st.Heals)).Round(4)
+	}
+	if st.Healed > 0 {
+		st.AvgHealTime = st.healTime / time.Duration(st.Healed)
+	}
+}
+
+// loadCycles builds the pnl cycles of placed surebets in [from, to) ordered by id.
+func loadCycles(src Source, from time.Time, to time.Time, paper bool) ([]cycle, error) {
+	data, err := placer.LoadCycles(src, from.UnixNano()-1, to.UnixNano()-1, paper)
+	if err != nil {
+		return nil, err
+	}
+	cycles := make([]cycle, 0, len(data))
+	for _, d := range data {
+		var lastHealFill time.Time
+		for _, f := range d.Fills {
+			if f.OrderID != d.Surebet.OrderID && f.Time.After(lastHealFill) {
+				lastHealFill = f.Time
+			}
+		}
+		c := cycle{pnl: placer.BuildCycle(d.Surebet, d.Heal, d.Fills)}
+		if c.pnl.Closed && !lastHealFill.IsZero() {
+			c.healed = true
+			c.healTime = lastHealFill.Sub(time.Unix(0, d.Heal.Start))
+		}
+		cycles = append(cycles, c)
+	}
+	return cycles, nil
+}
@@ -0,0 +1,123 @@
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aibotsoft/crypto-surebet/pkg/store"
+	"github.com/shopspring/decimal"
+)
+
+// addCycle saves a buy bet filled at 100 and its heal filled at healPrice after
+// healTime, a zero healTime leaves the heal unfilled.
+func addCycle(mem *store.Memory, at time.Time, market string, healPrice float64, healTime time.Duration) {
+	id := at.UnixNano()
+	mem.SaveSurebet(&store.Surebet{
+		ID:          id,
+		OrderID:     id,
+		PlaceParams: store.PlaceParamsEmb{Market: market, Side: store.SideBuy},
+	})
+	healOrder := &store.Order{ID: id + 1, Market: market, Side: store.SideSell}
+	mem.SaveHeal(&store.Heal{
+		ID:          id,
+		Start:       id,
+		FilledSize:  decimal.NewFromInt(1),
+		MinSize:     decimal.NewFromFloat(0.1),
+		ProfitPart:  decimal.NewFromFloat(0.1),
+		PlaceParams: store.PlaceParamsEmb{Market: market, Side: store.SideSell},
+		Orders:      []*store.Order{healOrder},
+	})
+	fill := func(fillID int64, orderID int64, side store.Side, price float64, t time.Time) {
+		mem.SaveFills(&store.Fills{ID: fillID, OrderID: orderID, Market: market, Side: side,
+			Price: price, Size: 1, Fee: 0.01, FeeCurrency: "USD", QuoteCurrency: "USD", Time: t})
+	}
+	fill(id, id, store.SideBuy, 100, at)
+	if healTime > 0 {
+		fill(id+1, id+1, store.SideSell, healPrice, at.Add(healTime))
+	}
+}
+
+func TestBuild(t *testing.T) {
+	mem := store.NewMemory()
+	day := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	addCycle(mem, day.Add(time.Hour), "BTC/USD", 101, time.Second)
+	addCycle(mem, day.Add(2*time.Hour), "ETH/USD", 99, 3*time.Second)
+	addCycle(mem, day.Add(26*time.Hour), "BTC/USD", 0, 0)
+
+	rep, err := Build(mem, day, day.Add(48*time.Hour), Day, false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Periods) != 2 {
+		t.Fatalf("periods %d", len(rep.Periods))
+	}
+	first := rep.Periods[0].Total
+	// 1 - 1 - 4 fees of 0.01
+	if first.Count != 2 || first.Healed != 2 || !first.RealizedPnl.Equal(decimal.NewFromFloat(-0.04)) {
+		t.Fatalf("first day %+v", first)
+	}
+	if first.AvgHealTime != 2*time.Second || !first.ExpectedProfit.Equal(decimal.NewFromFloat(0.2)) {
+		t.Fatalf("first day heal %+v", first)
+	}
+	if top := rep.Periods[0].Top; len(top) != 1 || top[0].Market != "BTC/USD" {
+		t.Fatalf("top %+v", top)
+	}
+	if bottom := rep.Periods[0].Bottom; len(bottom) != 1 || bottom[0].Market != "ETH/USD" {
+		t.Fatalf("bottom %+v", bottom)
+	}
+	second := rep.Periods[1].Total
+	if second.Count != 1 || second.Healed != 0 || !second.HealRate.IsZero() {
+		t.Fatalf("second day %+v", second)
+	}
+
+	week, err := Build(mem, day, day.Add(48*time.Hour), Week, false, 1)
+	if err != nil || len(week.Periods) != 1 || week.Periods[0].Total.Count != 3 {
+		t.Fatalf("week %+v %v", week, err)
+	}
+	if !week.Periods[0].From.Equal(time.Date(2022, 5, 30, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("week start %v", week.Periods[0].From)
+	}
+
+	var buf bytes.Buffer
+	if err := rep.Write(&buf, "csv"); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	// header, two markets and total, one market and total
+	if err != nil || len(rows) != 6 || rows[0][2] != "market" {
+		t.Fatalf("csv %v %v", rows, err)
+	}
+	buf.Reset()
+	if err := rep.Write(&buf, "md"); err != nil || !strings.Contains(buf.String(), "| BTC/USD |") {
+		t.Fatalf("md %s %v", buf.String(), err)
+	}
+	if err := rep.Write(&buf, "xml"); err == nil {
+		t.Fatal("unknown format accepted")
+	}
+}
+
+func TestBuildPaper(t *testing.T) {
+	mem := store.NewMemory()
+	day := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	addCycle(mem, day.Add(time.Hour), "BTC/USD", 101, time.Second)
+	addCycle(mem, day.Add(2*time.Hour), "ETH/USD", 99, time.Second)
+	sb, err := mem.SelectSurebets(0, day.Add(24*time.Hour).UnixNano(), 10)
+	if err != nil || len(sb) != 2 {
+		t.Fatalf("surebets %v %v", sb, err)
+	}
+	paper := sb[1]
+	paper.Paper = true
+	mem.SaveSurebet(&paper)
+
+	live, err := Build(mem, day, day.Add(24*time.Hour), Day, false, 1)
+	if err != nil || live.Periods[0].Total.Count != 1 || live.Periods[0].Markets[0].Market != "BTC/USD" {
+		t.Fatalf("live %+v %v", live, err)
+	}
+	rep, err := Build(mem, day, day.Add(24*time.Hour), Day, true, 1)
+	if err != nil || !rep.Paper || rep.Periods[0].Total.Count != 1 || rep.Periods[0].Markets[0].Market != "ETH/USD" {
+		t.Fatalf("paper %+v %v", rep, err)
+	}
+}